@@ -0,0 +1,4 @@
+// Package messagev1 holds the gRPC API generated from message.proto.
+package messagev1
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative message/v1/message.proto
@@ -1,11 +1,129 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"messagio_testsuite/config"
 	"messagio_testsuite/internal/app"
+	"os"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
 )
 
-const configPath = "config/config.yaml"
+const defaultConfigPath = "config/config.yaml"
+
+const usage = `Usage: app [-config path] <command> [arguments]
+
+Commands:
+  serve [-migrate]        run what app.role configures, -migrate applies
+                          pending migrations first
+  worker                  run as a worker whatever app.role configures
+  migrate up              apply all pending migrations
+  migrate down [N]        roll back the last N migrations, 1 by default
+  migrate status          print the applied and the latest migration
+  migrate force VERSION   record VERSION as applied and clear the dirty flag
+                          after fixing a failed migration
+
+Flags:
+`
 
 func main() {
-	app.Run(configPath)
+	flags := flag.NewFlagSet("app", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath, "path to the config file")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	command, args := flags.Arg(0), flags.Args()[1:]
+	switch command {
+	case "serve", "worker", "migrate":
+	default:
+		fmt.Fprintf(flags.Output(), "unknown command %q\n\n", command)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.NewConfig(*configPath)
+	if err != nil {
+		logrus.Fatalf("Config error: %s", err)
+	}
+
+	app.SetLogrus(cfg.Log.Level)
+
+	switch command {
+	case "serve":
+		serve(cfg, args)
+	case "worker":
+		app.Work(cfg)
+	case "migrate":
+		if err := runMigrate(cfg, args); err != nil {
+			logrus.Fatalf("Migrate: %v", err)
+		}
+	}
+}
+
+func serve(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	migrate := flags.Bool("migrate", false, "apply pending migrations before serving")
+	_ = flags.Parse(args)
+
+	if *migrate {
+		if err := runMigrate(cfg, []string{"up"}); err != nil {
+			logrus.Fatalf("Migrate: %v", err)
+		}
+	}
+
+	app.Serve(cfg)
+}
+
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("missing subcommand, one of up, down, status or force")
+	}
+
+	m, err := app.NewMigrator(cfg.PG)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	switch args[0] {
+	case "up":
+		return m.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+		return m.Down(steps)
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("version: %d\ndirty: %t\nlatest: %d\n", status.Version, status.Dirty, status.Latest)
+		return nil
+	case "force":
+		if len(args) < 2 {
+			return errors.New("missing version to force")
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		return m.Force(version)
+	default:
+		return fmt.Errorf("unknown subcommand %q", args[0])
+	}
 }
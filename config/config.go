@@ -10,22 +10,38 @@ import (
 
 type (
 	Config struct {
-		App    `yaml:"app"`
-		Server `yaml:"server"`
-		Log    `yaml:"log"`
-		PG     `yaml:"postgres"`
-		Kafka  `yaml:"kafka"`
+		App         `yaml:"app"`
+		Server      `yaml:"server"`
+		GRPC        `yaml:"grpc"`
+		Log         `yaml:"log"`
+		PG          `yaml:"postgres"`
+		Kafka       `yaml:"kafka"`
+		Outbox      `yaml:"outbox"`
+		Idempotency `yaml:"idempotency"`
+		Events      `yaml:"events"`
+		Auth        `yaml:"auth"`
+		RateLimit   `yaml:"rate_limit"`
+		Tracing     `yaml:"tracing"`
+		Health      `yaml:"health"`
 	}
 
 	App struct {
 		Name    string `env-required:"true" yaml:"name" env:"APP_NAME"`
 		Version string `env-required:"true" yaml:"version" env:"APP_VERSION"`
+		// Role is api, worker or all, see entity.Role.
+		Role string `env-default:"all" yaml:"role" env:"APP_ROLE"`
+
+		ShutdownTimeout time.Duration `env-default:"30s" yaml:"shutdown_timeout" env:"APP_SHUTDOWN_TIMEOUT"`
 	}
 
 	Server struct {
 		Port string `env-required:"true" yaml:"port" env:"SERVER_PORT"`
 	}
 
+	GRPC struct {
+		Port string `env-default:":9090" yaml:"port" env:"GRPC_PORT"`
+	}
+
 	Log struct {
 		Level string `env-required:"true" yaml:"level" env:"LOG_LEVEL"`
 	}
@@ -38,9 +54,91 @@ type (
 	}
 
 	Kafka struct {
-		Brokers []string `env-required:"true" yaml:"brokers" env:"KAFKA_BROKERS" env-separator:","`
-		Topic   string   `env-required:"true" yaml:"topic" env:"KAFKA_TOPIC"`
-		GroupID string   `env-required:"true" yaml:"group_id" env:"KAFKA_GROUP_ID"`
+		// Driver is kafka or memory, only kafka needs Brokers.
+		Driver           string   `env-default:"kafka" yaml:"driver" env:"KAFKA_DRIVER"`
+		MemoryPartitions int      `env-default:"4" yaml:"memory_partitions" env:"KAFKA_MEMORY_PARTITIONS"`
+		Brokers          []string `yaml:"brokers" env:"KAFKA_BROKERS" env-separator:","`
+		Topic            string   `env-required:"true" yaml:"topic" env:"KAFKA_TOPIC"`
+		GroupID          string   `env-required:"true" yaml:"group_id" env:"KAFKA_GROUP_ID"`
+		// The topic, its retry topics and the dead-letter topic are created
+		// on startup with TopicPartitions and TopicReplicationFactor unless
+		// they exist.
+		TopicPartitions        int `env-default:"4" yaml:"topic_partitions" env:"KAFKA_TOPIC_PARTITIONS"`
+		TopicReplicationFactor int `env-default:"1" yaml:"topic_replication_factor" env:"KAFKA_TOPIC_REPLICATION_FACTOR"`
+
+		// at_least_once delivery requires a DeadLetterTopic.
+		Delivery        string        `env-default:"at_least_once" yaml:"delivery" env:"KAFKA_DELIVERY"`
+		CommitInterval  time.Duration `env-default:"1s" yaml:"commit_interval" env:"KAFKA_COMMIT_INTERVAL"`
+		CommitBatchSize int           `env-default:"100" yaml:"commit_batch_size" env:"KAFKA_COMMIT_BATCH_SIZE"`
+		Workers         int           `env-default:"4" yaml:"workers" env:"KAFKA_WORKERS"`
+		QueueDepth      int           `env-default:"100" yaml:"queue_depth" env:"KAFKA_QUEUE_DEPTH"`
+		DrainTimeout    time.Duration `env-default:"10s" yaml:"drain_timeout" env:"KAFKA_DRAIN_TIMEOUT"`
+		DeadLetterTopic string        `yaml:"dead_letter_topic" env:"KAFKA_DEAD_LETTER_TOPIC"`
+		Retry           KafkaRetry    `yaml:"retry"`
+	}
+
+	KafkaRetry struct {
+		Attempts       int               `env-default:"3" yaml:"attempts" env:"KAFKA_RETRY_ATTEMPTS"`
+		InitialBackoff time.Duration     `env-default:"200ms" yaml:"initial_backoff" env:"KAFKA_RETRY_INITIAL_BACKOFF"`
+		MaxBackoff     time.Duration     `env-default:"5s" yaml:"max_backoff" env:"KAFKA_RETRY_MAX_BACKOFF"`
+		Multiplier     float64           `env-default:"2" yaml:"multiplier" env:"KAFKA_RETRY_MULTIPLIER"`
+		Topics         []KafkaRetryTopic `yaml:"topics"`
+	}
+
+	KafkaRetryTopic struct {
+		Topic string        `yaml:"topic"`
+		Delay time.Duration `yaml:"delay"`
+	}
+
+	Outbox struct {
+		PollInterval time.Duration `env-default:"1s" yaml:"poll_interval" env:"OUTBOX_POLL_INTERVAL"`
+		BatchSize    int           `env-default:"100" yaml:"batch_size" env:"OUTBOX_BATCH_SIZE"`
+		MinBackoff   time.Duration `env-default:"1s" yaml:"min_backoff" env:"OUTBOX_MIN_BACKOFF"`
+		MaxBackoff   time.Duration `env-default:"1m" yaml:"max_backoff" env:"OUTBOX_MAX_BACKOFF"`
+		Lease        time.Duration `env-default:"30s" yaml:"lease" env:"OUTBOX_LEASE"`
+		Retention    time.Duration `env-default:"24h" yaml:"retention" env:"OUTBOX_RETENTION"`
+	}
+
+	Idempotency struct {
+		TTL   time.Duration `env-default:"24h" yaml:"ttl" env:"IDEMPOTENCY_TTL"`
+		Lease time.Duration `env-default:"1m" yaml:"lease" env:"IDEMPOTENCY_LEASE"`
+	}
+
+	Events struct {
+		LogSize          int           `env-default:"1000" yaml:"log_size" env:"EVENTS_LOG_SIZE"`
+		SubscriberBuffer int           `env-default:"64" yaml:"subscriber_buffer" env:"EVENTS_SUBSCRIBER_BUFFER"`
+		PollInterval     time.Duration `env-default:"250ms" yaml:"poll_interval" env:"EVENTS_POLL_INTERVAL"`
+	}
+
+	Auth struct {
+		// BootstrapKey is stored as an admin API key on startup, so the first
+		// keys can be created through the API. It takes mk_ and at least 32
+		// random characters.
+		BootstrapKey string `yaml:"bootstrap_key" env:"AUTH_BOOTSTRAP_KEY"`
+		JWKSFile     string `yaml:"jwks_file" env:"AUTH_JWKS_FILE"`
+		JWTIssuer    string `yaml:"jwt_issuer" env:"AUTH_JWT_ISSUER"`
+		JWTAudience  string `yaml:"jwt_audience" env:"AUTH_JWT_AUDIENCE"`
+	}
+
+	RateLimit struct {
+		Rate       float64 `env-default:"10" yaml:"rate" env:"RATE_LIMIT_RATE"`
+		Burst      int     `env-default:"20" yaml:"burst" env:"RATE_LIMIT_BURST"`
+		DailyQuota int64   `env-default:"100000" yaml:"daily_quota" env:"RATE_LIMIT_DAILY_QUOTA"`
+	}
+
+	Tracing struct {
+		// Exporter is none, otlp, stdout or file.
+		Exporter     string  `env-default:"none" yaml:"exporter" env:"TRACING_EXPORTER"`
+		OTLPEndpoint string  `env-default:"localhost:4317" yaml:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT"`
+		OTLPInsecure bool    `env-default:"true" yaml:"otlp_insecure" env:"TRACING_OTLP_INSECURE"`
+		File         string  `env-default:"/logs/traces.json" yaml:"file" env:"TRACING_FILE"`
+		SampleRatio  float64 `env-default:"1" yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+	}
+
+	Health struct {
+		Timeout          time.Duration `env-default:"2s" yaml:"timeout" env:"HEALTH_TIMEOUT"`
+		OutboxMaxPending int64         `env-default:"10000" yaml:"outbox_max_pending" env:"HEALTH_OUTBOX_MAX_PENDING"`
+		OutboxMaxAge     time.Duration `env-default:"5m" yaml:"outbox_max_age" env:"HEALTH_OUTBOX_MAX_AGE"`
 	}
 )
 
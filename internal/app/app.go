@@ -4,22 +4,28 @@ import (
 	"context"
 	"fmt"
 	"messagio_testsuite/config"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/metrics"
 	"messagio_testsuite/internal/repo"
-	"messagio_testsuite/internal/repo/pgdb"
+	grpcv1 "messagio_testsuite/internal/routes/grpc/v1"
 	v1 "messagio_testsuite/internal/routes/http/v1"
+	routeerrs "messagio_testsuite/internal/routes/http/v1/route_errors"
 	"messagio_testsuite/internal/service"
+	"messagio_testsuite/internal/tracing"
+	"messagio_testsuite/pkg/jwks"
 	"messagio_testsuite/pkg/kafka"
 	"messagio_testsuite/pkg/postgres"
+	"messagio_testsuite/pkg/shutdown"
+	"net"
 	"net/http"
-	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/go-playground/validator"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 )
 
 type CustomValidator struct {
@@ -30,51 +36,173 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 	return cv.validator.Struct(i)
 }
 
-func Run(configPath string) {
+// Serve runs what the configured role does until SIGINT or SIGTERM.
+func Serve(cfg *config.Config) {
+	run(cfg, entity.Role(cfg.App.Role))
+}
 
-	cfg, err := config.NewConfig(configPath)
-	if err != nil {
-		logrus.Fatalf("Config error: %s", err)
+// Work runs as a worker whatever the configured role is.
+func Work(cfg *config.Config) {
+	run(cfg, entity.RoleWorker)
+}
+
+// run serves the HTTP and gRPC APIs when the role is api or all, and relays
+// the outbox and processes messages when it is worker or all. The HTTP server
+// is started for the probes and metrics either way.
+func run(cfg *config.Config, role entity.Role) {
+	if !role.Valid() {
+		logrus.Fatalf("Unknown role %q, expected api, worker or all", role)
 	}
+	logrus.Infof("Running as %s", role)
 
-	SetLogrus(cfg.Log.Level)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	pg, err := postgres.New(cfg.PG.URL, postgres.MaxPoolSize(cfg.PG.MaxPoolSize))
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		ServiceName:    cfg.App.Name,
+		ServiceVersion: cfg.App.Version,
+		Exporter:       cfg.Tracing.Exporter,
+		OTLPEndpoint:   cfg.Tracing.OTLPEndpoint,
+		OTLPInsecure:   cfg.Tracing.OTLPInsecure,
+		File:           cfg.Tracing.File,
+		SampleRatio:    cfg.Tracing.SampleRatio,
+	})
 	if err != nil {
-		logrus.Fatal(fmt.Errorf("app - Run - pgdb.NewServices: %w", err))
+		logrus.Fatalf("Failed to set up tracing: %v", err)
 	}
-	defer pg.Close()
 
-	consumer := kafka.NewKafkaConsumer(cfg.Kafka.Brokers, cfg.Kafka.GroupID, cfg.Kafka.Topic)
+	pg, err := postgres.New(cfg.PG.URL, postgres.MaxPoolSize(cfg.PG.MaxPoolSize), postgres.Tracer(tracing.PgxTracer{}))
 	if err != nil {
-		logrus.Fatalf("Failed to initialize Kafka consumer: %v", err)
+		logrus.Fatal(fmt.Errorf("app - Run - pgdb.NewServices: %w", err))
+	}
+
+	authCfg := service.AuthConfig{
+		Issuer:   cfg.Auth.JWTIssuer,
+		Audience: cfg.Auth.JWTAudience,
+	}
+	if role.ServesAPI() && cfg.Auth.JWKSFile != "" {
+		authCfg.JWKS, err = jwks.Load(cfg.Auth.JWKSFile)
+		if err != nil {
+			logrus.Fatalf("Failed to load JWKS: %v", err)
+		}
+		logrus.Infof("Loaded %d key(s) for bearer tokens from %s", authCfg.JWKS.Len(), cfg.Auth.JWKSFile)
 	}
-	defer consumer.Close()
 
-	producer := kafka.NewKafkaProducer(cfg.Kafka.Brokers, cfg.Kafka.Topic)
+	schemaVersion, err := latestMigration()
 	if err != nil {
-		logrus.Fatalf("Failed to initialize Kafka producer: %v", err)
+		logrus.Fatalf("Failed to read migrations: %v", err)
 	}
-	defer producer.Close()
 
-	messageRepo := pgdb.NewMessageRepo(pg)
+	var (
+		producer kafka.Producer
+		consumer kafka.Consumer
+	)
+	if role.ProcessesMessages() {
+		producer, consumer, err = newBroker(cfg.Kafka)
+		if err != nil {
+			logrus.Fatalf("Failed to initialize message broker: %v", err)
+		}
+		metrics.Registry.MustRegister(metrics.NewKafkaCollector(producer, consumer))
+	}
 
 	services := service.NewServices(service.ServicesDependencies{
-		Repos:         &repo.Repositories{Message: messageRepo},
+		Role:          role,
+		Repos:         repo.NewRepositories(pg),
 		KafkaProducer: producer,
 		KafkaConsumer: consumer,
+		Outbox: service.OutboxRelayConfig{
+			PollInterval: cfg.Outbox.PollInterval,
+			BatchSize:    cfg.Outbox.BatchSize,
+			MinBackoff:   cfg.Outbox.MinBackoff,
+			MaxBackoff:   cfg.Outbox.MaxBackoff,
+			Lease:        cfg.Outbox.Lease,
+			Retention:    cfg.Outbox.Retention,
+		},
+		Idempotency: service.IdempotencyConfig{
+			TTL:   cfg.Idempotency.TTL,
+			Lease: cfg.Idempotency.Lease,
+		},
+		Events: service.EventsConfig{
+			LogSize:          cfg.Events.LogSize,
+			SubscriberBuffer: cfg.Events.SubscriberBuffer,
+			PollInterval:     cfg.Events.PollInterval,
+		},
+		Auth: authCfg,
+		RateLimit: service.RateLimitConfig{
+			Rate:       cfg.RateLimit.Rate,
+			Burst:      cfg.RateLimit.Burst,
+			DailyQuota: cfg.RateLimit.DailyQuota,
+		},
+		Health: service.HealthConfig{
+			Timeout:          cfg.Health.Timeout,
+			OutboxMaxPending: cfg.Health.OutboxMaxPending,
+			OutboxMaxAge:     cfg.Health.OutboxMaxAge,
+			SchemaVersion:    schemaVersion,
+		},
 	})
 
+	metrics.Registry.MustRegister(metrics.NewPoolCollector(pg.Pool))
+
+	if role.ServesAPI() && cfg.Auth.BootstrapKey != "" {
+		err := services.Auth.EnsureAPIKey(ctx, cfg.Auth.BootstrapKey, service.CreateAPIKeyInput{
+			Name:   "bootstrap",
+			Scopes: []entity.Scope{entity.ScopeMessagesAdmin},
+		})
+		if err != nil {
+			logrus.Fatalf("Failed to store bootstrap API key: %v", err)
+		}
+	}
+
+	consumerCtx, stopConsumer := context.WithCancel(context.Background())
+	defer stopConsumer()
+	consumerDone := make(chan struct{})
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	relayDone := make(chan struct{})
+	eventsCtx, stopEvents := context.WithCancel(context.Background())
+	defer stopEvents()
+	eventsDone := make(chan struct{})
+	if role.ServesAPI() {
+		go func() {
+			defer close(eventsDone)
+			services.Events.Run(eventsCtx)
+		}()
+	}
+	if role.ProcessesMessages() {
+		go func() {
+			defer close(consumerDone)
+			if err := services.Processor.Run(consumerCtx); err != nil {
+				logrus.Errorf("Message processor stopped: %v", err)
+				stop()
+			}
+		}()
+
+		go func() {
+			defer close(relayDone)
+			services.Outbox.Run(relayCtx)
+		}()
+	}
+
 	e := echo.New()
+	e.HTTPErrorHandler = routeerrs.ErrorHandler
+	e.Use(middleware.RequestID())
+	e.Use(tracing.HTTPMiddleware())
+	e.Use(metrics.HTTPMiddleware())
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	e.Validator = &CustomValidator{validator: validator.New()}
 
-	e.GET("/health", func(c echo.Context) error {
-		return c.JSON(http.StatusOK, map[string]string{"status": "all systems operational"})
-	})
+	e.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+	v1.NewHealthRoutes(e, services.Health)
 
-	v1.NewMessageRoutes(e.Group("/api/v1"), services.Message)
+	if role.ServesAPI() {
+		api := e.Group("/api/v1")
+		v1.NewMessageRoutes(api, services.Message, services.Idempotency, services.Events, services.Auth, services.RateLimit)
+		v1.NewAPIKeyRoutes(api, services.Auth, services.Auth)
+		v1.NewOpenAPIRoutes(api)
+		// Event streams never finish on their own, end them when shutdown starts.
+		e.Server.RegisterOnShutdown(services.Events.Close)
+	}
 
 	addr := cfg.Server.Port
 	logrus.Infof("Starting server on %s...", addr)
@@ -84,17 +212,87 @@ func Run(configPath string) {
 		}
 	}()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	var grpcServer *grpc.Server
+	if role.ServesAPI() {
+		grpcServer = newGRPCServer(services)
+		lis, err := net.Listen("tcp", cfg.GRPC.Port)
+		if err != nil {
+			logrus.Fatalf("Failed to listen for gRPC on %s: %v", cfg.GRPC.Port, err)
+		}
+		logrus.Infof("Starting gRPC server on %s...", cfg.GRPC.Port)
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				logrus.Fatalf("Failed to start gRPC server: %v", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
 	logrus.Info("Shutting down server...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// Stop taking requests first, then let the consumer finish and commit what
+	// it has in flight before the producer and the pool it may still use go away.
+	sequence := shutdown.NewSequence(cfg.App.ShutdownTimeout)
+	sequence.Add("http server", e.Shutdown)
+	if grpcServer != nil {
+		sequence.Add("grpc server", func(ctx context.Context) error {
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+			if err := shutdown.Wait(stopped)(ctx); err != nil {
+				grpcServer.Stop()
+				return err
+			}
+			return nil
+		})
+	}
+	if role.ServesAPI() {
+		sequence.Add("event log", func(ctx context.Context) error {
+			stopEvents()
+			return shutdown.Wait(eventsDone)(ctx)
+		})
+	}
+	if role.ProcessesMessages() {
+		sequence.Add("consumer", func(ctx context.Context) error {
+			stopConsumer()
+			if err := shutdown.Wait(consumerDone)(ctx); err != nil {
+				consumer.Close()
+				return err
+			}
+			return nil
+		})
+		sequence.Add("outbox relay", func(ctx context.Context) error {
+			stopRelay()
+			return shutdown.Wait(relayDone)(ctx)
+		})
+		sequence.Add("producer", func(context.Context) error {
+			producer.Close()
+			return nil
+		})
+	}
+	sequence.Add("postgres", func(context.Context) error {
+		pg.Close()
+		return nil
+	})
+	sequence.Add("tracing", shutdownTracing)
 
-	if err := e.Shutdown(ctx); err != nil {
-		logrus.Fatalf("Server forced to shutdown: %v", err)
+	if err := sequence.Run(context.Background()); err != nil {
+		logrus.Errorf("Shutdown finished with errors: %v", err)
+		return
 	}
 
 	logrus.Info("Server exiting")
 }
+
+func newGRPCServer(services *service.Services) *grpc.Server {
+	grpcAuth := grpcv1.NewAuthenticator(services.Auth)
+	grpcThrottle := grpcv1.NewThrottler(services.RateLimit)
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor(), grpcAuth.UnaryInterceptor(), grpcThrottle.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(tracing.StreamServerInterceptor(), grpcAuth.StreamInterceptor(), grpcThrottle.StreamInterceptor()),
+	)
+	grpcv1.NewMessageServer(grpcServer, services.Message, services.Events, services.RateLimit)
+	return grpcServer
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"messagio_testsuite/config"
+	"messagio_testsuite/pkg/kafka"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	brokerDriverKafka  = "kafka"
+	brokerDriverMemory = "memory"
+
+	createTopicsTimeout = 10 * time.Second
+)
+
+func newBroker(cfg config.Kafka) (kafka.Producer, kafka.Consumer, error) {
+	delivery := kafka.Delivery(cfg.Delivery)
+	if !delivery.Valid() {
+		return nil, nil, fmt.Errorf("app - newBroker: unknown delivery %q, expected %s or %s", cfg.Delivery, kafka.AtLeastOnce, kafka.AtMostOnce)
+	}
+	// Without a dead-letter topic a record that runs out of retries could
+	// never be committed, and would stop every consumer that reads it.
+	if delivery == kafka.AtLeastOnce && cfg.DeadLetterTopic == "" {
+		return nil, nil, fmt.Errorf("app - newBroker: %s delivery requires a dead-letter topic", kafka.AtLeastOnce)
+	}
+
+	retryTiers := make([]kafka.RetryTier, len(cfg.Retry.Topics))
+	for i, t := range cfg.Retry.Topics {
+		retryTiers[i] = kafka.RetryTier{Topic: t.Topic, Delay: t.Delay}
+	}
+
+	opts := []kafka.ConsumerOption{
+		kafka.WithRetryPolicy(kafka.RetryPolicy{
+			Attempts:        cfg.Retry.Attempts,
+			InitialBackoff:  cfg.Retry.InitialBackoff,
+			MaxBackoff:      cfg.Retry.MaxBackoff,
+			Multiplier:      cfg.Retry.Multiplier,
+			Tiers:           retryTiers,
+			DeadLetterTopic: cfg.DeadLetterTopic,
+		}),
+		kafka.WithDelivery(delivery),
+		kafka.WithCommitInterval(cfg.CommitInterval),
+		kafka.WithCommitBatchSize(cfg.CommitBatchSize),
+		kafka.WithWorkers(cfg.Workers),
+		kafka.WithQueueDepth(cfg.QueueDepth),
+		kafka.WithDrainTimeout(cfg.DrainTimeout),
+	}
+
+	switch cfg.Driver {
+	case brokerDriverKafka:
+		if len(cfg.Brokers) == 0 {
+			return nil, nil, fmt.Errorf("app - newBroker: the %s driver requires brokers", brokerDriverKafka)
+		}
+		createTopics(cfg)
+		producer := kafka.NewKafkaProducer(cfg.Brokers, cfg.Topic)
+		consumer := kafka.NewKafkaConsumer(cfg.Brokers, cfg.GroupID, cfg.Topic, opts...)
+		return producer, consumer, nil
+	case brokerDriverMemory:
+		broker := kafka.NewMemoryBroker(cfg.MemoryPartitions)
+		producer := kafka.NewMemoryProducer(broker, cfg.Topic)
+		consumer := kafka.NewMemoryConsumer(broker, cfg.GroupID, cfg.Topic, opts...)
+		return producer, consumer, nil
+	default:
+		return nil, nil, fmt.Errorf("app - newBroker: unknown driver %q", cfg.Driver)
+	}
+}
+
+// createTopics provisions the topics of cfg. Writers create missing topics as
+// well, with the defaults of the cluster, so a failure is only logged.
+func createTopics(cfg config.Kafka) {
+	topics := []string{cfg.Topic}
+	for _, t := range cfg.Retry.Topics {
+		topics = append(topics, t.Topic)
+	}
+	if cfg.DeadLetterTopic != "" {
+		topics = append(topics, cfg.DeadLetterTopic)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), createTopicsTimeout)
+	defer cancel()
+	if err := kafka.CreateTopics(ctx, cfg.Brokers, cfg.TopicPartitions, cfg.TopicReplicationFactor, topics...); err != nil {
+		logrus.Warnf("Failed to create Kafka topics: %v", err)
+	}
+}
@@ -2,57 +2,133 @@ package app
 
 import (
 	"errors"
+	"fmt"
+	"messagio_testsuite/config"
 	"os"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	log "github.com/sirupsen/logrus"
 )
 
-const (
-	defaultAttempts = 20
-	defaultTimeout  = time.Second
-)
+const migrationsURL = "file://migrations"
 
-func init() {
-	databaseURL, ok := os.LookupEnv("PG_URL")
-	if !ok || len(databaseURL) == 0 {
-		log.Fatalf("migrate: environment variable not declared: PG_URL")
-	}
+// Migrator applies the migrations shipped with the app to the database of the
+// config.
+type Migrator struct {
+	m *migrate.Migrate
+}
+
+type MigrationStatus struct {
+	// Version is 0 before the first migration is applied.
+	Version uint
+	Dirty   bool
+	Latest  uint
+}
 
+func NewMigrator(cfg config.PG) (*Migrator, error) {
 	var (
-		attempts = defaultAttempts
+		attempts = cfg.ConnAttempts
 		err      error
 		m        *migrate.Migrate
 	)
 
-	for attempts > 0 {
-		m, err = migrate.New("file://migrations", databaseURL)
+	for {
+		m, err = migrate.New(migrationsURL, cfg.URL)
 		if err == nil {
-			break
+			return &Migrator{m: m}, nil
 		}
 
-		log.Printf("Migrate: pgdb is trying to connect, attempts left: %d", attempts)
-		time.Sleep(defaultTimeout)
 		attempts--
+		if attempts <= 0 {
+			return nil, fmt.Errorf("app - NewMigrator - migrate.New: %w", err)
+		}
+		log.Printf("Migrate: pgdb is trying to connect, attempts left: %d", attempts)
+		time.Sleep(cfg.ConnTimeout)
 	}
+}
 
+func (m *Migrator) Up() error {
+	err := m.m.Up()
+	if errors.Is(err, migrate.ErrNoChange) {
+		log.Printf("Migrate: no change")
+		return nil
+	}
 	if err != nil {
-		log.Fatalf("Migrate: pgdb connect error: %s", err)
+		return fmt.Errorf("app - Migrator.Up: %w", err)
+	}
+
+	log.Printf("Migrate: up success")
+	return nil
+}
+
+// Down rolls back the last steps migrations.
+func (m *Migrator) Down(steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("app - Migrator.Down: steps must be positive, got %d", steps)
 	}
 
-	err = m.Up()
-	defer func() { _, _ = m.Close() }()
+	err := m.m.Steps(-steps)
 	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		log.Fatalf("Migrate: up error: %s", err)
+		return fmt.Errorf("app - Migrator.Down: %w", err)
 	}
 
-	if errors.Is(err, migrate.ErrNoChange) {
-		log.Printf("Migrate: no change")
-		return
+	log.Printf("Migrate: down success")
+	return nil
+}
+
+func (m *Migrator) Status() (MigrationStatus, error) {
+	latest, err := latestMigration()
+	if err != nil {
+		return MigrationStatus{}, fmt.Errorf("app - Migrator.Status - latestMigration: %w", err)
 	}
 
-	log.Printf("Migrate: up success")
+	version, dirty, err := m.m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return MigrationStatus{}, fmt.Errorf("app - Migrator.Status: %w", err)
+	}
+	return MigrationStatus{Version: version, Dirty: dirty, Latest: latest}, nil
+}
+
+// Force records version as applied and clears the dirty flag without running
+// anything, once a failed migration has been fixed by hand.
+func (m *Migrator) Force(version int) error {
+	if err := m.m.Force(version); err != nil {
+		return fmt.Errorf("app - Migrator.Force: %w", err)
+	}
+
+	log.Printf("Migrate: forced version %d", version)
+	return nil
+}
+
+func (m *Migrator) Close() {
+	_, _ = m.m.Close()
+}
+
+// latestMigration returns the version of the newest migration shipped with the
+// app, the startup probe waits for the database to reach it.
+func latestMigration() (uint, error) {
+	src, err := source.Open(migrationsURL)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	version, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
 }
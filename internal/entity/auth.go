@@ -0,0 +1,53 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Scope string
+
+const (
+	ScopeMessagesWrite Scope = "messages:write"
+	ScopeMessagesRead  Scope = "messages:read"
+	// ScopeMessagesAdmin grants the other scopes as well as processing
+	// messages by hand and managing API keys.
+	ScopeMessagesAdmin Scope = "messages:admin"
+)
+
+func (s Scope) Valid() bool {
+	switch s {
+	case ScopeMessagesWrite, ScopeMessagesRead, ScopeMessagesAdmin:
+		return true
+	}
+	return false
+}
+
+// APIKey is a stored API key. Only the SHA-256 of the secret is kept, Prefix
+// is its first characters so a key can be recognized in listings.
+type APIKey struct {
+	ID        uuid.UUID  `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	KeyHash   string     `json:"-"`
+	Scopes    []Scope    `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Subject is the API key ID or the sub claim of a token.
+	Subject string
+	Scopes  []Scope
+}
+
+func (p Principal) HasScope(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeMessagesAdmin {
+			return true
+		}
+	}
+	return false
+}
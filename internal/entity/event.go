@@ -0,0 +1,28 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MessageEventType string
+
+const (
+	EventCreated      MessageEventType = "created"
+	EventProcessed    MessageEventType = "processed"
+	EventFailed       MessageEventType = "failed"
+	EventDeadLettered MessageEventType = "dead_lettered"
+)
+
+// MessageEvent is a change in a message's life pushed to subscribers. Its ID
+// is the ID of the message transition it reports, so IDs keep growing across
+// restarts and instances.
+type MessageEvent struct {
+	ID        uint64           `json:"id"`
+	Type      MessageEventType `json:"type"`
+	MessageID uuid.UUID        `json:"message_id"`
+	Status    MessageStatus    `json:"status"`
+	Error     string           `json:"error,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+}
@@ -0,0 +1,39 @@
+package entity
+
+import "time"
+
+type HealthStatus string
+
+const (
+	HealthStatusUp   HealthStatus = "up"
+	HealthStatusDown HealthStatus = "down"
+)
+
+// HealthReport is up when all of its components are. Role tells which
+// components are checked.
+type HealthReport struct {
+	Status     HealthStatus      `json:"status"`
+	Role       Role              `json:"role,omitempty"`
+	Components []ComponentHealth `json:"components,omitempty"`
+}
+
+type ComponentHealth struct {
+	Name    string       `json:"name"`
+	Status  HealthStatus `json:"status"`
+	Latency float64      `json:"latency_ms"`
+	Error   string       `json:"error,omitempty"`
+	Details interface{}  `json:"details,omitempty"`
+}
+
+// SchemaVersion is the state golang-migrate keeps in schema_migrations. Dirty
+// means the migration to Version failed halfway.
+type SchemaVersion struct {
+	Version uint `json:"version"`
+	Dirty   bool `json:"dirty"`
+}
+
+type OutboxBacklog struct {
+	Pending int64 `json:"pending"`
+	// OldestAt is the creation time of the oldest pending event, if any.
+	OldestAt *time.Time `json:"oldest_at,omitempty"`
+}
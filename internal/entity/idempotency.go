@@ -0,0 +1,20 @@
+package entity
+
+import "time"
+
+// IdempotencyKey is the stored outcome of a request sent with an
+// Idempotency-Key header. Keys are scoped to the client that sent them.
+// StatusCode is zero while the request is in flight.
+type IdempotencyKey struct {
+	Client      string    `json:"client"`
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	StatusCode  int       `json:"status_code"`
+	Response    []byte    `json:"response"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func (k IdempotencyKey) Completed() bool {
+	return k.StatusCode != 0
+}
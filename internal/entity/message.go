@@ -7,9 +7,17 @@ import (
 )
 
 type Message struct {
-	ID          uuid.UUID  `json:"id"`
-	Message     string     `json:"message"`
-	CreatedAt   time.Time  `json:"created_at"`
-	Processed   bool       `json:"processed"`
-	ProcessedAt *time.Time `json:"processed_at"`
+	ID             uuid.UUID     `json:"id"`
+	Message        string        `json:"message"`
+	CreatedAt      time.Time     `json:"created_at"`
+	Status         MessageStatus `json:"status"`
+	Processed      bool          `json:"processed"`
+	ProcessedAt    *time.Time    `json:"processed_at"`
+	PublishedAt    *time.Time    `json:"published_at,omitempty"`
+	ProcessingAt   *time.Time    `json:"processing_at,omitempty"`
+	FailedAt       *time.Time    `json:"failed_at,omitempty"`
+	DeadLetteredAt *time.Time    `json:"dead_lettered_at,omitempty"`
+	LastError      string        `json:"last_error,omitempty"`
+	// Version is bumped on every status change, for optimistic concurrency.
+	Version int `json:"version"`
 }
@@ -0,0 +1,35 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type SortOrder string
+
+const (
+	SortAsc  SortOrder = "asc"
+	SortDesc SortOrder = "desc"
+)
+
+// MessageCursor points at the last message of a page, the next page starts
+// right after it in the requested order.
+type MessageCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+type MessageFilter struct {
+	Status        MessageStatus
+	Processed     *bool
+	CreatedFrom   *time.Time
+	CreatedTo     *time.Time
+	ProcessedFrom *time.Time
+	ProcessedTo   *time.Time
+	Query         string
+
+	Order SortOrder
+	Limit int
+	After *MessageCursor
+}
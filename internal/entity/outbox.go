@@ -0,0 +1,19 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OutboxEvent struct {
+	ID        int64     `json:"id"`
+	MessageID uuid.UUID `json:"message_id"`
+	Key       []byte    `json:"key"`
+	Payload   []byte    `json:"payload"`
+	Attempts  int       `json:"attempts"`
+	CreatedAt time.Time `json:"created_at"`
+	// TraceContext is the trace context of the request that created the
+	// message, the relay publishes the event in that trace.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
@@ -0,0 +1,12 @@
+package entity
+
+import "time"
+
+// QuotaUsage is how much of its daily quota a client used. The quota resets
+// at midnight UTC.
+type QuotaUsage struct {
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
@@ -0,0 +1,31 @@
+package entity
+
+// Role is the part of the work an instance of the app does, so ingestion and
+// processing can be scaled separately.
+type Role string
+
+const (
+	// RoleAPI serves the HTTP and gRPC APIs. Its event streams follow the
+	// transitions recorded in the database, so they report what the workers
+	// did to the messages too.
+	RoleAPI Role = "api"
+	// RoleWorker relays the outbox to Kafka and processes the messages.
+	RoleWorker Role = "worker"
+	RoleAll    Role = "all"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleAPI, RoleWorker, RoleAll:
+		return true
+	}
+	return false
+}
+
+func (r Role) ServesAPI() bool {
+	return r == RoleAPI || r == RoleAll
+}
+
+func (r Role) ProcessesMessages() bool {
+	return r == RoleWorker || r == RoleAll
+}
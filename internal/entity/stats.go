@@ -0,0 +1,55 @@
+package entity
+
+import "time"
+
+type StatsBucket string
+
+const (
+	StatsBucketMinute StatsBucket = "minute"
+	StatsBucketHour   StatsBucket = "hour"
+	StatsBucketDay    StatsBucket = "day"
+)
+
+func (b StatsBucket) Duration() time.Duration {
+	switch b {
+	case StatsBucketMinute:
+		return time.Minute
+	case StatsBucketHour:
+		return time.Hour
+	case StatsBucketDay:
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+type MessageCounts struct {
+	Total        int64 `json:"total"`
+	Processed    int64 `json:"processed"`
+	Pending      int64 `json:"pending"`
+	Failed       int64 `json:"failed"`
+	DeadLettered int64 `json:"dead_lettered"`
+}
+
+// ProcessingLatency holds percentiles of processed_at - created_at in
+// milliseconds over the messages processed in a time range.
+type ProcessingLatency struct {
+	Samples int64   `json:"samples"`
+	P50     float64 `json:"p50_ms"`
+	P95     float64 `json:"p95_ms"`
+	P99     float64 `json:"p99_ms"`
+}
+
+type ThroughputBucket struct {
+	Start     time.Time `json:"start"`
+	Created   int64     `json:"created"`
+	Processed int64     `json:"processed"`
+}
+
+type MessageStats struct {
+	Counts     MessageCounts      `json:"counts"`
+	Latency    ProcessingLatency  `json:"latency"`
+	From       time.Time          `json:"from"`
+	To         time.Time          `json:"to"`
+	Bucket     StatsBucket        `json:"bucket"`
+	Throughput []ThroughputBucket `json:"throughput"`
+}
@@ -0,0 +1,58 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MessageStatus string
+
+const (
+	StatusReceived     MessageStatus = "received"
+	StatusPublished    MessageStatus = "published"
+	StatusProcessing   MessageStatus = "processing"
+	StatusProcessed    MessageStatus = "processed"
+	StatusFailed       MessageStatus = "failed"
+	StatusDeadLettered MessageStatus = "dead_lettered"
+)
+
+// transitions lists the statuses each status may move to. A record can be
+// consumed before the relay has marked it published, so received may skip
+// published, and processing may be entered again when a record is redelivered.
+var transitions = map[MessageStatus][]MessageStatus{
+	StatusReceived:   {StatusPublished, StatusProcessing, StatusProcessed, StatusFailed, StatusDeadLettered},
+	StatusPublished:  {StatusProcessing, StatusProcessed, StatusFailed, StatusDeadLettered},
+	StatusProcessing: {StatusProcessing, StatusProcessed, StatusFailed, StatusDeadLettered},
+}
+
+func (s MessageStatus) Valid() bool {
+	switch s {
+	case StatusReceived, StatusPublished, StatusProcessing, StatusProcessed, StatusFailed, StatusDeadLettered:
+		return true
+	}
+	return false
+}
+
+// Terminal reports whether no further transition is allowed from s.
+func (s MessageStatus) Terminal() bool {
+	return len(transitions[s]) == 0
+}
+
+func (s MessageStatus) CanTransition(to MessageStatus) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+type MessageTransition struct {
+	ID        int64         `json:"id"`
+	MessageID uuid.UUID     `json:"message_id"`
+	From      MessageStatus `json:"from"`
+	To        MessageStatus `json:"to"`
+	Reason    string        `json:"reason,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unmatchedRoute labels requests no route matched, so unknown paths do not
+// each get their own series.
+const unmatchedRoute = "unmatched"
+
+var (
+	httpRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route template, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	httpRequestsInFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being served.",
+	})
+)
+
+// HTTPMiddleware records the latency of every request. Errors are rendered by
+// the error handler of the server first, so the status is the one sent.
+func HTTPMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			httpRequestsInFlight.Inc()
+			defer httpRequestsInFlight.Dec()
+			start := time.Now()
+
+			err := next(c)
+			if err != nil && !c.Response().Committed {
+				c.Error(err)
+				err = nil
+			}
+
+			route := c.Path()
+			if route == "" || c.Response().Status == http.StatusNotFound && route == "/*" {
+				route = unmatchedRoute
+			}
+			httpRequestDuration.WithLabelValues(
+				c.Request().Method,
+				route,
+				strconv.Itoa(c.Response().Status),
+			).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"messagio_testsuite/pkg/kafka"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// durationTotals adds up the DurationStats kafka-go resets on every read.
+type durationTotals struct {
+	count int64
+	sum   time.Duration
+}
+
+func (d *durationTotals) add(s kafka.DurationStats) {
+	d.count += s.Count
+	d.sum += s.Sum
+}
+
+type writerTotals struct {
+	writes, messages, errors int64
+	writeTime                durationTotals
+}
+
+type readerTotals struct {
+	lag                int64
+	messages, errors   int64
+	readTime, waitTime durationTotals
+}
+
+// KafkaCollector reports the statistics of the producer writer, the consumer
+// readers and the consumer workers.
+type KafkaCollector struct {
+	producer kafka.Producer
+	consumer kafka.Consumer
+
+	mu      sync.Mutex
+	writers map[string]*writerTotals
+	readers map[string]*readerTotals
+
+	writerWrites    *prometheus.Desc
+	writerMessages  *prometheus.Desc
+	writerErrors    *prometheus.Desc
+	writerWriteTime *prometheus.Desc
+	readerLag       *prometheus.Desc
+	readerMessages  *prometheus.Desc
+	readerErrors    *prometheus.Desc
+	readerReadTime  *prometheus.Desc
+	readerWaitTime  *prometheus.Desc
+	inFlight        *prometheus.Desc
+	processed       *prometheus.Desc
+	forwarded       *prometheus.Desc
+	failed          *prometheus.Desc
+}
+
+func NewKafkaCollector(producer kafka.Producer, consumer kafka.Consumer) *KafkaCollector {
+	desc := func(subsystem, name, help string, labels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, labels, nil)
+	}
+	return &KafkaCollector{
+		producer: producer,
+		consumer: consumer,
+		writers:  make(map[string]*writerTotals),
+		readers:  make(map[string]*readerTotals),
+
+		writerWrites:    desc("kafka_writer", "writes_total", "Write requests sent to the brokers.", "topic"),
+		writerMessages:  desc("kafka_writer", "messages_total", "Messages written.", "topic"),
+		writerErrors:    desc("kafka_writer", "errors_total", "Writes that failed.", "topic"),
+		writerWriteTime: desc("kafka_writer", "write_seconds", "Latency of write requests.", "topic"),
+		readerLag:       desc("kafka_reader", "lag", "Messages behind the end of the partition.", "topic"),
+		readerMessages:  desc("kafka_reader", "messages_total", "Messages read.", "topic"),
+		readerErrors:    desc("kafka_reader", "errors_total", "Reads that failed.", "topic"),
+		readerReadTime:  desc("kafka_reader", "read_seconds", "Time spent reading fetched batches.", "topic"),
+		readerWaitTime:  desc("kafka_reader", "wait_seconds", "Time spent waiting for fetches.", "topic"),
+		inFlight:        desc("consumer", "in_flight", "Records queued for or being handled by a worker."),
+		processed:       desc("consumer", "processed_total", "Records handled."),
+		forwarded:       desc("consumer", "forwarded_total", "Records forwarded to a retry tier or the dead-letter topic."),
+		failed:          desc("consumer", "failed_total", "Records that exhausted their attempts."),
+	}
+}
+
+// Describe lists every descriptor up front, Collect leaves out the topics
+// without a reader or writer on top of a MemoryBroker.
+func (kc *KafkaCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		kc.writerWrites, kc.writerMessages, kc.writerErrors, kc.writerWriteTime,
+		kc.readerLag, kc.readerMessages, kc.readerErrors, kc.readerReadTime, kc.readerWaitTime,
+		kc.inFlight, kc.processed, kc.forwarded, kc.failed,
+	} {
+		ch <- d
+	}
+}
+
+func (kc *KafkaCollector) Collect(ch chan<- prometheus.Metric) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	for _, s := range kc.producer.WriterStats() {
+		t := kc.writers[s.Topic]
+		if t == nil {
+			t = &writerTotals{}
+			kc.writers[s.Topic] = t
+		}
+		t.writes += s.Writes
+		t.messages += s.Messages
+		t.errors += s.Errors
+		t.writeTime.add(s.WriteTime)
+	}
+	for topic, t := range kc.writers {
+		ch <- prometheus.MustNewConstMetric(kc.writerWrites, prometheus.CounterValue, float64(t.writes), topic)
+		ch <- prometheus.MustNewConstMetric(kc.writerMessages, prometheus.CounterValue, float64(t.messages), topic)
+		ch <- prometheus.MustNewConstMetric(kc.writerErrors, prometheus.CounterValue, float64(t.errors), topic)
+		ch <- prometheus.MustNewConstSummary(kc.writerWriteTime, uint64(t.writeTime.count), t.writeTime.sum.Seconds(), nil, topic)
+	}
+
+	for _, s := range kc.consumer.ReaderStats() {
+		t := kc.readers[s.Topic]
+		if t == nil {
+			t = &readerTotals{}
+			kc.readers[s.Topic] = t
+		}
+		t.lag = s.Lag
+		t.messages += s.Messages
+		t.errors += s.Errors
+		t.readTime.add(s.ReadTime)
+		t.waitTime.add(s.WaitTime)
+	}
+	for topic, t := range kc.readers {
+		ch <- prometheus.MustNewConstMetric(kc.readerLag, prometheus.GaugeValue, float64(t.lag), topic)
+		ch <- prometheus.MustNewConstMetric(kc.readerMessages, prometheus.CounterValue, float64(t.messages), topic)
+		ch <- prometheus.MustNewConstMetric(kc.readerErrors, prometheus.CounterValue, float64(t.errors), topic)
+		ch <- prometheus.MustNewConstSummary(kc.readerReadTime, uint64(t.readTime.count), t.readTime.sum.Seconds(), nil, topic)
+		ch <- prometheus.MustNewConstSummary(kc.readerWaitTime, uint64(t.waitTime.count), t.waitTime.sum.Seconds(), nil, topic)
+	}
+
+	s := kc.consumer.Stats()
+	ch <- prometheus.MustNewConstMetric(kc.inFlight, prometheus.GaugeValue, float64(s.InFlight))
+	ch <- prometheus.MustNewConstMetric(kc.processed, prometheus.CounterValue, float64(s.Processed))
+	ch <- prometheus.MustNewConstMetric(kc.forwarded, prometheus.CounterValue, float64(s.Forwarded))
+	ch <- prometheus.MustNewConstMetric(kc.failed, prometheus.CounterValue, float64(s.Failed))
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"context"
+	"messagio_testsuite/pkg/kafka"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(HTTPMiddleware())
+	e.GET("/metrics", echo.WrapHandler(Handler()))
+	e.GET("/messages/:id", func(c echo.Context) error {
+		if c.Param("id") == "missing" {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		return c.NoContent(http.StatusOK)
+	})
+
+	for _, target := range []string{"/messages/1", "/messages/2", "/messages/missing", "/unknown/path"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	body := rec.Body.String()
+	assert.Contains(t, body, `messaggio_http_request_duration_seconds_count{method="GET",route="/messages/:id",status="200"} 2`)
+	assert.Contains(t, body, `messaggio_http_request_duration_seconds_count{method="GET",route="/messages/:id",status="404"} 1`,
+		"errors are counted with the status sent")
+	assert.Contains(t, body, `messaggio_http_request_duration_seconds_count{method="GET",route="unmatched",status="404"} 1`)
+	assert.NotContains(t, body, "/unknown/path")
+}
+
+type fakeProducer struct {
+	kafka.Producer
+	stats []kafka.WriterStats
+}
+
+func (p *fakeProducer) WriterStats() []kafka.WriterStats {
+	return p.stats
+}
+
+type fakeConsumer struct {
+	kafka.Consumer
+	stats []kafka.ReaderStats
+}
+
+func (c *fakeConsumer) ReaderStats() []kafka.ReaderStats {
+	return c.stats
+}
+
+func (c *fakeConsumer) Stats() kafka.ConsumerStats {
+	return kafka.ConsumerStats{InFlight: 3, Processed: 10, Forwarded: 2, Failed: 1}
+}
+
+func TestKafkaCollector(t *testing.T) {
+	producer := &fakeProducer{stats: []kafka.WriterStats{{
+		Topic: "messages", Writes: 2, Messages: 5, Errors: 1,
+		WriteTime: kafka.DurationStats{Count: 2, Sum: 300 * time.Millisecond},
+	}}}
+	consumer := &fakeConsumer{stats: []kafka.ReaderStats{{
+		Topic: "messages", Lag: 7, Messages: 4,
+		ReadTime: kafka.DurationStats{Count: 4, Sum: time.Second},
+	}}}
+	c := NewKafkaCollector(producer, consumer)
+
+	// kafka-go resets the counters on every call, the collector adds them up.
+	testutil.CollectAndCount(c)
+	consumer.stats[0].Lag = 1
+
+	expected := `
+# HELP messaggio_kafka_writer_messages_total Messages written.
+# TYPE messaggio_kafka_writer_messages_total counter
+messaggio_kafka_writer_messages_total{topic="messages"} 10
+# HELP messaggio_kafka_writer_write_seconds Latency of write requests.
+# TYPE messaggio_kafka_writer_write_seconds summary
+messaggio_kafka_writer_write_seconds_sum{topic="messages"} 0.6
+messaggio_kafka_writer_write_seconds_count{topic="messages"} 4
+# HELP messaggio_kafka_reader_lag Messages behind the end of the partition.
+# TYPE messaggio_kafka_reader_lag gauge
+messaggio_kafka_reader_lag{topic="messages"} 1
+# HELP messaggio_kafka_reader_messages_total Messages read.
+# TYPE messaggio_kafka_reader_messages_total counter
+messaggio_kafka_reader_messages_total{topic="messages"} 8
+# HELP messaggio_consumer_in_flight Records queued for or being handled by a worker.
+# TYPE messaggio_consumer_in_flight gauge
+messaggio_consumer_in_flight 3
+`
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"messaggio_kafka_writer_messages_total",
+		"messaggio_kafka_writer_write_seconds",
+		"messaggio_kafka_reader_lag",
+		"messaggio_kafka_reader_messages_total",
+		"messaggio_consumer_in_flight",
+	)
+	assert.NoError(t, err)
+}
+
+func TestKafkaCollector_MemoryBroker(t *testing.T) {
+	broker := kafka.NewMemoryBroker(1)
+	producer := kafka.NewMemoryProducer(broker, "messages")
+	consumer := kafka.NewMemoryConsumer(broker, "group", "messages")
+	require.NoError(t, producer.Produce(context.Background(), kafka.Envelope{Content: "hello"}))
+
+	assert.Empty(t, producer.WriterStats())
+	assert.Empty(t, consumer.ReaderStats())
+	assert.Equal(t, 4, testutil.CollectAndCount(NewKafkaCollector(producer, consumer)),
+		"only the consumer workers are reported")
+}
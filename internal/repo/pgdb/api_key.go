@@ -0,0 +1,102 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"messagio_testsuite/internal/entity"
+	repoerrs "messagio_testsuite/internal/repo/repo_errors"
+	"messagio_testsuite/pkg/postgres"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type APIKeyRepo struct {
+	*postgres.Postgres
+}
+
+func NewAPIKeyRepo(pg *postgres.Postgres) *APIKeyRepo {
+	return &APIKeyRepo{pg}
+}
+
+const apiKeyColumns = "id, name, prefix, key_hash, scopes, created_at, revoked_at"
+
+func scanAPIKey(row pgx.Row) (entity.APIKey, error) {
+	var key entity.APIKey
+	var scopes []string
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &key.CreatedAt, &key.RevokedAt)
+	if err != nil {
+		return entity.APIKey{}, err
+	}
+	key.Scopes = make([]entity.Scope, len(scopes))
+	for i, s := range scopes {
+		key.Scopes[i] = entity.Scope(s)
+	}
+	return key, nil
+}
+
+func (r *APIKeyRepo) CreateAPIKey(ctx context.Context, key entity.APIKey) (entity.APIKey, error) {
+	scopes := make([]string, len(key.Scopes))
+	for i, s := range key.Scopes {
+		scopes[i] = string(s)
+	}
+
+	query := `INSERT INTO messaggio.api_keys (name, prefix, key_hash, scopes)
+		VALUES ($1, $2, $3, $4) RETURNING ` + apiKeyColumns
+	created, err := scanAPIKey(r.Pool.QueryRow(ctx, query, key.Name, key.Prefix, key.KeyHash, scopes))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return entity.APIKey{}, repoerrs.ErrAlreadyExists
+		}
+		return entity.APIKey{}, err
+	}
+	return created, nil
+}
+
+// GetAPIKeyByHash returns the key with the given hash, revoked or not.
+func (r *APIKeyRepo) GetAPIKeyByHash(ctx context.Context, hash string) (entity.APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " FROM messaggio.api_keys WHERE key_hash = $1"
+	key, err := scanAPIKey(r.Pool.QueryRow(ctx, query, hash))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.APIKey{}, repoerrs.ErrNotFound
+		}
+		return entity.APIKey{}, err
+	}
+	return key, nil
+}
+
+func (r *APIKeyRepo) GetAPIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	query := "SELECT " + apiKeyColumns + " FROM messaggio.api_keys ORDER BY created_at, id"
+	rows, err := r.Pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []entity.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey marks the key revoked. Revoking a key twice keeps the first
+// revocation time.
+func (r *APIKeyRepo) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
+	query := "UPDATE messaggio.api_keys SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE id = $1"
+	tag, err := r.Pool.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package pgdb_test
+
+import (
+	"context"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/repo/pgdb"
+	repoerrs "messagio_testsuite/internal/repo/repo_errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyRepo(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	repo := pgdb.NewAPIKeyRepo(testDB)
+	ctx := context.Background()
+
+	key := entity.APIKey{
+		Name:    "ingest",
+		Prefix:  "mk_abcdefgh",
+		KeyHash: "hash-1",
+		Scopes:  []entity.Scope{entity.ScopeMessagesWrite, entity.ScopeMessagesRead},
+	}
+	created, err := repo.CreateAPIKey(ctx, key)
+	require.NoError(t, err)
+	assert.NotEqual(t, uuid.Nil, created.ID)
+	assert.Equal(t, key.Scopes, created.Scopes)
+	assert.Nil(t, created.RevokedAt)
+
+	_, err = repo.CreateAPIKey(ctx, key)
+	assert.ErrorIs(t, err, repoerrs.ErrAlreadyExists)
+
+	found, err := repo.GetAPIKeyByHash(ctx, "hash-1")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, found.ID)
+	_, err = repo.GetAPIKeyByHash(ctx, "missing")
+	assert.ErrorIs(t, err, repoerrs.ErrNotFound)
+
+	require.NoError(t, repo.RevokeAPIKey(ctx, created.ID))
+	keys, err := repo.GetAPIKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.NotNil(t, keys[0].RevokedAt)
+
+	assert.ErrorIs(t, repo.RevokeAPIKey(ctx, uuid.New()), repoerrs.ErrNotFound)
+}
@@ -0,0 +1,96 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"messagio_testsuite/internal/entity"
+	repoerrs "messagio_testsuite/internal/repo/repo_errors"
+	"messagio_testsuite/pkg/postgres"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type IdempotencyRepo struct {
+	*postgres.Postgres
+}
+
+func NewIdempotencyRepo(pg *postgres.Postgres) *IdempotencyRepo {
+	return &IdempotencyRepo{pg}
+}
+
+// ReserveKey claims the client's key for a request with the given fingerprint.
+// Other clients may use the same key independently. An expired
+// key, or one whose previous holder did not complete within its lease, is
+// taken over. When the key is held by another request, the stored record is
+// returned with reserved set to false.
+func (r *IdempotencyRepo) ReserveKey(ctx context.Context, client, key, fingerprint string, ttl, lease time.Duration) (entity.IdempotencyKey, bool, error) {
+	query := `INSERT INTO messaggio.idempotency_keys (client, key, fingerprint, locked_until, expires_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP + make_interval(secs => $5), CURRENT_TIMESTAMP + make_interval(secs => $4))
+		ON CONFLICT (client, key) DO UPDATE SET
+			fingerprint = EXCLUDED.fingerprint,
+			status_code = NULL,
+			response = NULL,
+			created_at = CURRENT_TIMESTAMP,
+			locked_until = EXCLUDED.locked_until,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= CURRENT_TIMESTAMP
+			OR (idempotency_keys.status_code IS NULL AND idempotency_keys.locked_until <= CURRENT_TIMESTAMP)
+		RETURNING client, key, fingerprint, created_at, expires_at`
+	var record entity.IdempotencyKey
+	err := r.Pool.QueryRow(ctx, query, client, key, fingerprint, ttl.Seconds(), lease.Seconds()).
+		Scan(&record.Client, &record.Key, &record.Fingerprint, &record.CreatedAt, &record.ExpiresAt)
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return entity.IdempotencyKey{}, false, err
+	}
+
+	record, err = r.GetKey(ctx, client, key)
+	return record, false, err
+}
+
+func (r *IdempotencyRepo) GetKey(ctx context.Context, client, key string) (entity.IdempotencyKey, error) {
+	query := `SELECT client, key, fingerprint, COALESCE(status_code, 0), response, created_at, expires_at
+		FROM messaggio.idempotency_keys WHERE client = $1 AND key = $2`
+	var record entity.IdempotencyKey
+	err := r.Pool.QueryRow(ctx, query, client, key).
+		Scan(&record.Client, &record.Key, &record.Fingerprint, &record.StatusCode, &record.Response, &record.CreatedAt, &record.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.IdempotencyKey{}, repoerrs.ErrNotFound
+		}
+		return entity.IdempotencyKey{}, err
+	}
+	return record, nil
+}
+
+func (r *IdempotencyRepo) CompleteKey(ctx context.Context, client, key string, statusCode int, response []byte) error {
+	query := "UPDATE messaggio.idempotency_keys SET status_code = $3, response = $4 WHERE client = $1 AND key = $2"
+	tag, err := r.Pool.Exec(ctx, query, client, key, statusCode, response)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+	return nil
+}
+
+// ReleaseKey drops a reservation that was not completed so the request can be
+// retried with the same key.
+func (r *IdempotencyRepo) ReleaseKey(ctx context.Context, client, key string) error {
+	query := "DELETE FROM messaggio.idempotency_keys WHERE client = $1 AND key = $2 AND status_code IS NULL"
+	_, err := r.Pool.Exec(ctx, query, client, key)
+	return err
+}
+
+func (r *IdempotencyRepo) DeleteExpiredKeys(ctx context.Context) (int64, error) {
+	query := "DELETE FROM messaggio.idempotency_keys WHERE expires_at <= CURRENT_TIMESTAMP"
+	tag, err := r.Pool.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,74 @@
+package pgdb_test
+
+import (
+	"context"
+	"messagio_testsuite/internal/repo/pgdb"
+	repoerrs "messagio_testsuite/internal/repo/repo_errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyRepo_ReserveAndComplete(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	repo := pgdb.NewIdempotencyRepo(testDB)
+	ctx := context.Background()
+
+	_, reserved, err := repo.ReserveKey(ctx, "client", "key-1", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+
+	record, reserved, err := repo.ReserveKey(ctx, "client", "key-1", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, reserved, "the key is held until its lease runs out")
+	assert.False(t, record.Completed())
+
+	require.NoError(t, repo.CompleteKey(ctx, "client", "key-1", 201, []byte(`{"id":"1"}`)))
+
+	record, reserved, err = repo.ReserveKey(ctx, "client", "key-1", "other", time.Hour, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, "fingerprint", record.Fingerprint)
+	assert.Equal(t, 201, record.StatusCode)
+	assert.Equal(t, []byte(`{"id":"1"}`), record.Response)
+
+	_, reserved, err = repo.ReserveKey(ctx, "other-client", "key-1", "other", time.Hour, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, reserved, "keys are scoped to the client")
+
+	assert.ErrorIs(t, repo.CompleteKey(ctx, "client", "missing", 201, nil), repoerrs.ErrNotFound)
+}
+
+func TestIdempotencyRepo_ExpiredAndReleasedKeys(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	repo := pgdb.NewIdempotencyRepo(testDB)
+	ctx := context.Background()
+
+	// A reservation whose lease ran out is taken over.
+	_, reserved, err := repo.ReserveKey(ctx, "client", "stale", "fingerprint", time.Hour, -time.Second)
+	require.NoError(t, err)
+	require.True(t, reserved)
+	_, reserved, err = repo.ReserveKey(ctx, "client", "stale", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, reserved)
+
+	// A released key can be reserved again.
+	require.NoError(t, repo.ReleaseKey(ctx, "client", "stale"))
+	_, err = repo.GetKey(ctx, "client", "stale")
+	assert.ErrorIs(t, err, repoerrs.ErrNotFound)
+
+	// Expired keys are reusable and purged.
+	_, _, err = repo.ReserveKey(ctx, "client", "expired", "fingerprint", -time.Second, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, repo.CompleteKey(ctx, "client", "expired", 201, nil))
+
+	deleted, err := repo.DeleteExpiredKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
@@ -5,12 +5,24 @@ import (
 	"errors"
 	"messagio_testsuite/internal/entity"
 	repoerrs "messagio_testsuite/internal/repo/repo_errors"
+	"messagio_testsuite/internal/tracing"
 	"messagio_testsuite/pkg/postgres"
+	"strings"
+	"time"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/v5"
 )
 
+const messageColumns = `id, message, created_at, status, processed, processed_at,
+	published_at, processing_at, failed_at, dead_lettered_at, COALESCE(last_error, ''), version`
+
+func scanMessage(row pgx.Row, message *entity.Message) error {
+	return row.Scan(&message.ID, &message.Message, &message.CreatedAt, &message.Status, &message.Processed, &message.ProcessedAt,
+		&message.PublishedAt, &message.ProcessingAt, &message.FailedAt, &message.DeadLetteredAt, &message.LastError, &message.Version)
+}
+
 type MessageRepo struct {
 	*postgres.Postgres
 }
@@ -19,7 +31,10 @@ func NewMessageRepo(pg *postgres.Postgres) *MessageRepo {
 	return &MessageRepo{pg}
 }
 
-func (r *MessageRepo) CreateMessage(ctx context.Context, message entity.Message) (uuid.UUID, error) {
+func (r *MessageRepo) CreateMessage(ctx context.Context, message entity.Message, event entity.OutboxEvent) (id uuid.UUID, err error) {
+	ctx, span := tracing.Start(ctx, "MessageRepo.CreateMessage")
+	defer func() { tracing.End(span, err) }()
+
 	tx, err := r.Pool.Begin(ctx)
 	if err != nil {
 		return uuid.Nil, repoerrs.ErrInsertFailed
@@ -30,14 +45,32 @@ func (r *MessageRepo) CreateMessage(ctx context.Context, message entity.Message)
 			panic(p)
 		} else if err != nil {
 			tx.Rollback(ctx)
-		} else {
-			err = tx.Commit(ctx)
+		} else if err = tx.Commit(ctx); err != nil {
+			id, err = uuid.Nil, repoerrs.ErrInsertFailed
 		}
 	}()
 
-	query := "INSERT INTO messaggio.messages (message) VALUES ($1) RETURNING id"
-	var id uuid.UUID
-	err = tx.QueryRow(ctx, query, message.Message).Scan(&id)
+	if message.ID == uuid.Nil {
+		message.ID = uuid.New()
+	}
+	if message.CreatedAt.IsZero() {
+		message.CreatedAt = time.Now().UTC()
+	}
+
+	query := "INSERT INTO messaggio.messages (id, message, created_at) VALUES ($1, $2, $3) RETURNING id"
+	err = tx.QueryRow(ctx, query, message.ID, message.Message, message.CreatedAt).Scan(&id)
+	if err != nil {
+		return uuid.Nil, repoerrs.ErrInsertFailed
+	}
+
+	query = "INSERT INTO messaggio.message_transitions (message_id, to_status) VALUES ($1, $2)"
+	_, err = tx.Exec(ctx, query, id, string(entity.StatusReceived))
+	if err != nil {
+		return uuid.Nil, repoerrs.ErrInsertFailed
+	}
+
+	query = "INSERT INTO messaggio.outbox (message_id, message_key, payload, trace_context) VALUES ($1, $2, $3, $4)"
+	_, err = tx.Exec(ctx, query, id, event.Key, event.Payload, event.TraceContext)
 	if err != nil {
 		return uuid.Nil, repoerrs.ErrInsertFailed
 	}
@@ -45,10 +78,68 @@ func (r *MessageRepo) CreateMessage(ctx context.Context, message entity.Message)
 	return id, nil
 }
 
+// CreateMessages copies the messages, their creation transitions and their
+// outbox events in a single transaction, either all of them are stored or
+// none.
+func (r *MessageRepo) CreateMessages(ctx context.Context, messages []entity.Message, events []entity.OutboxEvent) (err error) {
+	ctx, span := tracing.Start(ctx, "MessageRepo.CreateMessages")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := r.Pool.Begin(ctx)
+	if err != nil {
+		return repoerrs.ErrInsertFailed
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback(ctx)
+			panic(p)
+		} else if err != nil {
+			tx.Rollback(ctx)
+		} else if err = tx.Commit(ctx); err != nil {
+			err = repoerrs.ErrInsertFailed
+		}
+	}()
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"messaggio", "messages"},
+		[]string{"id", "message", "created_at"},
+		pgx.CopyFromSlice(len(messages), func(i int) ([]any, error) {
+			return []any{messages[i].ID, messages[i].Message, messages[i].CreatedAt}, nil
+		}),
+	)
+	if err != nil {
+		return repoerrs.ErrInsertFailed
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"messaggio", "message_transitions"},
+		[]string{"message_id", "to_status"},
+		pgx.CopyFromSlice(len(messages), func(i int) ([]any, error) {
+			return []any{messages[i].ID, string(entity.StatusReceived)}, nil
+		}),
+	)
+	if err != nil {
+		return repoerrs.ErrInsertFailed
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"messaggio", "outbox"},
+		[]string{"message_id", "message_key", "payload", "trace_context"},
+		pgx.CopyFromSlice(len(events), func(i int) ([]any, error) {
+			return []any{events[i].MessageID, events[i].Key, events[i].Payload, events[i].TraceContext}, nil
+		}),
+	)
+	if err != nil {
+		return repoerrs.ErrInsertFailed
+	}
+
+	return nil
+}
+
 func (r *MessageRepo) GetMessageById(ctx context.Context, id uuid.UUID) (entity.Message, error) {
-	query := "SELECT id, message, created_at, processed, processed_at FROM messaggio.messages WHERE id = $1"
+	query := "SELECT " + messageColumns + " FROM messaggio.messages WHERE id = $1"
 	var message entity.Message
-	err := r.Pool.QueryRow(ctx, query, id).Scan(&message.ID, &message.Message, &message.CreatedAt, &message.Processed, &message.ProcessedAt)
+	err := scanMessage(r.Pool.QueryRow(ctx, query, id), &message)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return entity.Message{}, repoerrs.ErrNotFound
@@ -58,18 +149,65 @@ func (r *MessageRepo) GetMessageById(ctx context.Context, id uuid.UUID) (entity.
 	return message, nil
 }
 
-func (r *MessageRepo) GetMessages(ctx context.Context) ([]entity.Message, error) {
-	query := "SELECT id, message, created_at, processed, processed_at FROM messaggio.messages"
-	rows, err := r.Pool.Query(ctx, query)
+func (r *MessageRepo) GetMessages(ctx context.Context, filter entity.MessageFilter) ([]entity.Message, error) {
+	builder := r.Builder.
+		Select(messageColumns).
+		From("messaggio.messages")
+
+	if filter.Status != "" {
+		builder = builder.Where(squirrel.Eq{"status": filter.Status})
+	}
+	if filter.Processed != nil {
+		builder = builder.Where(squirrel.Eq{"processed": *filter.Processed})
+	}
+	if filter.CreatedFrom != nil {
+		builder = builder.Where(squirrel.GtOrEq{"created_at": *filter.CreatedFrom})
+	}
+	if filter.CreatedTo != nil {
+		builder = builder.Where(squirrel.Lt{"created_at": *filter.CreatedTo})
+	}
+	if filter.ProcessedFrom != nil {
+		builder = builder.Where(squirrel.GtOrEq{"processed_at": *filter.ProcessedFrom})
+	}
+	if filter.ProcessedTo != nil {
+		builder = builder.Where(squirrel.Lt{"processed_at": *filter.ProcessedTo})
+	}
+	if filter.Query != "" {
+		builder = builder.Where(squirrel.ILike{"message": "%" + escapeLike(filter.Query) + "%"})
+	}
+
+	// Keyset pagination on (created_at, id), which is unique and backed by
+	// messages_created_at_id_idx.
+	if filter.Order == entity.SortDesc {
+		if filter.After != nil {
+			builder = builder.Where("(created_at, id) < (?, ?)", filter.After.CreatedAt, filter.After.ID)
+		}
+		builder = builder.OrderBy("created_at DESC", "id DESC")
+	} else {
+		if filter.After != nil {
+			builder = builder.Where("(created_at, id) > (?, ?)", filter.After.CreatedAt, filter.After.ID)
+		}
+		builder = builder.OrderBy("created_at", "id")
+	}
+	if filter.Limit > 0 {
+		builder = builder.Limit(uint64(filter.Limit))
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.Pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var messages []entity.Message
+	messages := make([]entity.Message, 0)
 	for rows.Next() {
 		var message entity.Message
-		if err := rows.Scan(&message.ID, &message.Message, &message.CreatedAt, &message.Processed, &message.ProcessedAt); err != nil {
+		if err := scanMessage(rows, &message); err != nil {
 			return nil, err
 		}
 		messages = append(messages, message)
@@ -81,23 +219,191 @@ func (r *MessageRepo) GetMessages(ctx context.Context) ([]entity.Message, error)
 	return messages, nil
 }
 
-func (r *MessageRepo) MarkMessageAsProcessed(ctx context.Context, id uuid.UUID) error {
-	query := "UPDATE messaggio.messages SET processed = true, processed_at = CURRENT_TIMESTAMP WHERE id = $1"
-	_, err := r.Pool.Exec(ctx, query, id)
-	return err
+// UpdateMessageStatus moves the message at the given version from one status
+// to another, stamps the transition time and records it in the history. It
+// returns repoerrs.ErrNotFound when there is no such message and
+// repoerrs.ErrConflict when it was changed since that version was read.
+func (r *MessageRepo) UpdateMessageStatus(ctx context.Context, id uuid.UUID, version int, from, to entity.MessageStatus, reason string) error {
+	set := "status = $3, version = version + 1"
+	switch to {
+	case entity.StatusPublished:
+		set += ", published_at = CURRENT_TIMESTAMP"
+	case entity.StatusProcessing:
+		set += ", processing_at = CURRENT_TIMESTAMP"
+	case entity.StatusProcessed:
+		set += ", processed = true, processed_at = CURRENT_TIMESTAMP"
+	case entity.StatusFailed:
+		set += ", failed_at = CURRENT_TIMESTAMP, last_error = NULLIF($4, '')"
+	case entity.StatusDeadLettered:
+		set += ", dead_lettered_at = CURRENT_TIMESTAMP, last_error = NULLIF($4, '')"
+	}
+
+	query := `WITH updated AS (
+			UPDATE messaggio.messages SET ` + set + `
+			WHERE id = $1 AND status = $2 AND version = $5
+			RETURNING id
+		)
+		INSERT INTO messaggio.message_transitions (message_id, from_status, to_status, reason)
+		SELECT id, $2, $3, NULLIF($4, '') FROM updated`
+	tag, err := r.Pool.Exec(ctx, query, id, string(from), string(to), reason, version)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+
+	var exists bool
+	err = r.Pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM messaggio.messages WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return repoerrs.ErrNotFound
+	}
+	return repoerrs.ErrConflict
+}
+
+const transitionColumns = "id, message_id, COALESCE(from_status, ''), to_status, COALESCE(reason, ''), created_at"
+
+// GetMessageTransitions returns the history of a message, leaving out its
+// creation.
+func (r *MessageRepo) GetMessageTransitions(ctx context.Context, id uuid.UUID) ([]entity.MessageTransition, error) {
+	query := "SELECT " + transitionColumns + `
+		FROM messaggio.message_transitions WHERE message_id = $1 AND from_status IS NOT NULL ORDER BY id`
+	return r.queryTransitions(ctx, query, id)
+}
+
+// GetTransitionsAfter returns up to limit transitions of any message with an
+// ID greater than after, oldest first. Creations have an empty From.
+func (r *MessageRepo) GetTransitionsAfter(ctx context.Context, after int64, limit int) ([]entity.MessageTransition, error) {
+	query := "SELECT " + transitionColumns + `
+		FROM messaggio.message_transitions WHERE id > $1 ORDER BY id LIMIT $2`
+	return r.queryTransitions(ctx, query, after, limit)
+}
+
+// GetTransitions returns the transitions with the given IDs that exist,
+// oldest first.
+func (r *MessageRepo) GetTransitions(ctx context.Context, ids []int64) ([]entity.MessageTransition, error) {
+	query := "SELECT " + transitionColumns + `
+		FROM messaggio.message_transitions WHERE id = ANY($1) ORDER BY id`
+	return r.queryTransitions(ctx, query, ids)
+}
+
+// GetLastTransitionID returns 0 before the first transition.
+func (r *MessageRepo) GetLastTransitionID(ctx context.Context) (int64, error) {
+	var id int64
+	err := r.Pool.QueryRow(ctx, "SELECT COALESCE(MAX(id), 0) FROM messaggio.message_transitions").Scan(&id)
+	return id, err
+}
+
+func (r *MessageRepo) queryTransitions(ctx context.Context, query string, args ...any) ([]entity.MessageTransition, error) {
+	rows, err := r.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transitions := make([]entity.MessageTransition, 0)
+	for rows.Next() {
+		var t entity.MessageTransition
+		if err := rows.Scan(&t.ID, &t.MessageID, &t.From, &t.To, &t.Reason, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return transitions, nil
 }
 
 func (r *MessageRepo) GetProcessedMessagesStats(ctx context.Context) (int, error) {
-	query := "SELECT COUNT(*) FROM messaggio.messages WHERE processed = true"
+	query := "SELECT COUNT(*) FROM messaggio.messages WHERE status = 'processed'"
 	var count int
 	err := r.Pool.QueryRow(ctx, query).Scan(&count)
 	return count, err
 }
 
+func (r *MessageRepo) GetMessageCounts(ctx context.Context) (entity.MessageCounts, error) {
+	query := `SELECT COUNT(*),
+		COUNT(*) FILTER (WHERE status = 'processed'),
+		COUNT(*) FILTER (WHERE status = 'failed'),
+		COUNT(*) FILTER (WHERE status = 'dead_lettered')
+		FROM messaggio.messages`
+	var counts entity.MessageCounts
+	err := r.Pool.QueryRow(ctx, query).Scan(&counts.Total, &counts.Processed, &counts.Failed, &counts.DeadLettered)
+	if err != nil {
+		return entity.MessageCounts{}, err
+	}
+	counts.Pending = counts.Total - counts.Processed - counts.Failed - counts.DeadLettered
+	return counts, nil
+}
+
+func (r *MessageRepo) GetProcessingLatency(ctx context.Context, from, to time.Time) (entity.ProcessingLatency, error) {
+	query := `SELECT COUNT(*),
+		COALESCE(percentile_cont(0.50) WITHIN GROUP (ORDER BY latency), 0),
+		COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY latency), 0),
+		COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY latency), 0)
+		FROM (
+			SELECT (EXTRACT(EPOCH FROM processed_at - created_at) * 1000)::double precision AS latency
+			FROM messaggio.messages
+			WHERE processed_at >= $1 AND processed_at < $2
+		) l`
+	var latency entity.ProcessingLatency
+	err := r.Pool.QueryRow(ctx, query, from, to).Scan(&latency.Samples, &latency.P50, &latency.P95, &latency.P99)
+	return latency, err
+}
+
+// GetThroughput counts created and processed messages per bucket in [from, to).
+// Buckets are aligned with date_trunc and empty ones are included.
+func (r *MessageRepo) GetThroughput(ctx context.Context, from, to time.Time, bucket entity.StatsBucket) ([]entity.ThroughputBucket, error) {
+	query := `WITH buckets AS (
+			SELECT generate_series(date_trunc($1, $2::timestamp), $3::timestamp, $4::interval) AS start
+		), created AS (
+			SELECT date_trunc($1, created_at) AS start, COUNT(*) AS n
+			FROM messaggio.messages
+			WHERE created_at >= $2 AND created_at < $3
+			GROUP BY 1
+		), processed AS (
+			SELECT date_trunc($1, processed_at) AS start, COUNT(*) AS n
+			FROM messaggio.messages
+			WHERE processed_at >= $2 AND processed_at < $3
+			GROUP BY 1
+		)
+		SELECT b.start, COALESCE(c.n, 0), COALESCE(p.n, 0)
+		FROM buckets b
+		LEFT JOIN created c USING (start)
+		LEFT JOIN processed p USING (start)
+		WHERE b.start < $3
+		ORDER BY b.start`
+	rows, err := r.Pool.Query(ctx, query, string(bucket), from, to, bucket.Duration())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := make([]entity.ThroughputBucket, 0)
+	for rows.Next() {
+		var b entity.ThroughputBucket
+		if err := rows.Scan(&b.Start, &b.Created, &b.Processed); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
 func (r *MessageRepo) GetMessageByContent(ctx context.Context, content string) (entity.Message, error) {
-	query := "SELECT id, message, created_at, processed, processed_at FROM messaggio.messages WHERE message = $1"
+	query := "SELECT " + messageColumns + ` FROM messaggio.messages
+		WHERE message = $1 ORDER BY processed, created_at LIMIT 1`
 	var message entity.Message
-	err := r.Pool.QueryRow(ctx, query, content).Scan(&message.ID, &message.Message, &message.CreatedAt, &message.Processed, &message.ProcessedAt)
+	err := scanMessage(r.Pool.QueryRow(ctx, query, content), &message)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return entity.Message{}, repoerrs.ErrNotFound
@@ -106,3 +412,10 @@ func (r *MessageRepo) GetMessageByContent(ctx context.Context, content string) (
 	}
 	return message, nil
 }
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike makes s match literally inside a LIKE pattern.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
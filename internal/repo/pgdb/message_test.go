@@ -4,9 +4,11 @@ import (
 	"context"
 	"messagio_testsuite/internal/entity"
 	"messagio_testsuite/internal/repo/pgdb"
+	repoerrs "messagio_testsuite/internal/repo/repo_errors"
 	"messagio_testsuite/pkg/postgres"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/docker/go-connections/nat"
 	"github.com/google/uuid"
@@ -18,6 +20,8 @@ import (
 
 var testDB *postgres.Postgres
 
+var testEvent = entity.OutboxEvent{Payload: []byte("test payload")}
+
 const createSchemaSQL = `
 CREATE SCHEMA IF NOT EXISTS messaggio;
 CREATE TABLE messaggio.messages (
@@ -25,7 +29,60 @@ CREATE TABLE messaggio.messages (
     message TEXT NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     processed BOOLEAN DEFAULT FALSE,
-    processed_at TIMESTAMP
+    processed_at TIMESTAMP,
+    failed_at TIMESTAMP,
+    last_error TEXT,
+    status TEXT NOT NULL DEFAULT 'received',
+    published_at TIMESTAMP,
+    processing_at TIMESTAMP,
+    dead_lettered_at TIMESTAMP,
+    version INT NOT NULL DEFAULT 1
+);
+CREATE TABLE messaggio.message_transitions (
+    id BIGSERIAL PRIMARY KEY,
+    message_id uuid NOT NULL REFERENCES messaggio.messages (id) ON DELETE CASCADE,
+    from_status TEXT,
+    to_status TEXT NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE messaggio.outbox (
+    id BIGSERIAL PRIMARY KEY,
+    message_id uuid NOT NULL REFERENCES messaggio.messages (id) ON DELETE CASCADE,
+    message_key BYTEA,
+    payload BYTEA NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP,
+    trace_context JSONB
+);
+CREATE TABLE messaggio.idempotency_keys (
+    client TEXT NOT NULL,
+    key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status_code INT,
+    response BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (client, key)
+);
+CREATE TABLE messaggio.api_keys (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+CREATE TABLE messaggio.client_quotas (
+    client TEXT NOT NULL,
+    day DATE NOT NULL,
+    used BIGINT NOT NULL,
+    PRIMARY KEY (client, day)
 );
 `
 
@@ -89,11 +146,46 @@ func TestMessageRepo_CreateMessage(t *testing.T) {
 		Message: "test message",
 	}
 
-	id, err := repo.CreateMessage(ctx, message)
+	id, err := repo.CreateMessage(ctx, message, testEvent)
 	require.NoError(t, err)
 	assert.NotEqual(t, uuid.Nil, id)
 }
 
+func TestMessageRepo_CreateMessages(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	repo := pgdb.NewMessageRepo(testDB)
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	messages := make([]entity.Message, 3)
+	events := make([]entity.OutboxEvent, 3)
+	for i := range messages {
+		messages[i] = entity.Message{ID: uuid.New(), Message: "batch message", CreatedAt: now}
+		events[i] = entity.OutboxEvent{MessageID: messages[i].ID, Payload: []byte("test payload")}
+	}
+
+	require.NoError(t, repo.CreateMessages(ctx, messages, events))
+
+	for _, message := range messages {
+		fetched, err := repo.GetMessageById(ctx, message.ID)
+		require.NoError(t, err)
+		assert.Equal(t, message.Message, fetched.Message)
+	}
+
+	var pending int
+	require.NoError(t, testDB.Pool.QueryRow(ctx, "SELECT COUNT(*) FROM messaggio.outbox WHERE sent_at IS NULL").Scan(&pending))
+	assert.Equal(t, 3, pending)
+
+	// A duplicate ID fails the whole batch.
+	duplicate := []entity.Message{{ID: uuid.New(), Message: "new", CreatedAt: now}, messages[0]}
+	err := repo.CreateMessages(ctx, duplicate, []entity.OutboxEvent{{MessageID: duplicate[0].ID}, events[0]})
+	assert.ErrorIs(t, err, repoerrs.ErrInsertFailed)
+	_, err = repo.GetMessageById(ctx, duplicate[0].ID)
+	assert.ErrorIs(t, err, repoerrs.ErrNotFound)
+}
+
 func TestMessageRepo_GetMessageById(t *testing.T) {
 	teardown := setupPostgres(t)
 	defer teardown()
@@ -105,7 +197,7 @@ func TestMessageRepo_GetMessageById(t *testing.T) {
 		Message: "test message",
 	}
 
-	id, err := repo.CreateMessage(ctx, message)
+	id, err := repo.CreateMessage(ctx, message, testEvent)
 	require.NoError(t, err)
 
 	fetchedMessage, err := repo.GetMessageById(ctx, id)
@@ -121,18 +213,74 @@ func TestMessageRepo_GetMessages(t *testing.T) {
 	repo := pgdb.NewMessageRepo(testDB)
 	ctx := context.Background()
 
-	_, err := repo.CreateMessage(ctx, entity.Message{Message: "test message 1"})
+	_, err := repo.CreateMessage(ctx, entity.Message{Message: "test message 1"}, testEvent)
 	require.NoError(t, err)
 
-	_, err = repo.CreateMessage(ctx, entity.Message{Message: "test message 2"})
+	_, err = repo.CreateMessage(ctx, entity.Message{Message: "test message 2"}, testEvent)
 	require.NoError(t, err)
 
-	messages, err := repo.GetMessages(ctx)
+	messages, err := repo.GetMessages(ctx, entity.MessageFilter{})
 	require.NoError(t, err)
 	assert.GreaterOrEqual(t, len(messages), 2)
 }
 
-func TestMessageRepo_MarkMessageAsProcessed(t *testing.T) {
+func TestMessageRepo_GetMessagesFiltersAndPaginates(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	repo := pgdb.NewMessageRepo(testDB)
+	ctx := context.Background()
+
+	start := time.Now().UTC().Truncate(time.Microsecond)
+	var ids []uuid.UUID
+	for i, content := range []string{"alpha", "beta", "100% gamma", "alphabet"} {
+		id, err := repo.CreateMessage(ctx, entity.Message{
+			Message:   content,
+			CreatedAt: start.Add(time.Duration(i) * time.Second),
+		}, testEvent)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	require.NoError(t, repo.UpdateMessageStatus(ctx, ids[1], 1, entity.StatusReceived, entity.StatusProcessed, ""))
+
+	messages, err := repo.GetMessages(ctx, entity.MessageFilter{Order: entity.SortAsc, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, ids[:2], []uuid.UUID{messages[0].ID, messages[1].ID})
+
+	last := messages[1]
+	messages, err = repo.GetMessages(ctx, entity.MessageFilter{
+		Order: entity.SortAsc,
+		After: &entity.MessageCursor{CreatedAt: last.CreatedAt, ID: last.ID},
+	})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, ids[2:], []uuid.UUID{messages[0].ID, messages[1].ID})
+
+	messages, err = repo.GetMessages(ctx, entity.MessageFilter{Order: entity.SortDesc, Query: "ALPHA"})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, []uuid.UUID{ids[3], ids[0]}, []uuid.UUID{messages[0].ID, messages[1].ID})
+
+	messages, err = repo.GetMessages(ctx, entity.MessageFilter{Query: "0%"})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, ids[2], messages[0].ID)
+
+	processed := true
+	messages, err = repo.GetMessages(ctx, entity.MessageFilter{Processed: &processed})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, ids[1], messages[0].ID)
+
+	createdTo := start.Add(time.Second)
+	messages, err = repo.GetMessages(ctx, entity.MessageFilter{CreatedTo: &createdTo})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, ids[0], messages[0].ID)
+}
+
+func TestMessageRepo_UpdateMessageStatus(t *testing.T) {
 	teardown := setupPostgres(t)
 	defer teardown()
 
@@ -143,15 +291,86 @@ func TestMessageRepo_MarkMessageAsProcessed(t *testing.T) {
 		Message: "test message",
 	}
 
-	id, err := repo.CreateMessage(ctx, message)
+	id, err := repo.CreateMessage(ctx, message, testEvent)
+	require.NoError(t, err)
+
+	fetchedMessage, err := repo.GetMessageById(ctx, id)
 	require.NoError(t, err)
+	assert.Equal(t, entity.StatusReceived, fetchedMessage.Status)
+
+	assert.Equal(t, 1, fetchedMessage.Version)
 
-	err = repo.MarkMessageAsProcessed(ctx, id)
+	err = repo.UpdateMessageStatus(ctx, id, 1, entity.StatusReceived, entity.StatusProcessing, "")
+	require.NoError(t, err)
+	err = repo.UpdateMessageStatus(ctx, id, 2, entity.StatusProcessing, entity.StatusProcessed, "")
 	require.NoError(t, err)
 
-	fetchedMessage, err := repo.GetMessageById(ctx, id)
+	fetchedMessage, err = repo.GetMessageById(ctx, id)
 	require.NoError(t, err)
 	assert.True(t, fetchedMessage.Processed)
+	assert.Equal(t, entity.StatusProcessed, fetchedMessage.Status)
+	assert.NotNil(t, fetchedMessage.ProcessingAt)
+	assert.NotNil(t, fetchedMessage.ProcessedAt)
+	assert.Equal(t, 3, fetchedMessage.Version)
+
+	// The update only applies from the expected status and version.
+	err = repo.UpdateMessageStatus(ctx, id, 3, entity.StatusProcessing, entity.StatusFailed, "too late")
+	assert.ErrorIs(t, err, repoerrs.ErrConflict)
+	err = repo.UpdateMessageStatus(ctx, id, 2, entity.StatusProcessed, entity.StatusFailed, "too late")
+	assert.ErrorIs(t, err, repoerrs.ErrConflict)
+	err = repo.UpdateMessageStatus(ctx, uuid.New(), 1, entity.StatusReceived, entity.StatusProcessed, "")
+	assert.ErrorIs(t, err, repoerrs.ErrNotFound)
+
+	transitions, err := repo.GetMessageTransitions(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, transitions, 2)
+	assert.Equal(t, entity.StatusReceived, transitions[0].From)
+	assert.Equal(t, entity.StatusProcessing, transitions[0].To)
+	assert.Equal(t, entity.StatusProcessed, transitions[1].To)
+}
+
+func TestMessageRepo_GetTransitionsAfter(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	repo := pgdb.NewMessageRepo(testDB)
+	ctx := context.Background()
+
+	last, err := repo.GetLastTransitionID(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, last)
+
+	id, err := repo.CreateMessage(ctx, entity.Message{Message: "test message"}, testEvent)
+	require.NoError(t, err)
+	require.NoError(t, repo.UpdateMessageStatus(ctx, id, 1, entity.StatusReceived, entity.StatusProcessing, ""))
+	require.NoError(t, repo.UpdateMessageStatus(ctx, id, 2, entity.StatusProcessing, entity.StatusFailed, "boom"))
+
+	transitions, err := repo.GetTransitionsAfter(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, transitions, 3)
+	assert.Equal(t, id, transitions[0].MessageID)
+	assert.Empty(t, transitions[0].From, "creation has no from status")
+	assert.Equal(t, entity.StatusReceived, transitions[0].To)
+	assert.Equal(t, entity.StatusFailed, transitions[2].To)
+	assert.Equal(t, "boom", transitions[2].Reason)
+
+	transitions, err = repo.GetTransitionsAfter(ctx, transitions[0].ID, 1)
+	require.NoError(t, err)
+	require.Len(t, transitions, 1)
+	assert.Equal(t, entity.StatusProcessing, transitions[0].To)
+
+	last, err = repo.GetLastTransitionID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, transitions[0].ID+1, last)
+
+	transitions, err = repo.GetTransitions(ctx, []int64{last, last + 1})
+	require.NoError(t, err)
+	require.Len(t, transitions, 1, "missing IDs are left out")
+	assert.Equal(t, last, transitions[0].ID)
+
+	history, err := repo.GetMessageTransitions(ctx, id)
+	require.NoError(t, err)
+	assert.Len(t, history, 2, "the history leaves out the creation")
 }
 
 func TestMessageRepo_GetProcessedMessagesStats(t *testing.T) {
@@ -161,16 +380,16 @@ func TestMessageRepo_GetProcessedMessagesStats(t *testing.T) {
 	repo := pgdb.NewMessageRepo(testDB)
 	ctx := context.Background()
 
-	_, err := repo.CreateMessage(ctx, entity.Message{Message: "test message 1"})
+	_, err := repo.CreateMessage(ctx, entity.Message{Message: "test message 1"}, testEvent)
 	require.NoError(t, err)
 
-	_, err = repo.CreateMessage(ctx, entity.Message{Message: "test message 2"})
+	_, err = repo.CreateMessage(ctx, entity.Message{Message: "test message 2"}, testEvent)
 	require.NoError(t, err)
 
-	id, err := repo.CreateMessage(ctx, entity.Message{Message: "test message 3"})
+	id, err := repo.CreateMessage(ctx, entity.Message{Message: "test message 3"}, testEvent)
 	require.NoError(t, err)
 
-	err = repo.MarkMessageAsProcessed(ctx, id)
+	err = repo.UpdateMessageStatus(ctx, id, 1, entity.StatusReceived, entity.StatusProcessed, "")
 	require.NoError(t, err)
 
 	count, err := repo.GetProcessedMessagesStats(ctx)
@@ -178,6 +397,75 @@ func TestMessageRepo_GetProcessedMessagesStats(t *testing.T) {
 	assert.GreaterOrEqual(t, count, 1)
 }
 
+func TestMessageRepo_GetMessageCounts(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	repo := pgdb.NewMessageRepo(testDB)
+	ctx := context.Background()
+
+	var ids []uuid.UUID
+	for i := 0; i < 4; i++ {
+		id, err := repo.CreateMessage(ctx, entity.Message{Message: "test message"}, testEvent)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	require.NoError(t, repo.UpdateMessageStatus(ctx, ids[0], 1, entity.StatusReceived, entity.StatusProcessed, ""))
+	require.NoError(t, repo.UpdateMessageStatus(ctx, ids[1], 1, entity.StatusReceived, entity.StatusFailed, "database unavailable"))
+	require.NoError(t, repo.UpdateMessageStatus(ctx, ids[2], 1, entity.StatusReceived, entity.StatusDeadLettered, "database unavailable"))
+
+	counts, err := repo.GetMessageCounts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, entity.MessageCounts{Total: 4, Processed: 1, Pending: 1, Failed: 1, DeadLettered: 1}, counts)
+}
+
+func TestMessageRepo_GetProcessingLatencyAndThroughput(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	repo := pgdb.NewMessageRepo(testDB)
+	ctx := context.Background()
+
+	from := time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Hour)
+
+	// Latencies of 1s to 100s, created in the first hour and processed in
+	// the second one. One more message stays unprocessed in the third hour.
+	for i := 1; i <= 100; i++ {
+		created := from.Add(time.Duration(i) * time.Second)
+		id, err := repo.CreateMessage(ctx, entity.Message{Message: "test message", CreatedAt: created}, testEvent)
+		require.NoError(t, err)
+
+		_, err = testDB.Pool.Exec(ctx, "UPDATE messaggio.messages SET status = 'processed', processed = true, processed_at = $2 WHERE id = $1",
+			id, from.Add(time.Hour+time.Duration(2*i)*time.Second))
+		require.NoError(t, err)
+	}
+	_, err := repo.CreateMessage(ctx, entity.Message{Message: "test message", CreatedAt: from.Add(150 * time.Minute)}, testEvent)
+	require.NoError(t, err)
+
+	latency, err := repo.GetProcessingLatency(ctx, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), latency.Samples)
+	assert.InDelta(t, 3600_000+50_500, latency.P50, 1)
+	assert.InDelta(t, 3600_000+95_050, latency.P95, 1)
+	assert.InDelta(t, 3600_000+99_010, latency.P99, 1)
+
+	buckets, err := repo.GetThroughput(ctx, from, to, entity.StatsBucketHour)
+	require.NoError(t, err)
+	assert.Equal(t, []entity.ThroughputBucket{
+		{Start: from, Created: 100, Processed: 0},
+		{Start: from.Add(time.Hour), Created: 0, Processed: 100},
+		{Start: from.Add(2 * time.Hour), Created: 1, Processed: 0},
+	}, buckets)
+
+	buckets, err = repo.GetThroughput(ctx, from, from.Add(3*time.Minute), entity.StatsBucketMinute)
+	require.NoError(t, err)
+	require.Len(t, buckets, 3)
+	assert.Equal(t, int64(59), buckets[0].Created)
+	assert.Equal(t, int64(41), buckets[1].Created)
+	assert.Equal(t, int64(0), buckets[2].Created)
+}
+
 func TestMessageRepo_GetMessageByContent(t *testing.T) {
 	teardown := setupPostgres(t)
 	defer teardown()
@@ -189,7 +477,7 @@ func TestMessageRepo_GetMessageByContent(t *testing.T) {
 		Message: "unique test message",
 	}
 
-	id, err := repo.CreateMessage(ctx, message)
+	id, err := repo.CreateMessage(ctx, message, testEvent)
 	require.NoError(t, err)
 
 	fetchedMessage, err := repo.GetMessageByContent(ctx, message.Message)
@@ -0,0 +1,99 @@
+package pgdb
+
+import (
+	"context"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/pkg/postgres"
+	"sort"
+	"time"
+)
+
+type OutboxRepo struct {
+	*postgres.Postgres
+}
+
+func NewOutboxRepo(pg *postgres.Postgres) *OutboxRepo {
+	return &OutboxRepo{pg}
+}
+
+// ClaimPendingEvents locks up to limit due events and pushes their next attempt
+// forward by lease, so a relay that dies mid-publish only delays them.
+func (r *OutboxRepo) ClaimPendingEvents(ctx context.Context, limit int, lease time.Duration) ([]entity.OutboxEvent, error) {
+	query := `UPDATE messaggio.outbox
+		SET attempts = attempts + 1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2)
+		WHERE id IN (
+			SELECT id FROM messaggio.outbox
+			WHERE sent_at IS NULL AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, message_id, message_key, payload, attempts, created_at, trace_context`
+	rows, err := r.Pool.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []entity.OutboxEvent
+	for rows.Next() {
+		var event entity.OutboxEvent
+		if err := rows.Scan(&event.ID, &event.MessageID, &event.Key, &event.Payload, &event.Attempts, &event.CreatedAt, &event.TraceContext); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+// MarkEventsSent also moves the messages of the events from received to
+// published, unless a consumer already got to them.
+func (r *OutboxRepo) MarkEventsSent(ctx context.Context, ids []int64) error {
+	query := `WITH sent AS (
+			UPDATE messaggio.outbox SET sent_at = CURRENT_TIMESTAMP, last_error = NULL
+			WHERE id = ANY($1)
+			RETURNING message_id
+		), published AS (
+			UPDATE messaggio.messages m
+			SET status = 'published', published_at = CURRENT_TIMESTAMP, version = m.version + 1
+			FROM sent
+			WHERE m.id = sent.message_id AND m.status = 'received'
+			RETURNING m.id
+		)
+		INSERT INTO messaggio.message_transitions (message_id, from_status, to_status)
+		SELECT id, 'received', 'published' FROM published`
+	_, err := r.Pool.Exec(ctx, query, ids)
+	return err
+}
+
+func (r *OutboxRepo) MarkEventFailed(ctx context.Context, id int64, reason string, retryIn time.Duration) error {
+	query := `UPDATE messaggio.outbox
+		SET last_error = $2, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $3)
+		WHERE id = $1`
+	_, err := r.Pool.Exec(ctx, query, id, reason, retryIn.Seconds())
+	return err
+}
+
+func (r *OutboxRepo) DeleteSentEvents(ctx context.Context, olderThan time.Duration) (int64, error) {
+	query := "DELETE FROM messaggio.outbox WHERE sent_at < CURRENT_TIMESTAMP - make_interval(secs => $1)"
+	tag, err := r.Pool.Exec(ctx, query, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *OutboxRepo) GetBacklog(ctx context.Context) (entity.OutboxBacklog, error) {
+	query := "SELECT count(*), min(created_at) FROM messaggio.outbox WHERE sent_at IS NULL"
+	var backlog entity.OutboxBacklog
+	if err := r.Pool.QueryRow(ctx, query).Scan(&backlog.Pending, &backlog.OldestAt); err != nil {
+		return entity.OutboxBacklog{}, err
+	}
+	return backlog, nil
+}
@@ -0,0 +1,102 @@
+package pgdb_test
+
+import (
+	"context"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/repo/pgdb"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxRepo_ClaimPendingEvents(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	messageRepo := pgdb.NewMessageRepo(testDB)
+	outboxRepo := pgdb.NewOutboxRepo(testDB)
+	ctx := context.Background()
+
+	id, err := messageRepo.CreateMessage(ctx, entity.Message{Message: "test message"}, testEvent)
+	require.NoError(t, err)
+
+	events, err := outboxRepo.ClaimPendingEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, id, events[0].MessageID)
+	assert.Equal(t, testEvent.Payload, events[0].Payload)
+	assert.Equal(t, 1, events[0].Attempts)
+
+	events, err = outboxRepo.ClaimPendingEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, events, "claimed events are leased")
+}
+
+func TestOutboxRepo_MarkEventFailed(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	messageRepo := pgdb.NewMessageRepo(testDB)
+	outboxRepo := pgdb.NewOutboxRepo(testDB)
+	ctx := context.Background()
+
+	id, err := messageRepo.CreateMessage(ctx, entity.Message{Message: "test message"}, testEvent)
+	require.NoError(t, err)
+
+	events, err := outboxRepo.ClaimPendingEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	err = outboxRepo.MarkEventFailed(ctx, events[0].ID, "broker unavailable", 0)
+	require.NoError(t, err)
+
+	events, err = outboxRepo.ClaimPendingEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, 2, events[0].Attempts)
+
+	err = outboxRepo.MarkEventsSent(ctx, []int64{events[0].ID})
+	require.NoError(t, err)
+
+	message, err := messageRepo.GetMessageById(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, entity.StatusPublished, message.Status)
+	assert.NotNil(t, message.PublishedAt)
+
+	err = outboxRepo.MarkEventFailed(ctx, events[0].ID, "late failure", 0)
+	require.NoError(t, err)
+
+	events, err = outboxRepo.ClaimPendingEvents(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, events, "sent events are never claimed again")
+}
+
+func TestOutboxRepo_GetBacklog(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	messageRepo := pgdb.NewMessageRepo(testDB)
+	outboxRepo := pgdb.NewOutboxRepo(testDB)
+	ctx := context.Background()
+
+	backlog, err := outboxRepo.GetBacklog(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, entity.OutboxBacklog{}, backlog)
+
+	for i := 0; i < 2; i++ {
+		_, err := messageRepo.CreateMessage(ctx, entity.Message{Message: "test message"}, testEvent)
+		require.NoError(t, err)
+	}
+
+	events, err := outboxRepo.ClaimPendingEvents(ctx, 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.NoError(t, outboxRepo.MarkEventsSent(ctx, []int64{events[0].ID}))
+
+	backlog, err = outboxRepo.GetBacklog(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), backlog.Pending)
+	assert.NotNil(t, backlog.OldestAt)
+}
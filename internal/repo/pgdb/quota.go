@@ -0,0 +1,66 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"messagio_testsuite/pkg/postgres"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type QuotaRepo struct {
+	*postgres.Postgres
+}
+
+func NewQuotaRepo(pg *postgres.Postgres) *QuotaRepo {
+	return &QuotaRepo{pg}
+}
+
+// ConsumeQuota counts n units against the quota of client for day, all of
+// them or none when they would take the usage past limit. It returns the units
+// used after the call.
+func (r *QuotaRepo) ConsumeQuota(ctx context.Context, client string, day time.Time, n, limit int64) (int64, bool, error) {
+	query := `INSERT INTO messaggio.client_quotas (client, day, used)
+		SELECT $1, $2, $3::bigint WHERE $3::bigint <= $4::bigint
+		ON CONFLICT (client, day) DO UPDATE SET used = client_quotas.used + EXCLUDED.used
+		WHERE client_quotas.used + EXCLUDED.used <= $4::bigint
+		RETURNING used`
+	var used int64
+	err := r.Pool.QueryRow(ctx, query, client, day, n, limit).Scan(&used)
+	if err == nil {
+		return used, true, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, err
+	}
+
+	used, err = r.GetQuotaUsage(ctx, client, day)
+	return used, false, err
+}
+
+// ReleaseQuota gives back n units consumed for day.
+func (r *QuotaRepo) ReleaseQuota(ctx context.Context, client string, day time.Time, n int64) error {
+	query := "UPDATE messaggio.client_quotas SET used = GREATEST(used - $3, 0) WHERE client = $1 AND day = $2"
+	_, err := r.Pool.Exec(ctx, query, client, day, n)
+	return err
+}
+
+func (r *QuotaRepo) GetQuotaUsage(ctx context.Context, client string, day time.Time) (int64, error) {
+	query := "SELECT used FROM messaggio.client_quotas WHERE client = $1 AND day = $2"
+	var used int64
+	err := r.Pool.QueryRow(ctx, query, client, day).Scan(&used)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	return used, err
+}
+
+func (r *QuotaRepo) DeleteQuotaUsage(ctx context.Context, before time.Time) (int64, error) {
+	query := "DELETE FROM messaggio.client_quotas WHERE day < $1"
+	tag, err := r.Pool.Exec(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,55 @@
+package pgdb_test
+
+import (
+	"context"
+	"messagio_testsuite/internal/repo/pgdb"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotaRepo(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	repo := pgdb.NewQuotaRepo(testDB)
+	ctx := context.Background()
+	today := time.Date(2024, 7, 2, 0, 0, 0, 0, time.UTC)
+	yesterday := today.AddDate(0, 0, -1)
+
+	for i := int64(1); i <= 2; i++ {
+		used, ok, err := repo.ConsumeQuota(ctx, "client", today, 1, 3)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, i, used)
+	}
+
+	used, ok, err := repo.ConsumeQuota(ctx, "client", today, 2, 3)
+	require.NoError(t, err)
+	assert.False(t, ok, "two units do not fit into the one left")
+	assert.Equal(t, int64(2), used)
+
+	require.NoError(t, repo.ReleaseQuota(ctx, "client", today, 1))
+	used, ok, err = repo.ConsumeQuota(ctx, "client", today, 2, 3)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), used)
+
+	_, ok, err = repo.ConsumeQuota(ctx, "client", yesterday, 4, 3)
+	require.NoError(t, err)
+	assert.False(t, ok, "more than the limit never fits")
+
+	_, ok, err = repo.ConsumeQuota(ctx, "client", yesterday, 3, 3)
+	require.NoError(t, err)
+	assert.True(t, ok, "every day has its own quota")
+
+	used, err = repo.GetQuotaUsage(ctx, "other", today)
+	require.NoError(t, err)
+	assert.Zero(t, used)
+
+	deleted, err := repo.DeleteQuotaUsage(ctx, today)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
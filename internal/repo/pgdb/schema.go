@@ -0,0 +1,37 @@
+package pgdb
+
+import (
+	"context"
+	"errors"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/pkg/postgres"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type SchemaRepo struct {
+	*postgres.Postgres
+}
+
+func NewSchemaRepo(pg *postgres.Postgres) *SchemaRepo {
+	return &SchemaRepo{pg}
+}
+
+func (r *SchemaRepo) Ping(ctx context.Context) error {
+	return r.Pool.Ping(ctx)
+}
+
+// GetSchemaVersion returns version 0 until the first migration is applied.
+func (r *SchemaRepo) GetSchemaVersion(ctx context.Context) (entity.SchemaVersion, error) {
+	var version entity.SchemaVersion
+	err := r.Pool.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version.Version, &version.Dirty)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.Is(err, pgx.ErrNoRows) || errors.As(err, &pgErr) && pgErr.Code == "42P01" {
+			return entity.SchemaVersion{}, nil
+		}
+		return entity.SchemaVersion{}, err
+	}
+	return version, nil
+}
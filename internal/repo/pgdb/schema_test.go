@@ -0,0 +1,33 @@
+package pgdb_test
+
+import (
+	"context"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/repo/pgdb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaRepo_GetSchemaVersion(t *testing.T) {
+	teardown := setupPostgres(t)
+	defer teardown()
+
+	schemaRepo := pgdb.NewSchemaRepo(testDB)
+	ctx := context.Background()
+
+	require.NoError(t, schemaRepo.Ping(ctx))
+
+	version, err := schemaRepo.GetSchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, entity.SchemaVersion{}, version, "nothing migrated yet")
+
+	_, err = testDB.Pool.Exec(ctx, `CREATE TABLE schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL);
+		INSERT INTO schema_migrations VALUES (10, false)`)
+	require.NoError(t, err)
+
+	version, err = schemaRepo.GetSchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, entity.SchemaVersion{Version: 10}, version)
+}
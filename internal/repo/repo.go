@@ -5,25 +5,79 @@ import (
 	"messagio_testsuite/internal/entity"
 	"messagio_testsuite/internal/repo/pgdb"
 	"messagio_testsuite/pkg/postgres"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type Message interface {
-	CreateMessage(ctx context.Context, message entity.Message) (uuid.UUID, error)
+	CreateMessage(ctx context.Context, message entity.Message, event entity.OutboxEvent) (uuid.UUID, error)
+	CreateMessages(ctx context.Context, messages []entity.Message, events []entity.OutboxEvent) error
 	GetMessageById(ctx context.Context, id uuid.UUID) (entity.Message, error)
-	GetMessages(ctx context.Context) ([]entity.Message, error)
-	MarkMessageAsProcessed(ctx context.Context, id uuid.UUID) error
+	GetMessages(ctx context.Context, filter entity.MessageFilter) ([]entity.Message, error)
+	UpdateMessageStatus(ctx context.Context, id uuid.UUID, version int, from, to entity.MessageStatus, reason string) error
+	GetMessageTransitions(ctx context.Context, id uuid.UUID) ([]entity.MessageTransition, error)
+	GetTransitionsAfter(ctx context.Context, after int64, limit int) ([]entity.MessageTransition, error)
+	GetTransitions(ctx context.Context, ids []int64) ([]entity.MessageTransition, error)
+	GetLastTransitionID(ctx context.Context) (int64, error)
 	GetProcessedMessagesStats(ctx context.Context) (int, error)
+	GetMessageCounts(ctx context.Context) (entity.MessageCounts, error)
+	GetProcessingLatency(ctx context.Context, from, to time.Time) (entity.ProcessingLatency, error)
+	GetThroughput(ctx context.Context, from, to time.Time, bucket entity.StatsBucket) ([]entity.ThroughputBucket, error)
 	GetMessageByContent(ctx context.Context, content string) (entity.Message, error)
 }
 
+type Outbox interface {
+	ClaimPendingEvents(ctx context.Context, limit int, lease time.Duration) ([]entity.OutboxEvent, error)
+	MarkEventsSent(ctx context.Context, ids []int64) error
+	MarkEventFailed(ctx context.Context, id int64, reason string, retryIn time.Duration) error
+	DeleteSentEvents(ctx context.Context, olderThan time.Duration) (int64, error)
+	GetBacklog(ctx context.Context) (entity.OutboxBacklog, error)
+}
+
+type Idempotency interface {
+	ReserveKey(ctx context.Context, client, key, fingerprint string, ttl, lease time.Duration) (entity.IdempotencyKey, bool, error)
+	GetKey(ctx context.Context, client, key string) (entity.IdempotencyKey, error)
+	CompleteKey(ctx context.Context, client, key string, statusCode int, response []byte) error
+	ReleaseKey(ctx context.Context, client, key string) error
+	DeleteExpiredKeys(ctx context.Context) (int64, error)
+}
+
+type APIKey interface {
+	CreateAPIKey(ctx context.Context, key entity.APIKey) (entity.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (entity.APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]entity.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id uuid.UUID) error
+}
+
+type Quota interface {
+	ConsumeQuota(ctx context.Context, client string, day time.Time, n, limit int64) (int64, bool, error)
+	ReleaseQuota(ctx context.Context, client string, day time.Time, n int64) error
+	GetQuotaUsage(ctx context.Context, client string, day time.Time) (int64, error)
+	DeleteQuotaUsage(ctx context.Context, before time.Time) (int64, error)
+}
+
+type Schema interface {
+	Ping(ctx context.Context) error
+	GetSchemaVersion(ctx context.Context) (entity.SchemaVersion, error)
+}
+
 type Repositories struct {
 	Message
+	Outbox
+	Idempotency
+	APIKey
+	Quota
+	Schema
 }
 
 func NewRepositories(pg *postgres.Postgres) *Repositories {
 	return &Repositories{
-		Message: pgdb.NewMessageRepo(pg),
+		Message:     pgdb.NewMessageRepo(pg),
+		Outbox:      pgdb.NewOutboxRepo(pg),
+		Idempotency: pgdb.NewIdempotencyRepo(pg),
+		APIKey:      pgdb.NewAPIKeyRepo(pg),
+		Quota:       pgdb.NewQuotaRepo(pg),
+		Schema:      pgdb.NewSchemaRepo(pg),
 	}
 }
@@ -7,4 +7,5 @@ var (
 	ErrAlreadyExists = errors.New("already exists")
 	ErrInsertFailed  = errors.New("failed to insert record")
 	ErrUpdateFailed  = errors.New("failed to update record")
+	ErrConflict      = errors.New("record was changed concurrently")
 )
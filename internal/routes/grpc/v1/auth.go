@@ -0,0 +1,107 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	messagev1 "messagio_testsuite/api/message/v1"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const metadataAPIKey = "x-api-key"
+
+// methodScopes is the scope each method requires, the counterpart of the
+// scopes NewMessageRoutes puts on the HTTP routes. Methods missing here are
+// refused.
+var methodScopes = map[string]entity.Scope{
+	messagev1.MessageService_CreateMessage_FullMethodName:        entity.ScopeMessagesWrite,
+	messagev1.MessageService_BatchCreateMessages_FullMethodName:  entity.ScopeMessagesWrite,
+	messagev1.MessageService_GetMessage_FullMethodName:           entity.ScopeMessagesRead,
+	messagev1.MessageService_ListMessages_FullMethodName:         entity.ScopeMessagesRead,
+	messagev1.MessageService_GetStats_FullMethodName:             entity.ScopeMessagesRead,
+	messagev1.MessageService_WatchMessages_FullMethodName:        entity.ScopeMessagesRead,
+	messagev1.MessageService_MarkMessageProcessed_FullMethodName: entity.ScopeMessagesAdmin,
+}
+
+type principalKey struct{}
+
+// Authenticator checks the x-api-key or authorization metadata of calls the
+// way the HTTP Authenticator checks headers.
+type Authenticator struct {
+	auth service.Auth
+}
+
+func NewAuthenticator(auth service.Auth) *Authenticator {
+	return &Authenticator{auth: auth}
+}
+
+func (a *Authenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (a *Authenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (a *Authenticator) authorize(ctx context.Context, method string) (context.Context, error) {
+	scope, ok := methodScopes[method]
+	if !ok {
+		return nil, toStatus(fmt.Errorf("%w: %s is not open to callers", serviceerrs.ErrForbidden, method))
+	}
+
+	principal, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if !principal.HasScope(scope) {
+		return nil, toStatus(fmt.Errorf("%w: %s is required", serviceerrs.ErrForbidden, scope))
+	}
+	return context.WithValue(ctx, principalKey{}, principal), nil
+}
+
+func (a *Authenticator) authenticate(ctx context.Context) (entity.Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get(metadataAPIKey); len(keys) > 0 && keys[0] != "" {
+		return a.auth.AuthenticateAPIKey(ctx, keys[0])
+	}
+
+	if values := md.Get("authorization"); len(values) > 0 {
+		scheme, token, ok := strings.Cut(values[0], " ")
+		if ok && strings.EqualFold(scheme, "Bearer") && token != "" {
+			return a.auth.AuthenticateToken(ctx, strings.TrimSpace(token))
+		}
+	}
+	return entity.Principal{}, serviceerrs.ErrUnauthenticated
+}
+
+// PrincipalFrom returns the caller authenticated by the interceptors.
+func PrincipalFrom(ctx context.Context) (entity.Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(entity.Principal)
+	return principal, ok
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
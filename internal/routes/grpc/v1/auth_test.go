@@ -0,0 +1,79 @@
+package v1_test
+
+import (
+	"context"
+	messagev1 "messagio_testsuite/api/message/v1"
+	"messagio_testsuite/internal/entity"
+	v1 "messagio_testsuite/internal/routes/grpc/v1"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type stubAuth map[string]entity.Principal
+
+func (a stubAuth) AuthenticateAPIKey(_ context.Context, key string) (entity.Principal, error) {
+	if principal, ok := a[key]; ok {
+		return principal, nil
+	}
+	return entity.Principal{}, serviceerrs.ErrUnauthenticated
+}
+
+func (a stubAuth) AuthenticateToken(_ context.Context, token string) (entity.Principal, error) {
+	return a.AuthenticateAPIKey(context.Background(), token)
+}
+
+func setupWithAuth(t *testing.T) (messagev1.MessageServiceClient, *MockMessageService, *MockEventsService) {
+	auth := v1.NewAuthenticator(stubAuth{
+		"mk_writer": {Subject: "writer", Scopes: []entity.Scope{entity.ScopeMessagesWrite}},
+		"token":     {Subject: "reader", Scopes: []entity.Scope{entity.ScopeMessagesRead}},
+	})
+	return setup(t,
+		grpc.ChainUnaryInterceptor(auth.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(auth.StreamInterceptor()),
+	)
+}
+
+func TestAuthenticator_Unary(t *testing.T) {
+	client, mockService, _ := setupWithAuth(t)
+
+	id := uuid.New()
+	mockService.On("CreateMessage", mock.Anything, "Hello, world!").Return(id, nil)
+
+	req := &messagev1.CreateMessageRequest{Message: "Hello, world!"}
+	_, err := client.CreateMessage(context.Background(), req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "mk_unknown")
+	_, err = client.CreateMessage(ctx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer token")
+	_, err = client.CreateMessage(ctx, req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "mk_writer")
+	resp, err := client.CreateMessage(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, id.String(), resp.GetId())
+
+	mockService.AssertExpectations(t)
+}
+
+func TestAuthenticator_Stream(t *testing.T) {
+	client, _, _ := setupWithAuth(t)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "mk_writer")
+	stream, err := client.WatchMessages(ctx, &messagev1.WatchMessagesRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
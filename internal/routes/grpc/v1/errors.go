@@ -0,0 +1,27 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// toStatus maps service errors to gRPC status codes with serviceerrs.Mappings,
+// the table the HTTP routes use as well. Unknown errors are not leaked to the
+// client.
+func toStatus(err error) error {
+	if m, ok := serviceerrs.Lookup(err); ok {
+		return status.Error(m.Code, m.Detail(err))
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return status.Error(codes.Internal, "internal server error")
+}
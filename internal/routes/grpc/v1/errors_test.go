@@ -0,0 +1,55 @@
+package v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestToStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		code    codes.Code
+		message string
+	}{
+		{
+			name:    "client sentinel",
+			err:     fmt.Errorf("create: %w", serviceerrs.ErrIdempotencyKeyInFlight),
+			code:    codes.Aborted,
+			message: "create: " + serviceerrs.ErrIdempotencyKeyInFlight.Error(),
+		},
+		{
+			name:    "server sentinel hides its cause",
+			err:     fmt.Errorf("%w: dial tcp: connection refused", serviceerrs.ErrCannotGetStats),
+			code:    codes.Internal,
+			message: serviceerrs.ErrCannotGetStats.Error(),
+		},
+		{
+			name:    "context",
+			err:     context.DeadlineExceeded,
+			code:    codes.DeadlineExceeded,
+			message: context.DeadlineExceeded.Error(),
+		},
+		{
+			name:    "unknown error hides its text",
+			err:     errors.New("pq: connection refused"),
+			code:    codes.Internal,
+			message: "internal server error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := status.Convert(toStatus(tt.err))
+			assert.Equal(t, tt.code, st.Code())
+			assert.Equal(t, tt.message, st.Message())
+		})
+	}
+}
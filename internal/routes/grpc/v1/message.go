@@ -0,0 +1,267 @@
+package v1
+
+import (
+	"context"
+	messagev1 "messagio_testsuite/api/message/v1"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type MessageServer struct {
+	messagev1.UnimplementedMessageServiceServer
+
+	MessageService service.Message
+	EventsService  service.Events
+
+	throttle *Throttler
+}
+
+func NewMessageServer(s *grpc.Server, MessageService service.Message, EventsService service.Events, RateLimitService service.RateLimit) {
+	messagev1.RegisterMessageServiceServer(s, &MessageServer{
+		MessageService: MessageService,
+		EventsService:  EventsService,
+		throttle:       NewThrottler(RateLimitService),
+	})
+}
+
+func (s *MessageServer) CreateMessage(ctx context.Context, req *messagev1.CreateMessageRequest) (*messagev1.CreateMessageResponse, error) {
+	if req.GetMessage() == "" {
+		return nil, status.Error(codes.InvalidArgument, "message is required")
+	}
+
+	if err := s.throttle.ChargeQuota(ctx, 1); err != nil {
+		return nil, err
+	}
+
+	id, err := s.MessageService.CreateMessage(ctx, req.GetMessage())
+	if err != nil {
+		s.throttle.RefundQuota(ctx, 1)
+		return nil, toStatus(err)
+	}
+	return &messagev1.CreateMessageResponse{Id: id.String()}, nil
+}
+
+func (s *MessageServer) BatchCreateMessages(ctx context.Context, req *messagev1.BatchCreateMessagesRequest) (*messagev1.BatchCreateMessagesResponse, error) {
+	if len(req.GetMessages()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty batch")
+	}
+	if len(req.GetMessages()) > service.MaxBatchSize {
+		return nil, toStatus(serviceerrs.ErrBatchTooLarge)
+	}
+
+	if err := s.throttle.ChargeQuota(ctx, len(req.GetMessages())); err != nil {
+		return nil, err
+	}
+
+	results, err := s.MessageService.CreateMessages(ctx, req.GetMessages())
+	if err != nil {
+		s.throttle.RefundQuota(ctx, len(req.GetMessages()))
+		return nil, toStatus(err)
+	}
+
+	resp := &messagev1.BatchCreateMessagesResponse{Results: make([]*messagev1.CreateMessageResult, len(results))}
+	for i, result := range results {
+		item := &messagev1.CreateMessageResult{Index: int32(result.Index), Error: result.Error}
+		if result.ID != nil {
+			item.Id = result.ID.String()
+		}
+		if result.Error != "" {
+			resp.Failed++
+		} else {
+			resp.Created++
+		}
+		resp.Results[i] = item
+	}
+	s.throttle.RefundQuota(ctx, int(resp.Failed))
+	return resp, nil
+}
+
+func (s *MessageServer) GetMessage(ctx context.Context, req *messagev1.GetMessageRequest) (*messagev1.Message, error) {
+	id, err := parseID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := s.MessageService.GetMessageById(ctx, id)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toProtoMessage(message), nil
+}
+
+func (s *MessageServer) ListMessages(ctx context.Context, req *messagev1.ListMessagesRequest) (*messagev1.ListMessagesResponse, error) {
+	input := service.GetMessagesInput{
+		Status:        entity.MessageStatus(req.GetStatus()),
+		Processed:     req.Processed,
+		CreatedFrom:   fromTimestamp(req.GetCreatedFrom()),
+		CreatedTo:     fromTimestamp(req.GetCreatedTo()),
+		ProcessedFrom: fromTimestamp(req.GetProcessedFrom()),
+		ProcessedTo:   fromTimestamp(req.GetProcessedTo()),
+		Query:         req.GetQuery(),
+		Order:         entity.SortOrder(req.GetOrder()),
+		Limit:         int(req.GetLimit()),
+		Cursor:        req.GetCursor(),
+	}
+
+	page, err := s.MessageService.GetMessages(ctx, input)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &messagev1.ListMessagesResponse{
+		Messages:   make([]*messagev1.Message, len(page.Messages)),
+		NextCursor: page.NextCursor,
+	}
+	for i, message := range page.Messages {
+		resp.Messages[i] = toProtoMessage(message)
+	}
+	return resp, nil
+}
+
+func (s *MessageServer) GetStats(ctx context.Context, req *messagev1.GetStatsRequest) (*messagev1.Stats, error) {
+	stats, err := s.MessageService.GetStats(ctx, service.GetStatsInput{
+		From:   fromTimestamp(req.GetFrom()),
+		To:     fromTimestamp(req.GetTo()),
+		Bucket: entity.StatsBucket(req.GetBucket()),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &messagev1.Stats{
+		Counts: &messagev1.MessageCounts{
+			Total:        stats.Counts.Total,
+			Processed:    stats.Counts.Processed,
+			Pending:      stats.Counts.Pending,
+			Failed:       stats.Counts.Failed,
+			DeadLettered: stats.Counts.DeadLettered,
+		},
+		Latency: &messagev1.ProcessingLatency{
+			Samples: stats.Latency.Samples,
+			P50Ms:   stats.Latency.P50,
+			P95Ms:   stats.Latency.P95,
+			P99Ms:   stats.Latency.P99,
+		},
+		From:       timestamppb.New(stats.From),
+		To:         timestamppb.New(stats.To),
+		Bucket:     string(stats.Bucket),
+		Throughput: make([]*messagev1.ThroughputBucket, len(stats.Throughput)),
+	}
+	for i, bucket := range stats.Throughput {
+		resp.Throughput[i] = &messagev1.ThroughputBucket{
+			Start:     timestamppb.New(bucket.Start),
+			Created:   bucket.Created,
+			Processed: bucket.Processed,
+		}
+	}
+	return resp, nil
+}
+
+func (s *MessageServer) MarkMessageProcessed(ctx context.Context, req *messagev1.MarkMessageProcessedRequest) (*messagev1.MarkMessageProcessedResponse, error) {
+	id, err := parseID(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetVersion() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid version")
+	}
+
+	if _, err := s.MessageService.MarkMessageAsProcessed(ctx, id, int(req.GetVersion())); err != nil {
+		return nil, toStatus(err)
+	}
+	return &messagev1.MarkMessageProcessedResponse{}, nil
+}
+
+// WatchMessages streams message events like the SSE endpoint does, replaying
+// the logged events after after_event_id first.
+func (s *MessageServer) WatchMessages(req *messagev1.WatchMessagesRequest, stream messagev1.MessageService_WatchMessagesServer) error {
+	filter := service.EventFilter{After: req.GetAfterEventId()}
+	if req.GetId() != "" {
+		id, err := parseID(req.GetId())
+		if err != nil {
+			return err
+		}
+		filter.MessageID = &id
+	}
+
+	ctx := stream.Context()
+	replay, events := s.EventsService.Subscribe(ctx, filter)
+	for _, event := range replay {
+		if err := stream.Send(toProtoEvent(event)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.Unavailable, "event stream closed")
+			}
+			if err := stream.Send(toProtoEvent(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func parseID(s string) (uuid.UUID, error) {
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, status.Error(codes.InvalidArgument, "invalid id format")
+	}
+	return id, nil
+}
+
+func toProtoMessage(message entity.Message) *messagev1.Message {
+	return &messagev1.Message{
+		Id:             message.ID.String(),
+		Message:        message.Message,
+		CreatedAt:      timestamppb.New(message.CreatedAt),
+		Status:         string(message.Status),
+		Processed:      message.Processed,
+		ProcessedAt:    toTimestamp(message.ProcessedAt),
+		PublishedAt:    toTimestamp(message.PublishedAt),
+		ProcessingAt:   toTimestamp(message.ProcessingAt),
+		FailedAt:       toTimestamp(message.FailedAt),
+		DeadLetteredAt: toTimestamp(message.DeadLetteredAt),
+		LastError:      message.LastError,
+		Version:        int32(message.Version),
+	}
+}
+
+func toProtoEvent(event entity.MessageEvent) *messagev1.MessageEvent {
+	return &messagev1.MessageEvent{
+		Id:        event.ID,
+		Type:      string(event.Type),
+		MessageId: event.MessageID.String(),
+		Status:    string(event.Status),
+		Error:     event.Error,
+		CreatedAt: timestamppb.New(event.CreatedAt),
+	}
+}
+
+func toTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func fromTimestamp(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
@@ -0,0 +1,336 @@
+package v1_test
+
+import (
+	"context"
+	messagev1 "messagio_testsuite/api/message/v1"
+	"messagio_testsuite/internal/entity"
+	v1 "messagio_testsuite/internal/routes/grpc/v1"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"messagio_testsuite/pkg/kafka"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type MockMessageService struct {
+	mock.Mock
+}
+
+func (m *MockMessageService) CreateMessage(ctx context.Context, content string) (uuid.UUID, error) {
+	args := m.Called(ctx, content)
+	return args.Get(0).(uuid.UUID), args.Error(1)
+}
+
+func (m *MockMessageService) CreateMessages(ctx context.Context, contents []string) ([]service.CreateMessageResult, error) {
+	args := m.Called(ctx, contents)
+	results, _ := args.Get(0).([]service.CreateMessageResult)
+	return results, args.Error(1)
+}
+
+func (m *MockMessageService) GetMessageById(ctx context.Context, id uuid.UUID) (entity.Message, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(entity.Message), args.Error(1)
+}
+
+func (m *MockMessageService) GetMessages(ctx context.Context, input service.GetMessagesInput) (service.MessagesPage, error) {
+	args := m.Called(ctx, input)
+	return args.Get(0).(service.MessagesPage), args.Error(1)
+}
+
+func (m *MockMessageService) MarkMessageAsProcessed(ctx context.Context, id uuid.UUID, version int) (int, error) {
+	args := m.Called(ctx, id, version)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockMessageService) GetMessageHistory(ctx context.Context, id uuid.UUID) ([]entity.MessageTransition, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).([]entity.MessageTransition), args.Error(1)
+}
+
+func (m *MockMessageService) GetProcessedMessagesStats(ctx context.Context) (int, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int), args.Error(1)
+}
+
+func (m *MockMessageService) GetStats(ctx context.Context, input service.GetStatsInput) (entity.MessageStats, error) {
+	args := m.Called(ctx, input)
+	return args.Get(0).(entity.MessageStats), args.Error(1)
+}
+
+func (m *MockMessageService) GetConsumerStats() kafka.ConsumerStats {
+	args := m.Called()
+	return args.Get(0).(kafka.ConsumerStats)
+}
+
+type MockEventsService struct {
+	mock.Mock
+}
+
+func (m *MockEventsService) Subscribe(ctx context.Context, filter service.EventFilter) ([]entity.MessageEvent, <-chan entity.MessageEvent) {
+	args := m.Called(ctx, filter)
+	replay, _ := args.Get(0).([]entity.MessageEvent)
+	return replay, args.Get(1).(chan entity.MessageEvent)
+}
+
+func (m *MockEventsService) Close() {
+	m.Called()
+}
+
+// memoryQuota keeps quota usage in memory.
+type memoryQuota struct {
+	mu   sync.Mutex
+	used int64
+}
+
+func (m *memoryQuota) ConsumeQuota(_ context.Context, _ string, _ time.Time, n, limit int64) (int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.used+n > limit {
+		return m.used, false, nil
+	}
+	m.used += n
+	return m.used, true, nil
+}
+
+func (m *memoryQuota) ReleaseQuota(_ context.Context, _ string, _ time.Time, n int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.used = max(m.used-n, 0)
+	return nil
+}
+
+func (m *memoryQuota) GetQuotaUsage(context.Context, string, time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.used, nil
+}
+
+func (m *memoryQuota) DeleteQuotaUsage(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func setup(t *testing.T, opts ...grpc.ServerOption) (messagev1.MessageServiceClient, *MockMessageService, *MockEventsService) {
+	return setupWithLimits(t, service.NewRateLimitService(nil, service.RateLimitConfig{}), opts...)
+}
+
+func setupWithLimits(t *testing.T, limits service.RateLimit, opts ...grpc.ServerOption) (messagev1.MessageServiceClient, *MockMessageService, *MockEventsService) {
+	mockService := new(MockMessageService)
+	mockEvents := new(MockEventsService)
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(opts...)
+	v1.NewMessageServer(server, mockService, mockEvents, limits)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return messagev1.NewMessageServiceClient(conn), mockService, mockEvents
+}
+
+func TestCreateMessage(t *testing.T) {
+	client, mockService, _ := setup(t)
+
+	id := uuid.New()
+	mockService.On("CreateMessage", mock.Anything, "Hello, world!").Return(id, nil)
+
+	resp, err := client.CreateMessage(context.Background(), &messagev1.CreateMessageRequest{Message: "Hello, world!"})
+	require.NoError(t, err)
+	assert.Equal(t, id.String(), resp.GetId())
+
+	_, err = client.CreateMessage(context.Background(), &messagev1.CreateMessageRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	mockService.AssertExpectations(t)
+}
+
+func TestBatchCreateMessages(t *testing.T) {
+	client, mockService, _ := setup(t)
+
+	id := uuid.New()
+	mockService.On("CreateMessages", mock.Anything, []string{"first", ""}).Return([]service.CreateMessageResult{
+		{Index: 0, ID: &id},
+		{Index: 1, Error: serviceerrs.ErrEmptyMessage.Error()},
+	}, nil)
+
+	resp, err := client.BatchCreateMessages(context.Background(), &messagev1.BatchCreateMessagesRequest{Messages: []string{"first", ""}})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), resp.GetCreated())
+	assert.Equal(t, int32(1), resp.GetFailed())
+	require.Len(t, resp.GetResults(), 2)
+	assert.Equal(t, id.String(), resp.GetResults()[0].GetId())
+	assert.Equal(t, "message is required", resp.GetResults()[1].GetError())
+
+	mockService.On("CreateMessages", mock.Anything, mock.Anything).Return(nil, serviceerrs.ErrBatchTooLarge)
+	_, err = client.BatchCreateMessages(context.Background(), &messagev1.BatchCreateMessagesRequest{Messages: []string{"a", "b", "c"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestBatchCreateMessages_ChargesQuotaPerMessage(t *testing.T) {
+	quota := &memoryQuota{}
+	client, mockService, _ := setupWithLimits(t, service.NewRateLimitService(quota, service.RateLimitConfig{DailyQuota: 3}))
+
+	id := uuid.New()
+	mockService.On("CreateMessages", mock.Anything, []string{"first", ""}).Return([]service.CreateMessageResult{
+		{Index: 0, ID: &id},
+		{Index: 1, Error: serviceerrs.ErrEmptyMessage.Error()},
+	}, nil).Once()
+
+	_, err := client.BatchCreateMessages(context.Background(), &messagev1.BatchCreateMessagesRequest{Messages: []string{"first", ""}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), quota.used, "the failed message is given back")
+
+	_, err = client.BatchCreateMessages(context.Background(), &messagev1.BatchCreateMessagesRequest{Messages: []string{"a", "b", "c"}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "three messages do not fit into the two left")
+	assert.Equal(t, int64(1), quota.used)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestGetMessage(t *testing.T) {
+	client, mockService, _ := setup(t)
+
+	id := uuid.New()
+	createdAt := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	mockService.On("GetMessageById", mock.Anything, id).Return(entity.Message{
+		ID: id, Message: "Hello, world!", CreatedAt: createdAt, Status: entity.StatusReceived, Version: 1,
+	}, nil)
+
+	message, err := client.GetMessage(context.Background(), &messagev1.GetMessageRequest{Id: id.String()})
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, world!", message.GetMessage())
+	assert.Equal(t, createdAt, message.GetCreatedAt().AsTime())
+	assert.Equal(t, "received", message.GetStatus())
+	assert.Nil(t, message.GetProcessedAt())
+	assert.Equal(t, int32(1), message.GetVersion())
+
+	_, err = client.GetMessage(context.Background(), &messagev1.GetMessageRequest{Id: "not-a-uuid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	missing := uuid.New()
+	mockService.On("GetMessageById", mock.Anything, missing).Return(entity.Message{}, serviceerrs.ErrMessageNotFound)
+	_, err = client.GetMessage(context.Background(), &messagev1.GetMessageRequest{Id: missing.String()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestListMessages(t *testing.T) {
+	client, mockService, _ := setup(t)
+
+	processed := false
+	input := service.GetMessagesInput{Processed: &processed, Order: entity.SortAsc, Limit: 10, Cursor: "abc"}
+	mockService.On("GetMessages", mock.Anything, input).Return(service.MessagesPage{
+		Messages:   []entity.Message{{ID: uuid.New(), Message: "Hello, world!"}},
+		NextCursor: "def",
+	}, nil)
+
+	resp, err := client.ListMessages(context.Background(), &messagev1.ListMessagesRequest{
+		Processed: &processed, Order: "asc", Limit: 10, Cursor: "abc",
+	})
+	require.NoError(t, err)
+	assert.Len(t, resp.GetMessages(), 1)
+	assert.Equal(t, "def", resp.GetNextCursor())
+
+	mockService.On("GetMessages", mock.Anything, mock.Anything).Return(service.MessagesPage{}, serviceerrs.ErrInvalidCursor)
+	_, err = client.ListMessages(context.Background(), &messagev1.ListMessagesRequest{Cursor: "bad"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGetStats(t *testing.T) {
+	client, mockService, _ := setup(t)
+
+	to := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	from := to.Add(-time.Hour)
+	mockService.On("GetStats", mock.Anything, service.GetStatsInput{From: &from, To: &to, Bucket: entity.StatsBucketMinute}).Return(entity.MessageStats{
+		Counts:     entity.MessageCounts{Total: 3, Processed: 2, Pending: 1},
+		Latency:    entity.ProcessingLatency{Samples: 2, P50: 12.5},
+		From:       from,
+		To:         to,
+		Bucket:     entity.StatsBucketMinute,
+		Throughput: []entity.ThroughputBucket{{Start: from, Created: 3, Processed: 2}},
+	}, nil)
+
+	stats, err := client.GetStats(context.Background(), &messagev1.GetStatsRequest{
+		From: timestamppb.New(from), To: timestamppb.New(to), Bucket: "minute",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.GetCounts().GetTotal())
+	assert.Equal(t, 12.5, stats.GetLatency().GetP50Ms())
+	require.Len(t, stats.GetThroughput(), 1)
+	assert.Equal(t, int64(2), stats.GetThroughput()[0].GetProcessed())
+}
+
+func TestMarkMessageProcessed(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{name: "ok", code: codes.OK},
+		{name: "not found", err: serviceerrs.ErrMessageNotFound, code: codes.NotFound},
+		{name: "already processed", err: serviceerrs.ErrMessageAlreadyProcessed, code: codes.FailedPrecondition},
+		{name: "version mismatch", err: serviceerrs.ErrVersionMismatch, code: codes.Aborted},
+		{name: "internal", err: serviceerrs.ErrCannotGetMessage, code: codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, mockService, _ := setup(t)
+
+			id := uuid.New()
+			mockService.On("MarkMessageAsProcessed", mock.Anything, id, 2).Return(0, tt.err)
+
+			_, err := client.MarkMessageProcessed(context.Background(), &messagev1.MarkMessageProcessedRequest{Id: id.String(), Version: 2})
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestWatchMessages(t *testing.T) {
+	client, _, mockEvents := setup(t)
+
+	id := uuid.New()
+	events := make(chan entity.MessageEvent, 1)
+	mockEvents.On("Subscribe", mock.Anything, service.EventFilter{After: 3, MessageID: &id}).Return(
+		[]entity.MessageEvent{{ID: 4, Type: entity.EventCreated, MessageID: id, Status: entity.StatusReceived}},
+		events,
+	)
+
+	stream, err := client.WatchMessages(context.Background(), &messagev1.WatchMessagesRequest{Id: id.String(), AfterEventId: 3})
+	require.NoError(t, err)
+
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), event.GetId())
+	assert.Equal(t, "created", event.GetType())
+
+	events <- entity.MessageEvent{ID: 5, Type: entity.EventProcessed, MessageID: id, Status: entity.StatusProcessed}
+	event, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), event.GetId())
+	assert.Equal(t, id.String(), event.GetMessageId())
+
+	close(events)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,84 @@
+package v1
+
+import (
+	"context"
+	"fmt"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// Throttler applies the rate limit to every call, like the HTTP Throttler.
+// Its interceptors go after the Authenticator ones, the daily quota is charged
+// by the handlers for the messages they create.
+type Throttler struct {
+	limits service.RateLimit
+}
+
+func NewThrottler(limits service.RateLimit) *Throttler {
+	return &Throttler{limits: limits}
+}
+
+func (t *Throttler) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := t.throttle(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (t *Throttler) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := t.throttle(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (t *Throttler) throttle(ctx context.Context) error {
+	if result := t.limits.Allow(clientKey(ctx)); !result.Allowed {
+		return toStatus(fmt.Errorf("%w, retry in %s", serviceerrs.ErrRateLimited, result.RetryAfter.Round(time.Millisecond)))
+	}
+	return nil
+}
+
+// ChargeQuota counts n messages against the daily quota of the caller, all of
+// them or none.
+func (t *Throttler) ChargeQuota(ctx context.Context, n int) error {
+	usage, err := t.limits.ConsumeQuota(ctx, clientKey(ctx), n)
+	if err != nil {
+		if usage != nil {
+			err = fmt.Errorf("%w, resets at %s", err, usage.ResetsAt.Format(time.RFC3339))
+		}
+		return toStatus(err)
+	}
+	return nil
+}
+
+// RefundQuota gives back the quota of n charged messages that were not
+// created.
+func (t *Throttler) RefundQuota(ctx context.Context, n int) {
+	t.limits.ReleaseQuota(ctx, clientKey(ctx), n)
+}
+
+// clientKey matches the keys of the HTTP routes, so a client shares its limits
+// across both.
+func clientKey(ctx context.Context) string {
+	if principal, ok := PrincipalFrom(ctx); ok {
+		return "principal:" + principal.Subject
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		return "ip:" + host
+	}
+	return "ip:"
+}
@@ -0,0 +1,84 @@
+package v1
+
+import (
+	"messagio_testsuite/internal/entity"
+	routeerrs "messagio_testsuite/internal/routes/http/v1/route_errors"
+	"messagio_testsuite/internal/service"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type APIKeyRoutes struct {
+	APIKeysService service.APIKeys
+}
+
+func NewAPIKeyRoutes(g *echo.Group, APIKeysService service.APIKeys, AuthService service.Auth) {
+	r := &APIKeyRoutes{
+		APIKeysService: APIKeysService,
+	}
+
+	admin := NewAuthenticator(AuthService).Require(entity.ScopeMessagesAdmin)
+
+	g.POST("/admin/api-keys", r.Create, admin)
+	g.GET("/admin/api-keys", r.GetAll, admin)
+	g.DELETE("/admin/api-keys/:id", r.Revoke, admin)
+}
+
+// Create answers with the generated key. It is the only time the secret is
+// shown.
+func (r *APIKeyRoutes) Create(c echo.Context) error {
+	type request struct {
+		Name   string         `json:"name" validate:"required"`
+		Scopes []entity.Scope `json:"scopes" validate:"required"`
+	}
+	var req request
+	if err := c.Bind(&req); err != nil {
+		return routeerrs.BadRequest("invalid request body", err)
+	}
+
+	if err := c.Validate(&req); err != nil {
+		return routeerrs.BadRequest(err.Error(), err)
+	}
+
+	apiKey, secret, err := r.APIKeysService.CreateAPIKey(c.Request().Context(), service.CreateAPIKeyInput{
+		Name:   req.Name,
+		Scopes: req.Scopes,
+	})
+	if err != nil {
+		return err
+	}
+
+	type response struct {
+		Key    string        `json:"key"`
+		APIKey entity.APIKey `json:"api_key"`
+	}
+
+	return c.JSON(http.StatusCreated, response{
+		Key:    secret,
+		APIKey: apiKey,
+	})
+}
+
+func (r *APIKeyRoutes) GetAll(c echo.Context) error {
+	keys, err := r.APIKeysService.GetAPIKeys(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+func (r *APIKeyRoutes) Revoke(c echo.Context) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return routeerrs.BadRequest("invalid id format", err)
+	}
+
+	if err := r.APIKeysService.RevokeAPIKey(c.Request().Context(), id); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,69 @@
+package v1
+
+import (
+	"fmt"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderAPIKey = "X-API-Key"
+
+	principalContextKey = "principal"
+	authRealm           = "messaggio"
+)
+
+// Authenticator resolves the caller of a request with an API key from the
+// X-API-Key header or a bearer token, and checks its scopes.
+type Authenticator struct {
+	auth service.Auth
+}
+
+func NewAuthenticator(auth service.Auth) *Authenticator {
+	return &Authenticator{auth: auth}
+}
+
+// Require answers 401 to requests without valid credentials and 403 to
+// callers missing scope.
+func (a *Authenticator) Require(scope entity.Scope) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, err := a.authenticate(c)
+			if err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, fmt.Sprintf("Bearer realm=%q", authRealm))
+				return err
+			}
+			if !principal.HasScope(scope) {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate,
+					fmt.Sprintf("Bearer realm=%q, error=\"insufficient_scope\", scope=%q", authRealm, scope))
+				return fmt.Errorf("%w: %s is required", serviceerrs.ErrForbidden, scope)
+			}
+
+			c.Set(principalContextKey, principal)
+			return next(c)
+		}
+	}
+}
+
+func (a *Authenticator) authenticate(c echo.Context) (entity.Principal, error) {
+	req := c.Request()
+	if key := req.Header.Get(HeaderAPIKey); key != "" {
+		return a.auth.AuthenticateAPIKey(req.Context(), key)
+	}
+
+	scheme, token, ok := strings.Cut(req.Header.Get(echo.HeaderAuthorization), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") && token != "" {
+		return a.auth.AuthenticateToken(req.Context(), strings.TrimSpace(token))
+	}
+	return entity.Principal{}, serviceerrs.ErrUnauthenticated
+}
+
+// PrincipalFrom returns the caller authenticated by Require.
+func PrincipalFrom(c echo.Context) (entity.Principal, bool) {
+	principal, ok := c.Get(principalContextKey).(entity.Principal)
+	return principal, ok
+}
@@ -0,0 +1,113 @@
+package v1_test
+
+import (
+	"context"
+	"messagio_testsuite/internal/entity"
+	v1 "messagio_testsuite/internal/routes/http/v1"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const (
+	testAdminKey  = "mk_admin"
+	testReaderKey = "mk_reader"
+	testToken     = "token"
+)
+
+// stubAuth knows a fixed set of API keys and bearer tokens.
+type stubAuth map[string]entity.Principal
+
+var testAuth = stubAuth{
+	testAdminKey:  {Subject: "admin", Scopes: []entity.Scope{entity.ScopeMessagesAdmin}},
+	testReaderKey: {Subject: "reader", Scopes: []entity.Scope{entity.ScopeMessagesRead}},
+	testToken:     {Subject: "writer", Scopes: []entity.Scope{entity.ScopeMessagesWrite}},
+}
+
+func (a stubAuth) AuthenticateAPIKey(_ context.Context, key string) (entity.Principal, error) {
+	if principal, ok := a[key]; ok && key != testToken {
+		return principal, nil
+	}
+	return entity.Principal{}, serviceerrs.ErrUnauthenticated
+}
+
+func (a stubAuth) AuthenticateToken(_ context.Context, token string) (entity.Principal, error) {
+	if principal, ok := a[token]; ok && token == testToken {
+		return principal, nil
+	}
+	return entity.Principal{}, serviceerrs.ErrUnauthenticated
+}
+
+type MockAPIKeysService struct {
+	mock.Mock
+}
+
+func (m *MockAPIKeysService) CreateAPIKey(ctx context.Context, input service.CreateAPIKeyInput) (entity.APIKey, string, error) {
+	args := m.Called(ctx, input)
+	return args.Get(0).(entity.APIKey), args.String(1), args.Error(2)
+}
+
+func (m *MockAPIKeysService) GetAPIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	args := m.Called(ctx)
+	keys, _ := args.Get(0).([]entity.APIKey)
+	return keys, args.Error(1)
+}
+
+func (m *MockAPIKeysService) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func TestAuthenticator_Require(t *testing.T) {
+	tests := []struct {
+		name          string
+		scope         entity.Scope
+		header        map[string]string
+		status        int
+		subject       string
+		authenticated bool
+	}{
+		{name: "no credentials", scope: entity.ScopeMessagesRead, status: http.StatusUnauthorized},
+		{name: "unknown key", scope: entity.ScopeMessagesRead, header: map[string]string{v1.HeaderAPIKey: "mk_unknown"}, status: http.StatusUnauthorized},
+		{name: "key with scope", scope: entity.ScopeMessagesRead, header: map[string]string{v1.HeaderAPIKey: testReaderKey}, status: http.StatusOK, subject: "reader"},
+		{name: "key without scope", scope: entity.ScopeMessagesWrite, header: map[string]string{v1.HeaderAPIKey: testReaderKey}, status: http.StatusForbidden},
+		{name: "admin has every scope", scope: entity.ScopeMessagesWrite, header: map[string]string{v1.HeaderAPIKey: testAdminKey}, status: http.StatusOK, subject: "admin"},
+		{name: "bearer token", scope: entity.ScopeMessagesWrite, header: map[string]string{echo.HeaderAuthorization: "Bearer " + testToken}, status: http.StatusOK, subject: "writer"},
+		{name: "other scheme", scope: entity.ScopeMessagesWrite, header: map[string]string{echo.HeaderAuthorization: "Basic " + testToken}, status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _, _ := setup()
+			e.GET("/protected", func(c echo.Context) error {
+				principal, ok := v1.PrincipalFrom(c)
+				assert.True(t, ok)
+				return c.String(http.StatusOK, principal.Subject)
+			}, v1.NewAuthenticator(testAuth).Require(tt.scope))
+
+			req := httptest.NewRequest(http.MethodGet, "/protected", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code)
+			switch tt.status {
+			case http.StatusOK:
+				assert.Equal(t, tt.subject, rec.Body.String())
+			case http.StatusForbidden:
+				assert.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `error="insufficient_scope"`)
+			default:
+				assert.NotEmpty(t, rec.Header().Get(echo.HeaderWWWAuthenticate))
+			}
+		})
+	}
+}
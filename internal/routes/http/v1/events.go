@@ -0,0 +1,99 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"messagio_testsuite/internal/entity"
+	routeerrs "messagio_testsuite/internal/routes/http/v1/route_errors"
+	"messagio_testsuite/internal/service"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderLastEventID   = "Last-Event-ID"
+	MIMETextEventStream = "text/event-stream"
+
+	sseHeartbeatInterval = 15 * time.Second
+)
+
+// Events streams message events as Server-Sent Events. The optional id query
+// parameter limits the stream to one message. Clients resume with the
+// Last-Event-ID header (or the last_event_id query parameter) and get the
+// events they missed, on this or any other instance.
+func (r *MessageRoutes) Events(c echo.Context) error {
+	var filter service.EventFilter
+	if idStr := c.QueryParam("id"); idStr != "" {
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			return routeerrs.BadRequest("invalid id format", err)
+		}
+		filter.MessageID = &id
+	}
+
+	lastEventID := c.Request().Header.Get(HeaderLastEventID)
+	if lastEventID == "" {
+		lastEventID = c.QueryParam("last_event_id")
+	}
+	if lastEventID != "" {
+		after, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			return routeerrs.BadRequest("invalid last event id", err)
+		}
+		filter.After = after
+	}
+
+	ctx := c.Request().Context()
+	replay, events := r.EventsService.Subscribe(ctx, filter)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, MIMETextEventStream)
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	for _, event := range replay {
+		if err := writeEvent(res, event); err != nil {
+			return err
+		}
+	}
+	res.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				// The server is shutting down, the client reconnects with
+				// Last-Event-ID.
+				return nil
+			}
+			if err := writeEvent(res, event); err != nil {
+				return err
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+				return err
+			}
+		}
+		res.Flush()
+	}
+}
+
+func writeEvent(res *echo.Response, event entity.MessageEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
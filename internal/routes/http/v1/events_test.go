@@ -0,0 +1,61 @@
+package v1_test
+
+import (
+	"messagio_testsuite/internal/entity"
+	v1 "messagio_testsuite/internal/routes/http/v1"
+	"messagio_testsuite/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestEvents(t *testing.T) {
+	e, _, routes := setup()
+	mockEvents := routes.EventsService.(*MockEventsService)
+
+	id := uuid.New()
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	replay := []entity.MessageEvent{
+		{ID: 4, Type: entity.EventCreated, MessageID: id, Status: entity.StatusReceived, CreatedAt: now},
+	}
+	events := make(chan entity.MessageEvent, 1)
+	events <- entity.MessageEvent{ID: 5, Type: entity.EventProcessed, MessageID: id, Status: entity.StatusProcessed, CreatedAt: now}
+	close(events)
+	mockEvents.On("Subscribe", mock.Anything, service.EventFilter{After: 3, MessageID: &id}).Return(replay, events)
+
+	req := httptest.NewRequest(http.MethodGet, "/messages/events?id="+id.String(), nil)
+	req.Header.Set(v1.HeaderLastEventID, "3")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if assert.NoError(t, handle(c, routes.Events)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, v1.MIMETextEventStream, rec.Header().Get("Content-Type"))
+		assert.Equal(t, "id: 4\nevent: created\ndata: {\"id\":4,\"type\":\"created\",\"message_id\":\""+id.String()+
+			"\",\"status\":\"received\",\"created_at\":\"2024-07-01T12:00:00Z\"}\n\n"+
+			"id: 5\nevent: processed\ndata: {\"id\":5,\"type\":\"processed\",\"message_id\":\""+id.String()+
+			"\",\"status\":\"processed\",\"created_at\":\"2024-07-01T12:00:00Z\"}\n\n", rec.Body.String())
+	}
+
+	mockEvents.AssertExpectations(t)
+}
+
+func TestEvents_InvalidLastEventID(t *testing.T) {
+	e, _, routes := setup()
+	mockEvents := routes.EventsService.(*MockEventsService)
+
+	req := httptest.NewRequest(http.MethodGet, "/messages/events", nil)
+	req.Header.Set(v1.HeaderLastEventID, "abc")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	assert.Error(t, handle(c, routes.Events))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	mockEvents.AssertNotCalled(t, "Subscribe", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,36 @@
+package v1
+
+import (
+	"context"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/service"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type HealthRoutes struct {
+	HealthService service.Health
+}
+
+// NewHealthRoutes registers the probes at the root, they answer 503 with the
+// same report when something is down.
+func NewHealthRoutes(e *echo.Echo, HealthService service.Health) {
+	r := &HealthRoutes{
+		HealthService: HealthService,
+	}
+
+	e.GET("/livez", r.probe(r.HealthService.Live))
+	e.GET("/readyz", r.probe(r.HealthService.Ready))
+	e.GET("/startupz", r.probe(r.HealthService.Started))
+}
+
+func (r *HealthRoutes) probe(check func(ctx context.Context) entity.HealthReport) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		report := check(c.Request().Context())
+		if report.Status != entity.HealthStatusUp {
+			return c.JSON(http.StatusServiceUnavailable, report)
+		}
+		return c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,63 @@
+package v1_test
+
+import (
+	"context"
+	"encoding/json"
+	"messagio_testsuite/internal/entity"
+	v1 "messagio_testsuite/internal/routes/http/v1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubHealth struct {
+	ready entity.HealthReport
+}
+
+func (s *stubHealth) Live(context.Context) entity.HealthReport {
+	return entity.HealthReport{Status: entity.HealthStatusUp}
+}
+
+func (s *stubHealth) Ready(context.Context) entity.HealthReport {
+	return s.ready
+}
+
+func (s *stubHealth) Started(context.Context) entity.HealthReport {
+	return entity.HealthReport{Status: entity.HealthStatusUp}
+}
+
+func TestHealthRoutes(t *testing.T) {
+	health := &stubHealth{ready: entity.HealthReport{
+		Status: entity.HealthStatusDown,
+		Components: []entity.ComponentHealth{
+			{Name: "postgres", Status: entity.HealthStatusUp, Latency: 1.5},
+			{Name: "kafka", Status: entity.HealthStatusDown, Error: "topic not found"},
+		},
+	}}
+	e := echo.New()
+	v1.NewHealthRoutes(e, health)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var report entity.HealthReport
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, health.ready, report)
+
+	health.ready = entity.HealthReport{Status: entity.HealthStatusUp}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/startupz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -1,30 +1,72 @@
 package v1
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"messagio_testsuite/internal/entity"
 	routeerrs "messagio_testsuite/internal/routes/http/v1/route_errors"
 	"messagio_testsuite/internal/service"
 	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"messagio_testsuite/pkg/kafka"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+	HeaderETag               = "ETag"
+	HeaderIfMatch            = "If-Match"
+
+	maxIdempotencyKeyLength = 255
+)
+
 type MessageRoutes struct {
-	MessageService service.Message
+	MessageService     service.Message
+	IdempotencyService service.Idempotency
+	EventsService      service.Events
+	RateLimitService   service.RateLimit
 }
 
-func NewMessageRoutes(g *echo.Group, MessageService service.Message) {
+func NewMessageRoutes(g *echo.Group, MessageService service.Message, IdempotencyService service.Idempotency, EventsService service.Events, AuthService service.Auth, RateLimitService service.RateLimit) {
 	r := &MessageRoutes{
-		MessageService: MessageService,
+		MessageService:     MessageService,
+		IdempotencyService: IdempotencyService,
+		EventsService:      EventsService,
+		RateLimitService:   RateLimitService,
 	}
 
-	g.POST("/create", r.Create)
-	g.GET("/messages", r.GetAll)
-	g.GET("/messages/:id", r.GetByID)
-	g.GET("/messages/stats", r.GetStats)
-	g.PUT("/messages/:id/process", r.MarkAsProcessed)
+	auth := NewAuthenticator(AuthService)
+	throttle := NewThrottler(RateLimitService)
+	// The handlers charge the daily quota for every message they create.
+	write := []echo.MiddlewareFunc{auth.Require(entity.ScopeMessagesWrite), throttle.Limit()}
+	read := []echo.MiddlewareFunc{auth.Require(entity.ScopeMessagesRead), throttle.Limit()}
+	admin := []echo.MiddlewareFunc{auth.Require(entity.ScopeMessagesAdmin), throttle.Limit()}
+
+	g.POST("/messages", r.Create, write...)
+	g.POST("/messages/batch", r.CreateBatch, write...)
+	g.GET("/messages", r.GetAll, read...)
+	g.GET("/messages/:id", r.GetByID, read...)
+	g.GET("/messages/stats", r.GetStats, read...)
+	g.GET("/messages/events", r.Events, read...)
+	// The socket both creates messages and reports on them.
+	g.GET("/messages/ws", r.WebSocket, write...)
+	g.GET("/messages/:id/history", r.GetHistory, read...)
+	g.PUT("/messages/:id/process", r.MarkAsProcessed, admin...)
+
+	// Deprecated aliases of POST /messages and POST /messages/batch.
+	g.POST("/create", r.Create, write...)
+	g.POST("/create/batch", r.CreateBatch, write...)
 }
 
 func (r *MessageRoutes) Create(c echo.Context) error {
@@ -33,23 +75,48 @@ func (r *MessageRoutes) Create(c echo.Context) error {
 	}
 	var req request
 	if err := c.Bind(&req); err != nil {
-		routeerrs.NewErrorResponse(c, http.StatusBadRequest, "invalid request body")
-		return err
+		return routeerrs.BadRequest("invalid request body", err)
 	}
 
 	if err := c.Validate(&req); err != nil {
-		routeerrs.NewErrorResponse(c, http.StatusBadRequest, err.Error())
+		return routeerrs.BadRequest(err.Error(), err)
+	}
+
+	ctx := c.Request().Context()
+	throttle := NewThrottler(r.RateLimitService)
+
+	// Retries carrying the same Idempotency-Key get the first response back
+	// instead of creating the message again. Keys are scoped to the caller,
+	// so one client never gets another's response.
+	key, client := c.Request().Header.Get(HeaderIdempotencyKey), clientKey(c)
+	if key != "" {
+		if len(key) > maxIdempotencyKeyLength {
+			return routeerrs.BadRequest("idempotency key too long", nil)
+		}
+
+		fingerprint := service.Fingerprint(c.Request().Method, c.Path(), []byte(req.Message))
+		record, err := r.IdempotencyService.Begin(ctx, client, key, fingerprint)
+		if err != nil {
+			return err
+		}
+		if record != nil {
+			c.Response().Header().Set(HeaderIdempotentReplayed, "true")
+			return c.JSONBlob(record.StatusCode, record.Response)
+		}
+	}
+
+	if err := throttle.ChargeQuota(c, 1); err != nil {
+		if key != "" {
+			r.IdempotencyService.Release(ctx, client, key)
+		}
 		return err
 	}
 
-	id, err := r.MessageService.CreateMessage(c.Request().Context(), req.Message)
+	id, err := r.MessageService.CreateMessage(ctx, req.Message)
 	if err != nil {
-		if errors.Is(err, serviceerrs.ErrCannotCreateMessage) {
-			routeerrs.NewErrorResponse(c, http.StatusInternalServerError, "failed to create message")
-		} else if errors.Is(err, serviceerrs.ErrCannotProduceMessage) {
-			routeerrs.NewErrorResponse(c, http.StatusInternalServerError, "failed to produce message to Kafka")
-		} else {
-			routeerrs.NewErrorResponse(c, http.StatusInternalServerError, "internal server error")
+		throttle.RefundQuota(c, 1)
+		if key != "" {
+			r.IdempotencyService.Release(ctx, client, key)
 		}
 		return err
 	}
@@ -58,75 +125,399 @@ func (r *MessageRoutes) Create(c echo.Context) error {
 		Id uuid.UUID `json:"id"`
 	}
 
-	return c.JSON(http.StatusCreated, response{
+	body, err := json.Marshal(response{
 		Id: id,
 	})
+	if err != nil {
+		return err
+	}
+
+	if key != "" {
+		// The message exists at this point, a failure to store the response
+		// only means a retry would not be recognized.
+		_ = r.IdempotencyService.Complete(ctx, client, key, http.StatusCreated, body)
+	}
+	return c.JSONBlob(http.StatusCreated, body)
+}
+
+// MIMEApplicationNDJSON selects the streaming variant of CreateBatch.
+const MIMEApplicationNDJSON = "application/x-ndjson"
+
+// ndjsonChunkSize is how many streamed messages are inserted per transaction.
+const ndjsonChunkSize = 500
+
+type batchItem struct {
+	Message string `json:"message"`
 }
 
+// CreateBatch creates many messages in one request. A JSON array of
+// {"message": "..."} objects is answered with a result per item, 201 when all
+// of them were created and 207 otherwise. With Content-Type
+// application/x-ndjson the body is read as one object per line, inserted in
+// chunks, and the results are streamed back one per line as they are known.
+func (r *MessageRoutes) CreateBatch(c echo.Context) error {
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), MIMEApplicationNDJSON) {
+		return r.createStream(c)
+	}
+
+	var items []batchItem
+	if err := c.Bind(&items); err != nil {
+		return routeerrs.BadRequest("invalid request body", err)
+	}
+	if len(items) == 0 {
+		return routeerrs.BadRequest("empty batch", nil)
+	}
+	if len(items) > service.MaxBatchSize {
+		return batchTooLarge(serviceerrs.ErrBatchTooLarge)
+	}
+
+	throttle := NewThrottler(r.RateLimitService)
+	contents := make([]string, len(items))
+	for i, item := range items {
+		contents[i] = item.Message
+	}
+
+	// The whole batch is charged up front and the items that fail are given
+	// back, so a batch either fits the quota or is refused.
+	if err := throttle.ChargeQuota(c, len(contents)); err != nil {
+		return err
+	}
+
+	results, err := r.MessageService.CreateMessages(c.Request().Context(), contents)
+	if err != nil {
+		throttle.RefundQuota(c, len(contents))
+		if errors.Is(err, serviceerrs.ErrBatchTooLarge) {
+			return batchTooLarge(err)
+		}
+		return err
+	}
+
+	type response struct {
+		Created int                           `json:"created"`
+		Failed  int                           `json:"failed"`
+		Results []service.CreateMessageResult `json:"results"`
+	}
+
+	resp := response{Results: results}
+	for _, result := range results {
+		if result.Error != "" {
+			resp.Failed++
+		} else {
+			resp.Created++
+		}
+	}
+
+	throttle.RefundQuota(c, resp.Failed)
+
+	status := http.StatusCreated
+	if resp.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	return c.JSON(status, resp)
+}
+
+func batchTooLarge(err error) error {
+	return routeerrs.New(http.StatusRequestEntityTooLarge, fmt.Sprintf("batch exceeds %d messages", service.MaxBatchSize), err)
+}
+
+// createStream charges the quota a chunk at a time. Once a chunk does not fit,
+// its lines are reported as failed rather than ending the stream, which has
+// already been answered with 200.
+func (r *MessageRoutes) createStream(c echo.Context) error {
+	ctx := c.Request().Context()
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, MIMEApplicationNDJSON)
+	res.WriteHeader(http.StatusOK)
+
+	throttle := NewThrottler(r.RateLimitService)
+	enc := json.NewEncoder(res)
+	offset := 0
+	var (
+		contents []string
+		invalid  = make(map[int]bool)
+	)
+
+	flush := func() error {
+		if len(contents) == 0 {
+			return nil
+		}
+
+		var results []service.CreateMessageResult
+		if err := throttle.ChargeQuota(c, len(contents)); err != nil {
+			results = make([]service.CreateMessageResult, len(contents))
+			for i := range results {
+				results[i] = service.CreateMessageResult{Index: i, Error: err.Error()}
+			}
+		} else {
+			results, err = r.MessageService.CreateMessages(ctx, contents)
+			if err != nil {
+				throttle.RefundQuota(c, len(contents))
+				return err
+			}
+			failed := 0
+			for _, result := range results {
+				if result.Error != "" {
+					failed++
+				}
+			}
+			throttle.RefundQuota(c, failed)
+		}
+
+		for _, result := range results {
+			if invalid[result.Index] {
+				result = service.CreateMessageResult{Index: result.Index, Error: "invalid JSON"}
+			}
+			result.Index += offset
+			if err := enc.Encode(result); err != nil {
+				return err
+			}
+		}
+		res.Flush()
+
+		offset += len(contents)
+		contents = contents[:0]
+		clear(invalid)
+		return nil
+	}
+
+	scanner := bufio.NewScanner(c.Request().Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		// An undecodable line keeps its place in the batch and is reported
+		// as invalid instead of the service's result for it.
+		var item batchItem
+		if err := json.Unmarshal(line, &item); err != nil {
+			invalid[len(contents)] = true
+			item.Message = ""
+		}
+		contents = append(contents, item.Message)
+
+		if len(contents) == ndjsonChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// GetAll lists messages a page at a time. Supported query parameters are
+// processed, created_from, created_to, processed_from, processed_to (RFC 3339),
+// q (substring match), order (asc or desc), limit and cursor, which takes the
+// next_cursor of the previous page. status filters by lifecycle status.
 func (r *MessageRoutes) GetAll(c echo.Context) error {
-	messages, err := r.MessageService.GetMessages(c.Request().Context())
+	input := service.GetMessagesInput{
+		Status: entity.MessageStatus(c.QueryParam("status")),
+		Query:  c.QueryParam("q"),
+		Order:  entity.SortOrder(c.QueryParam("order")),
+		Cursor: c.QueryParam("cursor"),
+	}
+
+	err := echo.QueryParamsBinder(c).
+		Int("limit", &input.Limit).
+		CustomFunc("processed", func(values []string) []error {
+			processed, err := strconv.ParseBool(values[0])
+			if err != nil {
+				return []error{err}
+			}
+			input.Processed = &processed
+			return nil
+		}).
+		CustomFunc("created_from", bindTime(&input.CreatedFrom)).
+		CustomFunc("created_to", bindTime(&input.CreatedTo)).
+		CustomFunc("processed_from", bindTime(&input.ProcessedFrom)).
+		CustomFunc("processed_to", bindTime(&input.ProcessedTo)).
+		BindError()
+	if err != nil {
+		return routeerrs.BadRequest(err.Error(), err)
+	}
+
+	page, err := r.MessageService.GetMessages(c.Request().Context(), input)
 	if err != nil {
-		routeerrs.NewErrorResponse(c, http.StatusInternalServerError, "internal server error")
 		return err
 	}
 
-	return c.JSON(http.StatusOK, messages)
+	return c.JSON(http.StatusOK, page)
+}
+
+func bindTime(dest **time.Time) func(values []string) []error {
+	return func(values []string) []error {
+		t, err := time.Parse(time.RFC3339Nano, values[0])
+		if err != nil {
+			return []error{err}
+		}
+		*dest = &t
+		return nil
+	}
 }
 
 func (r *MessageRoutes) GetByID(c echo.Context) error {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
-		routeerrs.NewErrorResponse(c, http.StatusBadRequest, "invalid id format")
-		return err
+		return routeerrs.BadRequest("invalid id format", err)
 	}
 
 	message, err := r.MessageService.GetMessageById(c.Request().Context(), id)
 	if err != nil {
-		if err == serviceerrs.ErrMessageNotFound {
-			routeerrs.NewErrorResponse(c, http.StatusNotFound, "message not found")
-			return err
-		}
-		routeerrs.NewErrorResponse(c, http.StatusInternalServerError, "internal server error")
 		return err
 	}
 
+	c.Response().Header().Set(HeaderETag, messageETag(message.Version))
 	return c.JSON(http.StatusOK, message)
 }
 
+// messageETag renders a message version as a strong entity tag.
+func messageETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// parseIfMatch returns the message versions an If-Match header accepts, nil
+// when it is absent or "*" and any version does. If-Match uses the strong
+// comparison, so weak tags and tags that are no version never match.
+func parseIfMatch(header string) ([]int, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	versions := make([]int, 0)
+	for rest := header; ; {
+		rest = strings.TrimLeft(rest, " \t,")
+		if rest == "" {
+			return versions, nil
+		}
+		weak := strings.HasPrefix(rest, "W/")
+		rest = strings.TrimPrefix(rest, "W/")
+		if !strings.HasPrefix(rest, `"`) {
+			return nil, fmt.Errorf("invalid entity tag in %q", header)
+		}
+		end := strings.IndexByte(rest[1:], '"')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated entity tag in %q", header)
+		}
+		tag := rest[1 : end+1]
+		rest = strings.TrimLeft(rest[end+2:], " \t")
+		if rest != "" && rest[0] != ',' {
+			return nil, fmt.Errorf("invalid entity tag in %q", header)
+		}
+
+		if version, err := strconv.Atoi(tag); err == nil && version > 0 && !weak && messageETag(version) == `"`+tag+`"` {
+			versions = append(versions, version)
+		}
+	}
+}
+
+// ifMatchVersion picks the version to require of the message among the ones
+// If-Match accepts: 0 for any, otherwise the current one if it is listed.
+func (r *MessageRoutes) ifMatchVersion(ctx context.Context, id uuid.UUID, versions []int) (int, error) {
+	switch {
+	case versions == nil:
+		return 0, nil
+	case len(versions) == 0:
+		return 0, serviceerrs.ErrVersionMismatch
+	case len(versions) == 1:
+		return versions[0], nil
+	}
+
+	message, err := r.MessageService.GetMessageById(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if !slices.Contains(versions, message.Version) {
+		return 0, serviceerrs.ErrVersionMismatch
+	}
+	return message.Version, nil
+}
+
+// GetStats reports message counts, processing latency and throughput. The
+// range is given by from and to (RFC 3339) and split into minute, hour or day
+// buckets. The caller's daily quota usage is included.
 func (r *MessageRoutes) GetStats(c echo.Context) error {
-	count, err := r.MessageService.GetProcessedMessagesStats(c.Request().Context())
+	input := service.GetStatsInput{
+		Bucket: entity.StatsBucket(c.QueryParam("bucket")),
+	}
+
+	err := echo.QueryParamsBinder(c).
+		CustomFunc("from", bindTime(&input.From)).
+		CustomFunc("to", bindTime(&input.To)).
+		BindError()
+	if err != nil {
+		return routeerrs.BadRequest(err.Error(), err)
+	}
+
+	stats, err := r.MessageService.GetStats(c.Request().Context(), input)
+	if err != nil {
+		return err
+	}
+
+	quota, err := r.RateLimitService.GetQuotaUsage(c.Request().Context(), clientKey(c))
 	if err != nil {
-		routeerrs.NewErrorResponse(c, http.StatusInternalServerError, "internal server error")
 		return err
 	}
 
 	type response struct {
-		ProcessedMessages int `json:"processed_messages"`
+		entity.MessageStats
+		ProcessedMessages int64               `json:"processed_messages"`
+		Consumer          kafka.ConsumerStats `json:"consumer"`
+		// Quota is the usage of the caller, left out when quotas are off.
+		Quota *entity.QuotaUsage `json:"quota,omitempty"`
 	}
 
 	return c.JSON(http.StatusOK, response{
-		ProcessedMessages: count,
+		MessageStats:      stats,
+		ProcessedMessages: stats.Counts.Processed,
+		Consumer:          r.MessageService.GetConsumerStats(),
+		Quota:             quota,
 	})
 }
 
+func (r *MessageRoutes) GetHistory(c echo.Context) error {
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return routeerrs.BadRequest("invalid id format", err)
+	}
+
+	transitions, err := r.MessageService.GetMessageHistory(c.Request().Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, transitions)
+}
+
 func (r *MessageRoutes) MarkAsProcessed(c echo.Context) error {
 	idStr := c.Param("id")
 	id, err := uuid.Parse(idStr)
 	if err != nil {
-		routeerrs.NewErrorResponse(c, http.StatusBadRequest, "invalid id format")
+		return routeerrs.BadRequest("invalid id format", err)
+	}
+
+	versions, err := parseIfMatch(c.Request().Header.Get(HeaderIfMatch))
+	if err != nil {
+		return routeerrs.BadRequest("invalid If-Match header", err)
+	}
+
+	ctx := c.Request().Context()
+	version, err := r.ifMatchVersion(ctx, id, versions)
+	if err != nil {
 		return err
 	}
 
-	err = r.MessageService.MarkMessageAsProcessed(c.Request().Context(), id)
+	version, err = r.MessageService.MarkMessageAsProcessed(ctx, id, version)
 	if err != nil {
-		if err == serviceerrs.ErrMessageNotFound {
-			routeerrs.NewErrorResponse(c, http.StatusNotFound, err.Error())
-			return err
-		}
-		routeerrs.NewErrorResponse(c, http.StatusInternalServerError, "internal server error")
 		return err
 	}
+	c.Response().Header().Set(HeaderETag, messageETag(version))
 
 	type response struct {
 		Message string `json:"message"`
@@ -5,10 +5,15 @@ import (
 	"encoding/json"
 	"messagio_testsuite/internal/entity"
 	v1 "messagio_testsuite/internal/routes/http/v1"
+	routeerrs "messagio_testsuite/internal/routes/http/v1/route_errors"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"messagio_testsuite/pkg/kafka"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-playground/validator"
 	"github.com/google/uuid"
@@ -34,19 +39,30 @@ func (m *MockMessageService) CreateMessage(ctx context.Context, content string)
 	return args.Get(0).(uuid.UUID), args.Error(1)
 }
 
+func (m *MockMessageService) CreateMessages(ctx context.Context, contents []string) ([]service.CreateMessageResult, error) {
+	args := m.Called(ctx, contents)
+	results, _ := args.Get(0).([]service.CreateMessageResult)
+	return results, args.Error(1)
+}
+
 func (m *MockMessageService) GetMessageById(ctx context.Context, id uuid.UUID) (entity.Message, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(entity.Message), args.Error(1)
 }
 
-func (m *MockMessageService) GetMessages(ctx context.Context) ([]entity.Message, error) {
-	args := m.Called(ctx)
-	return args.Get(0).([]entity.Message), args.Error(1)
+func (m *MockMessageService) GetMessages(ctx context.Context, input service.GetMessagesInput) (service.MessagesPage, error) {
+	args := m.Called(ctx, input)
+	return args.Get(0).(service.MessagesPage), args.Error(1)
 }
 
-func (m *MockMessageService) MarkMessageAsProcessed(ctx context.Context, id uuid.UUID) error {
+func (m *MockMessageService) MarkMessageAsProcessed(ctx context.Context, id uuid.UUID, version int) (int, error) {
+	args := m.Called(ctx, id, version)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockMessageService) GetMessageHistory(ctx context.Context, id uuid.UUID) ([]entity.MessageTransition, error) {
 	args := m.Called(ctx, id)
-	return args.Error(0)
+	return args.Get(0).([]entity.MessageTransition), args.Error(1)
 }
 
 func (m *MockMessageService) GetProcessedMessagesStats(ctx context.Context) (int, error) {
@@ -54,17 +70,77 @@ func (m *MockMessageService) GetProcessedMessagesStats(ctx context.Context) (int
 	return args.Get(0).(int), args.Error(1)
 }
 
+func (m *MockMessageService) GetStats(ctx context.Context, input service.GetStatsInput) (entity.MessageStats, error) {
+	args := m.Called(ctx, input)
+	return args.Get(0).(entity.MessageStats), args.Error(1)
+}
+
+func (m *MockMessageService) GetConsumerStats() kafka.ConsumerStats {
+	args := m.Called()
+	return args.Get(0).(kafka.ConsumerStats)
+}
+
+type MockIdempotencyService struct {
+	mock.Mock
+}
+
+func (m *MockIdempotencyService) Begin(ctx context.Context, client, key, fingerprint string) (*entity.IdempotencyKey, error) {
+	args := m.Called(ctx, client, key, fingerprint)
+	record, _ := args.Get(0).(*entity.IdempotencyKey)
+	return record, args.Error(1)
+}
+
+func (m *MockIdempotencyService) Complete(ctx context.Context, client, key string, statusCode int, response []byte) error {
+	args := m.Called(ctx, client, key, statusCode, response)
+	return args.Error(0)
+}
+
+func (m *MockIdempotencyService) Release(ctx context.Context, client, key string) {
+	m.Called(ctx, client, key)
+}
+
+type MockEventsService struct {
+	mock.Mock
+}
+
+func (m *MockEventsService) Subscribe(ctx context.Context, filter service.EventFilter) ([]entity.MessageEvent, <-chan entity.MessageEvent) {
+	args := m.Called(ctx, filter)
+	replay, _ := args.Get(0).([]entity.MessageEvent)
+	return replay, args.Get(1).(chan entity.MessageEvent)
+}
+
+func (m *MockEventsService) Close() {
+	m.Called()
+}
+
 func setup() (*echo.Echo, *MockMessageService, *v1.MessageRoutes) {
 	e := echo.New()
 	e.Validator = &CustomValidator{validator: validator.New()}
+	e.HTTPErrorHandler = routeerrs.ErrorHandler
 	mockService := new(MockMessageService)
+	mockIdempotency := new(MockIdempotencyService)
+	mockEvents := new(MockEventsService)
+	limits := newRateLimitService(service.RateLimitConfig{Rate: 100, Burst: 100, DailyQuota: 1000})
 	routes := &v1.MessageRoutes{
-		MessageService: mockService,
+		MessageService:     mockService,
+		IdempotencyService: mockIdempotency,
+		EventsService:      mockEvents,
+		RateLimitService:   limits,
 	}
-	v1.NewMessageRoutes(e.Group("/"), mockService)
+	v1.NewMessageRoutes(e.Group("/"), mockService, mockIdempotency, mockEvents, testAuth, limits)
 	return e, mockService, routes
 }
 
+// handle runs h like Echo does, writing a returned error with the error
+// handler, and passes the error on.
+func handle(c echo.Context, h echo.HandlerFunc) error {
+	err := h(c)
+	if err != nil {
+		c.Echo().HTTPErrorHandler(err, c)
+	}
+	return err
+}
+
 func TestCreateMessage(t *testing.T) {
 	e, mockService, routes := setup()
 
@@ -77,7 +153,7 @@ func TestCreateMessage(t *testing.T) {
 	mockService.On("CreateMessage", mock.Anything, "Hello, world!").Return(uuid.New(), nil)
 
 	if assert.NoError(t, c.Validate(req)) {
-		if assert.NoError(t, routes.Create(c)) {
+		if assert.NoError(t, handle(c, routes.Create)) {
 			assert.Equal(t, http.StatusCreated, rec.Code)
 		}
 	}
@@ -85,11 +161,149 @@ func TestCreateMessage(t *testing.T) {
 	mockService.AssertExpectations(t)
 }
 
+func TestCreateMessage_IdempotencyKey(t *testing.T) {
+	e, mockService, routes := setup()
+	mockIdempotency := routes.IdempotencyService.(*MockIdempotencyService)
+
+	id := uuid.New()
+	fingerprint := service.Fingerprint(http.MethodPost, "/create", []byte("Hello, world!"))
+	body := []byte(`{"id":"` + id.String() + `"}`)
+	mockIdempotency.On("Begin", mock.Anything, "ip:192.0.2.1", "key-1", fingerprint).Return(nil, nil).Once()
+	mockService.On("CreateMessage", mock.Anything, "Hello, world!").Return(id, nil).Once()
+	mockIdempotency.On("Complete", mock.Anything, "ip:192.0.2.1", "key-1", http.StatusCreated, body).Return(nil).Once()
+
+	newContext := func() (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(`{"message": "Hello, world!"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(v1.HeaderIdempotencyKey, "key-1")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/create")
+		return c, rec
+	}
+
+	c, rec := newContext()
+	if assert.NoError(t, handle(c, routes.Create)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.JSONEq(t, string(body), rec.Body.String())
+		assert.Empty(t, rec.Header().Get(v1.HeaderIdempotentReplayed))
+	}
+
+	// The retry is answered from the stored response.
+	mockIdempotency.On("Begin", mock.Anything, "ip:192.0.2.1", "key-1", fingerprint).
+		Return(&entity.IdempotencyKey{Key: "key-1", StatusCode: http.StatusCreated, Response: body}, nil).Once()
+
+	c, rec = newContext()
+	if assert.NoError(t, handle(c, routes.Create)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.JSONEq(t, string(body), rec.Body.String())
+		assert.Equal(t, "true", rec.Header().Get(v1.HeaderIdempotentReplayed))
+	}
+
+	mockService.AssertExpectations(t)
+	mockIdempotency.AssertExpectations(t)
+}
+
+func TestCreateMessage_IdempotencyKeyConflicts(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{serviceerrs.ErrIdempotencyKeyReused, http.StatusUnprocessableEntity},
+		{serviceerrs.ErrIdempotencyKeyInFlight, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		e, mockService, routes := setup()
+		mockIdempotency := routes.IdempotencyService.(*MockIdempotencyService)
+		mockIdempotency.On("Begin", mock.Anything, mock.Anything, "key-1", mock.Anything).Return(nil, tt.err)
+
+		req := httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(`{"message": "Hello, world!"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(v1.HeaderIdempotencyKey, "key-1")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		assert.ErrorIs(t, handle(c, routes.Create), tt.err)
+		assert.Equal(t, tt.status, rec.Code)
+		mockService.AssertNotCalled(t, "CreateMessage", mock.Anything, mock.Anything)
+	}
+}
+
+func TestCreateBatch(t *testing.T) {
+	e, mockService, routes := setup()
+
+	id := uuid.New()
+	mockService.On("CreateMessages", mock.Anything, []string{"Hello, world!", ""}).Return([]service.CreateMessageResult{
+		{Index: 0, ID: &id},
+		{Index: 1, Error: "message is required"},
+	}, nil)
+
+	reqBody := `[{"message": "Hello, world!"}, {"message": ""}]`
+	req := httptest.NewRequest(http.MethodPost, "/create/batch", strings.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if assert.NoError(t, handle(c, routes.CreateBatch)) {
+		assert.Equal(t, http.StatusMultiStatus, rec.Code)
+		var response struct {
+			Created int                           `json:"created"`
+			Failed  int                           `json:"failed"`
+			Results []service.CreateMessageResult `json:"results"`
+		}
+		if assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response)) {
+			assert.Equal(t, 1, response.Created)
+			assert.Equal(t, 1, response.Failed)
+			assert.Equal(t, id, *response.Results[0].ID)
+			assert.Nil(t, response.Results[1].ID)
+		}
+	}
+
+	usage, err := routes.RateLimitService.GetQuotaUsage(context.Background(), "ip:192.0.2.1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), usage.Used, "only the created message counts against the quota")
+	}
+
+	mockService.AssertExpectations(t)
+}
+
+func TestCreateBatch_NDJSON(t *testing.T) {
+	e, mockService, routes := setup()
+
+	id := uuid.New()
+	mockService.On("CreateMessages", mock.Anything, []string{"Hello, world!", ""}).Return([]service.CreateMessageResult{
+		{Index: 0, ID: &id},
+		{Index: 1, Error: "message is required"},
+	}, nil)
+
+	reqBody := "{\"message\": \"Hello, world!\"}\n\n{not json\n"
+	req := httptest.NewRequest(http.MethodPost, "/create/batch", strings.NewReader(reqBody))
+	req.Header.Set(echo.HeaderContentType, v1.MIMEApplicationNDJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if assert.NoError(t, handle(c, routes.CreateBatch)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, v1.MIMEApplicationNDJSON, rec.Header().Get(echo.HeaderContentType))
+
+		dec := json.NewDecoder(rec.Body)
+		var first, second service.CreateMessageResult
+		if assert.NoError(t, dec.Decode(&first)) && assert.NoError(t, dec.Decode(&second)) {
+			assert.Equal(t, service.CreateMessageResult{Index: 0, ID: &id}, first)
+			assert.Equal(t, service.CreateMessageResult{Index: 1, Error: "invalid JSON"}, second)
+		}
+		assert.False(t, dec.More())
+	}
+
+	mockService.AssertExpectations(t)
+}
+
 func TestGetMessageByID(t *testing.T) {
 	e, mockService, routes := setup()
 
 	id := uuid.New()
-	expectedMessage := entity.Message{ID: id, Message: "Hello, world!"}
+	expectedMessage := entity.Message{ID: id, Message: "Hello, world!", Version: 3}
 	mockService.On("GetMessageById", mock.Anything, id).Return(expectedMessage, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/messages/"+id.String(), nil)
@@ -98,8 +312,9 @@ func TestGetMessageByID(t *testing.T) {
 	c.SetParamNames("id")
 	c.SetParamValues(id.String())
 
-	if assert.NoError(t, routes.GetByID(c)) {
+	if assert.NoError(t, handle(c, routes.GetByID)) {
 		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `"3"`, rec.Header().Get(v1.HeaderETag))
 		var message entity.Message
 		if assert.NoError(t, json.NewDecoder(rec.Body).Decode(&message)) {
 			assert.Equal(t, expectedMessage, message)
@@ -116,61 +331,275 @@ func TestGetMessages(t *testing.T) {
 		{ID: uuid.New(), Message: "Hello, world!"},
 		{ID: uuid.New(), Message: "Hello, universe!"},
 	}
-	mockService.On("GetMessages", mock.Anything).Return(expectedMessages, nil)
+	processed := false
+	createdFrom := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	expectedInput := service.GetMessagesInput{
+		Processed:   &processed,
+		CreatedFrom: &createdFrom,
+		Query:       "Hello",
+		Order:       entity.SortAsc,
+		Limit:       2,
+		Cursor:      "abc",
+	}
+	mockService.On("GetMessages", mock.Anything, expectedInput).
+		Return(service.MessagesPage{Messages: expectedMessages, NextCursor: "def"}, nil)
 
-	req := httptest.NewRequest(http.MethodGet, "/messages", nil)
+	req := httptest.NewRequest(http.MethodGet, "/messages?processed=false&created_from=2024-07-01T00:00:00Z&q=Hello&order=asc&limit=2&cursor=abc", nil)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 
-	if assert.NoError(t, routes.GetAll(c)) {
+	if assert.NoError(t, handle(c, routes.GetAll)) {
 		assert.Equal(t, http.StatusOK, rec.Code)
-		var messages []entity.Message
-		if assert.NoError(t, json.NewDecoder(rec.Body).Decode(&messages)) {
-			assert.Equal(t, expectedMessages, messages)
+		var page service.MessagesPage
+		if assert.NoError(t, json.NewDecoder(rec.Body).Decode(&page)) {
+			assert.Equal(t, expectedMessages, page.Messages)
+			assert.Equal(t, "def", page.NextCursor)
 		}
 	}
 
 	mockService.AssertExpectations(t)
 }
 
+func TestGetMessages_InvalidQuery(t *testing.T) {
+	e, mockService, routes := setup()
+
+	req := httptest.NewRequest(http.MethodGet, "/messages?created_from=yesterday", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	assert.Error(t, handle(c, routes.GetAll))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	mockService.On("GetMessages", mock.Anything, mock.Anything).Return(service.MessagesPage{}, serviceerrs.ErrInvalidCursor)
+
+	req = httptest.NewRequest(http.MethodGet, "/messages?cursor=bogus", nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+
+	assert.ErrorIs(t, handle(c, routes.GetAll), serviceerrs.ErrInvalidCursor)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
 func TestMarkMessageAsProcessed(t *testing.T) {
 	e, mockService, routes := setup()
 
 	id := uuid.New()
-	mockService.On("MarkMessageAsProcessed", mock.Anything, id).Return(nil)
+	mockService.On("MarkMessageAsProcessed", mock.Anything, id, 0).Return(4, nil)
+
+	req := httptest.NewRequest(http.MethodPut, "/messages/"+id.String()+"/process", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id.String())
+
+	if assert.NoError(t, handle(c, routes.MarkAsProcessed)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `"4"`, rec.Header().Get(v1.HeaderETag), "the ETag is the new version")
+	}
+
+	mockService.AssertExpectations(t)
+}
+
+func TestMarkMessageAsProcessed_InvalidTransition(t *testing.T) {
+	e, mockService, routes := setup()
+
+	id := uuid.New()
+	mockService.On("MarkMessageAsProcessed", mock.Anything, id, 0).Return(0, serviceerrs.ErrInvalidTransition)
+
+	req := httptest.NewRequest(http.MethodPut, "/messages/"+id.String()+"/process", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id.String())
+
+	assert.ErrorIs(t, handle(c, routes.MarkAsProcessed), serviceerrs.ErrInvalidTransition)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, routeerrs.MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+
+	var problem routeerrs.Problem
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+	assert.Equal(t, "urn:messaggio:problem:invalid-transition", problem.Type)
+	assert.Equal(t, http.StatusConflict, problem.Status)
+	assert.Equal(t, "/messages/"+id.String()+"/process", problem.Instance)
+
+	mockService.AssertExpectations(t)
+}
 
+func TestMarkMessageAsProcessed_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		version int
+		err     error
+		status  int
+	}{
+		{name: "not found", err: serviceerrs.ErrMessageNotFound, status: http.StatusNotFound},
+		{name: "already processed", err: serviceerrs.ErrMessageAlreadyProcessed, status: http.StatusConflict},
+		{name: "version mismatch", ifMatch: `"2"`, version: 2, err: serviceerrs.ErrVersionMismatch, status: http.StatusPreconditionFailed},
+		{name: "any version", ifMatch: "*", err: serviceerrs.ErrMessageNotFound, status: http.StatusNotFound},
+		{name: "tag list", ifMatch: `"2", W/"3"`, version: 2, err: serviceerrs.ErrVersionMismatch, status: http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, mockService, routes := setup()
+
+			id := uuid.New()
+			mockService.On("MarkMessageAsProcessed", mock.Anything, id, tt.version).Return(0, tt.err)
+
+			req := httptest.NewRequest(http.MethodPut, "/messages/"+id.String()+"/process", nil)
+			if tt.ifMatch != "" {
+				req.Header.Set(v1.HeaderIfMatch, tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(id.String())
+
+			assert.ErrorIs(t, handle(c, routes.MarkAsProcessed), tt.err)
+			assert.Equal(t, tt.status, rec.Code)
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMarkMessageAsProcessed_InvalidIfMatch(t *testing.T) {
+	e, mockService, routes := setup()
+
+	id := uuid.New()
 	req := httptest.NewRequest(http.MethodPut, "/messages/"+id.String()+"/process", nil)
+	req.Header.Set(v1.HeaderIfMatch, "3")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id.String())
+
+	assert.Error(t, handle(c, routes.MarkAsProcessed))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	mockService.AssertNotCalled(t, "MarkMessageAsProcessed", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestMarkMessageAsProcessed_IfMatchList(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		status  int
+	}{
+		{name: "weak tag", ifMatch: `W/"3"`, status: http.StatusPreconditionFailed},
+		{name: "no version", ifMatch: `"abc"`, status: http.StatusPreconditionFailed},
+		{name: "current version listed", ifMatch: `"2", "3"`, status: http.StatusOK},
+		{name: "current version missing", ifMatch: `"1", "2"`, status: http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, mockService, routes := setup()
+
+			id := uuid.New()
+			mockService.On("GetMessageById", mock.Anything, id).Return(entity.Message{ID: id, Version: 3}, nil).Maybe()
+			mockService.On("MarkMessageAsProcessed", mock.Anything, id, 3).Return(4, nil).Maybe()
+
+			req := httptest.NewRequest(http.MethodPut, "/messages/"+id.String()+"/process", nil)
+			req.Header.Set(v1.HeaderIfMatch, tt.ifMatch)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(id.String())
+
+			_ = handle(c, routes.MarkAsProcessed)
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status != http.StatusOK {
+				mockService.AssertNotCalled(t, "MarkMessageAsProcessed", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+	e, mockService, routes := setup()
+
+	id := uuid.New()
+	expected := []entity.MessageTransition{
+		{ID: 1, MessageID: id, From: entity.StatusReceived, To: entity.StatusPublished, CreatedAt: time.Now().UTC()},
+		{ID: 2, MessageID: id, From: entity.StatusPublished, To: entity.StatusProcessing, CreatedAt: time.Now().UTC()},
+	}
+	mockService.On("GetMessageHistory", mock.Anything, id).Return(expected, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/messages/"+id.String()+"/history", nil)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 	c.SetParamNames("id")
 	c.SetParamValues(id.String())
 
-	if assert.NoError(t, routes.MarkAsProcessed(c)) {
+	if assert.NoError(t, handle(c, routes.GetHistory)) {
 		assert.Equal(t, http.StatusOK, rec.Code)
+		var transitions []entity.MessageTransition
+		if assert.NoError(t, json.NewDecoder(rec.Body).Decode(&transitions)) {
+			assert.Equal(t, expected, transitions)
+		}
 	}
 
 	mockService.AssertExpectations(t)
 }
 
-func TestGetProcessedMessagesStats(t *testing.T) {
+func TestGetStats(t *testing.T) {
 	e, mockService, routes := setup()
 
-	expectedCount := 42
-	mockService.On("GetProcessedMessagesStats", mock.Anything).Return(expectedCount, nil)
+	from := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(2 * time.Hour)
+	expectedStats := entity.MessageStats{
+		Counts:  entity.MessageCounts{Total: 50, Processed: 42, Pending: 6, Failed: 2},
+		Latency: entity.ProcessingLatency{Samples: 42, P50: 12.5, P95: 80, P99: 120},
+		From:    from,
+		To:      to,
+		Bucket:  entity.StatsBucketHour,
+		Throughput: []entity.ThroughputBucket{
+			{Start: from, Created: 30, Processed: 25},
+			{Start: from.Add(time.Hour), Created: 20, Processed: 17},
+		},
+	}
+	expectedConsumer := kafka.ConsumerStats{Workers: 4, QueueDepth: 100, InFlight: 3, Processed: 42}
+	mockService.On("GetStats", mock.Anything, service.GetStatsInput{From: &from, To: &to, Bucket: entity.StatsBucketHour}).
+		Return(expectedStats, nil)
+	mockService.On("GetConsumerStats").Return(expectedConsumer)
 
-	req := httptest.NewRequest(http.MethodGet, "/messages/stats", nil)
+	req := httptest.NewRequest(http.MethodGet, "/messages/stats?from=2024-07-01T00:00:00Z&to=2024-07-01T02:00:00Z&bucket=hour", nil)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 
-	if assert.NoError(t, routes.GetStats(c)) {
+	if assert.NoError(t, handle(c, routes.GetStats)) {
 		assert.Equal(t, http.StatusOK, rec.Code)
 		var response struct {
-			ProcessedMessages int `json:"processed_messages"`
+			entity.MessageStats
+			ProcessedMessages int64               `json:"processed_messages"`
+			Consumer          kafka.ConsumerStats `json:"consumer"`
+			Quota             *entity.QuotaUsage  `json:"quota"`
 		}
 		if assert.NoError(t, json.NewDecoder(rec.Body).Decode(&response)) {
-			assert.Equal(t, expectedCount, response.ProcessedMessages)
+			assert.Equal(t, expectedStats, response.MessageStats)
+			assert.Equal(t, int64(42), response.ProcessedMessages)
+			assert.Equal(t, expectedConsumer, response.Consumer)
+			if assert.NotNil(t, response.Quota) {
+				assert.Equal(t, int64(1000), response.Quota.Limit)
+				assert.Equal(t, int64(1000), response.Quota.Remaining)
+			}
 		}
 	}
 
 	mockService.AssertExpectations(t)
 }
+
+func TestGetStats_InvalidRange(t *testing.T) {
+	e, mockService, routes := setup()
+
+	mockService.On("GetStats", mock.Anything, mock.Anything).Return(entity.MessageStats{}, serviceerrs.ErrInvalidFilter)
+
+	req := httptest.NewRequest(http.MethodGet, "/messages/stats?bucket=week", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	assert.ErrorIs(t, handle(c, routes.GetStats), serviceerrs.ErrInvalidFilter)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,20 @@
+package v1
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// OpenAPISpec describes the routes registered by NewMessageRoutes and
+// NewOpenAPIRoutes. The contract test in openapi_test.go keeps the two in sync.
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+func NewOpenAPIRoutes(g *echo.Group) {
+	g.GET("/openapi.json", func(c echo.Context) error {
+		return c.JSONBlob(http.StatusOK, OpenAPISpec)
+	})
+}
@@ -0,0 +1,438 @@
+package v1_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"messagio_testsuite/internal/entity"
+	v1 "messagio_testsuite/internal/routes/http/v1"
+	routeerrs "messagio_testsuite/internal/routes/http/v1/route_errors"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"messagio_testsuite/pkg/kafka"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/go-playground/validator"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	openapi3filter.RegisterBodyDecoder(v1.MIMETextEventStream, openapi3filter.FileBodyDecoder)
+	openapi3filter.RegisterBodyDecoder(v1.MIMEApplicationNDJSON, openapi3filter.FileBodyDecoder)
+}
+
+func loadOpenAPI(t *testing.T) *openapi3.T {
+	doc, err := openapi3.NewLoader().LoadFromData(v1.OpenAPISpec)
+	require.NoError(t, err)
+	require.NoError(t, doc.Validate(context.Background()))
+	return doc
+}
+
+// bodyRecorder keeps a copy of the response body for validation.
+type bodyRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// openAPIValidator answers requests that do not match the spec with 400 and
+// fails the test for responses that do not match it, including undocumented
+// status codes.
+func openAPIValidator(t *testing.T, router routers.Router) echo.MiddlewareFunc {
+	options := &openapi3filter.Options{
+		IncludeResponseStatus: true,
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if c.IsWebSocket() {
+				return next(c)
+			}
+
+			route, pathParams, err := router.FindRoute(req)
+			if err != nil {
+				t.Errorf("%s %s is not in the spec: %v", req.Method, req.URL.Path, err)
+				return next(c)
+			}
+
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    req,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    options,
+			}
+			if err := openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			recorder := &bodyRecorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = recorder
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			res := c.Response()
+			responseInput := &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: input,
+				Status:                 res.Status,
+				Header:                 res.Header(),
+				Options:                options,
+			}
+			responseInput.SetBodyBytes(recorder.body.Bytes())
+			if err := openapi3filter.ValidateResponse(req.Context(), responseInput); err != nil {
+				t.Errorf("%s %s: response does not match the spec: %v", req.Method, req.URL.Path, err)
+			}
+			return nil
+		}
+	}
+}
+
+type contractMocks struct {
+	Message     *MockMessageService
+	Idempotency *MockIdempotencyService
+	Events      *MockEventsService
+	APIKeys     *MockAPIKeysService
+	Limits      *service.RateLimitService
+}
+
+func (m *contractMocks) AssertExpectations(t *testing.T) {
+	m.Message.AssertExpectations(t)
+	m.Idempotency.AssertExpectations(t)
+	m.Events.AssertExpectations(t)
+	m.APIKeys.AssertExpectations(t)
+}
+
+func setupContract(t *testing.T) (*echo.Echo, *contractMocks) {
+	router, err := gorillamux.NewRouter(loadOpenAPI(t))
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.Validator = &CustomValidator{validator: validator.New()}
+	e.HTTPErrorHandler = routeerrs.ErrorHandler
+	m := &contractMocks{
+		Message:     new(MockMessageService),
+		Idempotency: new(MockIdempotencyService),
+		Events:      new(MockEventsService),
+		APIKeys:     new(MockAPIKeysService),
+		Limits:      newRateLimitService(service.RateLimitConfig{Rate: 1, Burst: 5, DailyQuota: 100}),
+	}
+
+	api := e.Group("/api/v1", openAPIValidator(t, router))
+	v1.NewMessageRoutes(api, m.Message, m.Idempotency, m.Events, testAuth, m.Limits)
+	v1.NewAPIKeyRoutes(api, m.APIKeys, testAuth)
+	v1.NewOpenAPIRoutes(api)
+	return e, m
+}
+
+var echoParam = regexp.MustCompile(`:(\w+)`)
+
+func TestOpenAPI_CoversRoutes(t *testing.T) {
+	doc := loadOpenAPI(t)
+	e, _ := setupContract(t)
+
+	var routes, operations []string
+	for _, route := range e.Routes() {
+		if route.Method == echo.RouteNotFound {
+			continue
+		}
+		path := strings.TrimPrefix(route.Path, "/api/v1")
+		routes = append(routes, route.Method+" "+echoParam.ReplaceAllString(path, "{$1}"))
+	}
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			operations = append(operations, method+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	sort.Strings(operations)
+
+	assert.Equal(t, operations, routes)
+}
+
+func TestOpenAPI_Contract(t *testing.T) {
+	id := uuid.New()
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	message := entity.Message{ID: id, Message: "Hello, world!", CreatedAt: now, Status: entity.StatusProcessed, Processed: true, ProcessedAt: &now, Version: 3}
+	apiKey := entity.APIKey{ID: uuid.New(), Name: "ingest", Prefix: "mk_abcdefgh", Scopes: []entity.Scope{entity.ScopeMessagesWrite}, CreatedAt: now}
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		header      map[string]string
+		body        string
+		mock        func(*contractMocks)
+		status      int
+	}{
+		{
+			name: "create", method: http.MethodPost, target: "/api/v1/messages",
+			contentType: echo.MIMEApplicationJSON, body: `{"message": "Hello, world!"}`,
+			mock: func(m *contractMocks) {
+				m.Message.On("CreateMessage", mock.Anything, "Hello, world!").Return(id, nil)
+			},
+			status: http.StatusCreated,
+		},
+		{
+			name: "create without message", method: http.MethodPost, target: "/api/v1/messages",
+			contentType: echo.MIMEApplicationJSON, body: `{}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "create replay", method: http.MethodPost, target: "/api/v1/create",
+			contentType: echo.MIMEApplicationJSON, body: `{"message": "Hello, world!"}`,
+			header: map[string]string{v1.HeaderIdempotencyKey: "key-1"},
+			mock: func(m *contractMocks) {
+				m.Idempotency.On("Begin", mock.Anything, mock.Anything, "key-1", mock.Anything).Return(&entity.IdempotencyKey{
+					Key: "key-1", StatusCode: http.StatusCreated, Response: []byte(`{"id":"` + id.String() + `"}`),
+				}, nil)
+			},
+			status: http.StatusCreated,
+		},
+		{
+			name: "create with reused key", method: http.MethodPost, target: "/api/v1/messages",
+			contentType: echo.MIMEApplicationJSON, body: `{"message": "Hello, world!"}`,
+			header: map[string]string{v1.HeaderIdempotencyKey: "key-1"},
+			mock: func(m *contractMocks) {
+				m.Idempotency.On("Begin", mock.Anything, mock.Anything, "key-1", mock.Anything).Return(nil, serviceerrs.ErrIdempotencyKeyReused)
+			},
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "batch", method: http.MethodPost, target: "/api/v1/messages/batch",
+			contentType: echo.MIMEApplicationJSON, body: `[{"message": "first"}, {"message": ""}]`,
+			mock: func(m *contractMocks) {
+				m.Message.On("CreateMessages", mock.Anything, []string{"first", ""}).Return([]service.CreateMessageResult{
+					{Index: 0, ID: &id},
+					{Index: 1, Error: serviceerrs.ErrEmptyMessage.Error()},
+				}, nil)
+			},
+			status: http.StatusMultiStatus,
+		},
+		{
+			name: "batch too large", method: http.MethodPost, target: "/api/v1/create/batch",
+			contentType: echo.MIMEApplicationJSON, body: `[{"message": "first"}]`,
+			mock: func(m *contractMocks) {
+				m.Message.On("CreateMessages", mock.Anything, []string{"first"}).Return(nil, serviceerrs.ErrBatchTooLarge)
+			},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "batch stream", method: http.MethodPost, target: "/api/v1/messages/batch",
+			contentType: v1.MIMEApplicationNDJSON, body: "{\"message\": \"first\"}\n",
+			mock: func(m *contractMocks) {
+				m.Message.On("CreateMessages", mock.Anything, []string{"first"}).Return([]service.CreateMessageResult{{Index: 0, ID: &id}}, nil)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "list", method: http.MethodGet, target: "/api/v1/messages?status=processed&order=asc&limit=1",
+			mock: func(m *contractMocks) {
+				m.Message.On("GetMessages", mock.Anything, mock.Anything).Return(service.MessagesPage{Messages: []entity.Message{message}, NextCursor: "next"}, nil)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "list with bad limit", method: http.MethodGet, target: "/api/v1/messages?limit=1000",
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "get", method: http.MethodGet, target: "/api/v1/messages/" + id.String(),
+			mock: func(m *contractMocks) {
+				m.Message.On("GetMessageById", mock.Anything, id).Return(message, nil)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "get missing", method: http.MethodGet, target: "/api/v1/messages/" + id.String(),
+			mock: func(m *contractMocks) {
+				m.Message.On("GetMessageById", mock.Anything, id).Return(entity.Message{}, serviceerrs.ErrMessageNotFound)
+			},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "stats", method: http.MethodGet, target: "/api/v1/messages/stats?bucket=hour",
+			mock: func(m *contractMocks) {
+				m.Message.On("GetStats", mock.Anything, mock.Anything).Return(entity.MessageStats{
+					Counts:     entity.MessageCounts{Total: 1, Processed: 1},
+					Latency:    entity.ProcessingLatency{Samples: 1, P50: 5, P95: 5, P99: 5},
+					From:       now.Add(-time.Hour),
+					To:         now,
+					Bucket:     entity.StatsBucketHour,
+					Throughput: []entity.ThroughputBucket{{Start: now.Add(-time.Hour), Created: 1, Processed: 1}},
+				}, nil)
+				m.Message.On("GetConsumerStats").Return(kafka.ConsumerStats{Workers: 4})
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "history", method: http.MethodGet, target: "/api/v1/messages/" + id.String() + "/history",
+			mock: func(m *contractMocks) {
+				m.Message.On("GetMessageHistory", mock.Anything, id).Return([]entity.MessageTransition{
+					{ID: 1, MessageID: id, From: entity.StatusReceived, To: entity.StatusProcessed, CreatedAt: now},
+				}, nil)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "process", method: http.MethodPut, target: "/api/v1/messages/" + id.String() + "/process",
+			header: map[string]string{v1.HeaderIfMatch: `"3"`},
+			mock: func(m *contractMocks) {
+				m.Message.On("MarkMessageAsProcessed", mock.Anything, id, 3).Return(4, nil)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "process stale version", method: http.MethodPut, target: "/api/v1/messages/" + id.String() + "/process",
+			header: map[string]string{v1.HeaderIfMatch: `"2"`},
+			mock: func(m *contractMocks) {
+				m.Message.On("MarkMessageAsProcessed", mock.Anything, id, 2).Return(0, serviceerrs.ErrVersionMismatch)
+			},
+			status: http.StatusPreconditionFailed,
+		},
+		{
+			name: "process already processed", method: http.MethodPut, target: "/api/v1/messages/" + id.String() + "/process",
+			mock: func(m *contractMocks) {
+				m.Message.On("MarkMessageAsProcessed", mock.Anything, id, 0).Return(0, serviceerrs.ErrMessageAlreadyProcessed)
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name: "events", method: http.MethodGet, target: "/api/v1/messages/events?id=" + id.String(),
+			header: map[string]string{v1.HeaderLastEventID: "1"},
+			mock: func(m *contractMocks) {
+				events := make(chan entity.MessageEvent)
+				close(events)
+				m.Events.On("Subscribe", mock.Anything, mock.Anything).Return([]entity.MessageEvent{
+					{ID: 2, Type: entity.EventProcessed, MessageID: id, Status: entity.StatusProcessed, CreatedAt: now},
+				}, events)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "openapi", method: http.MethodGet, target: "/api/v1/openapi.json",
+			status: http.StatusOK,
+		},
+		{
+			name: "create without credentials", method: http.MethodPost, target: "/api/v1/messages",
+			contentType: echo.MIMEApplicationJSON, body: `{"message": "Hello, world!"}`,
+			header: map[string]string{v1.HeaderAPIKey: ""},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name: "process without admin scope", method: http.MethodPut, target: "/api/v1/messages/" + id.String() + "/process",
+			header: map[string]string{v1.HeaderAPIKey: testReaderKey},
+			status: http.StatusForbidden,
+		},
+		{
+			name: "create when rate limited", method: http.MethodPost, target: "/api/v1/messages",
+			contentType: echo.MIMEApplicationJSON, body: `{"message": "Hello, world!"}`,
+			mock: func(m *contractMocks) {
+				for m.Limits.Allow("principal:admin").Allowed {
+				}
+			},
+			status: http.StatusTooManyRequests,
+		},
+		{
+			name: "create over quota", method: http.MethodPost, target: "/api/v1/messages",
+			contentType: echo.MIMEApplicationJSON, body: `{"message": "Hello, world!"}`,
+			mock: func(m *contractMocks) {
+				for {
+					if _, err := m.Limits.ConsumeQuota(context.Background(), "principal:admin", 1); err != nil {
+						break
+					}
+				}
+			},
+			status: http.StatusTooManyRequests,
+		},
+		{
+			name: "create api key", method: http.MethodPost, target: "/api/v1/admin/api-keys",
+			contentType: echo.MIMEApplicationJSON, body: `{"name": "ingest", "scopes": ["messages:write"]}`,
+			mock: func(m *contractMocks) {
+				m.APIKeys.On("CreateAPIKey", mock.Anything, service.CreateAPIKeyInput{Name: "ingest", Scopes: []entity.Scope{entity.ScopeMessagesWrite}}).
+					Return(apiKey, "mk_secret", nil)
+			},
+			status: http.StatusCreated,
+		},
+		{
+			name: "create api key with unknown scope", method: http.MethodPost, target: "/api/v1/admin/api-keys",
+			contentType: echo.MIMEApplicationJSON, body: `{"name": "ingest", "scopes": ["messages:delete"]}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "list api keys", method: http.MethodGet, target: "/api/v1/admin/api-keys",
+			mock: func(m *contractMocks) {
+				m.APIKeys.On("GetAPIKeys", mock.Anything).Return([]entity.APIKey{apiKey}, nil)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "revoke api key", method: http.MethodDelete, target: "/api/v1/admin/api-keys/" + apiKey.ID.String(),
+			mock: func(m *contractMocks) {
+				m.APIKeys.On("RevokeAPIKey", mock.Anything, apiKey.ID).Return(nil)
+			},
+			status: http.StatusNoContent,
+		},
+		{
+			name: "revoke missing api key", method: http.MethodDelete, target: "/api/v1/admin/api-keys/" + apiKey.ID.String(),
+			mock: func(m *contractMocks) {
+				m.APIKeys.On("RevokeAPIKey", mock.Anything, apiKey.ID).Return(serviceerrs.ErrAPIKeyNotFound)
+			},
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, mocks := setupContract(t)
+			if tt.mock != nil {
+				tt.mock(mocks)
+			}
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set(echo.HeaderContentType, tt.contentType)
+			}
+			req.Header.Set(v1.HeaderAPIKey, testAdminKey)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.status, rec.Code, rec.Body.String())
+			mocks.AssertExpectations(t)
+		})
+	}
+}
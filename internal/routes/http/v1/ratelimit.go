@@ -0,0 +1,83 @@
+package v1
+
+import (
+	"math"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+)
+
+// Throttler applies the rate limit and the daily quota of the client, the
+// caller authenticated by Require or else the client IP. Limit goes after
+// Require in the middleware of a route, the quota is charged by the handlers
+// for the messages they create.
+type Throttler struct {
+	limits service.RateLimit
+}
+
+func NewThrottler(limits service.RateLimit) *Throttler {
+	return &Throttler{limits: limits}
+}
+
+// Limit answers 429 once the client spent its burst and reports its bucket in
+// RateLimit-* headers.
+func (t *Throttler) Limit() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			result := t.limits.Allow(clientKey(c))
+			if result.Limit > 0 {
+				h := c.Response().Header()
+				h.Set(HeaderRateLimitLimit, strconv.Itoa(result.Limit))
+				h.Set(HeaderRateLimitRemaining, strconv.Itoa(result.Remaining))
+				h.Set(HeaderRateLimitReset, seconds(result.Reset))
+			}
+			if !result.Allowed {
+				c.Response().Header().Set(echo.HeaderRetryAfter, seconds(result.RetryAfter))
+				return serviceerrs.ErrRateLimited
+			}
+			return next(c)
+		}
+	}
+}
+
+// ChargeQuota counts n messages against the daily quota of the client, all
+// of them or none. Once they do not fit it returns ErrQuotaExceeded and sets
+// Retry-After to when the quota resets.
+func (t *Throttler) ChargeQuota(c echo.Context, n int) error {
+	usage, err := t.limits.ConsumeQuota(c.Request().Context(), clientKey(c), n)
+	if err != nil {
+		if usage != nil {
+			c.Response().Header().Set(echo.HeaderRetryAfter, seconds(time.Until(usage.ResetsAt)))
+		}
+		return err
+	}
+	return nil
+}
+
+// RefundQuota gives back the quota of n charged messages that were not
+// created.
+func (t *Throttler) RefundQuota(c echo.Context, n int) {
+	t.limits.ReleaseQuota(c.Request().Context(), clientKey(c), n)
+}
+
+// clientKey identifies the client rate limits and quotas are kept for.
+func clientKey(c echo.Context) string {
+	if principal, ok := PrincipalFrom(c); ok {
+		return "principal:" + principal.Subject
+	}
+	return "ip:" + c.RealIP()
+}
+
+// seconds rounds d up to whole seconds, at least one.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(max(int(math.Ceil(d.Seconds())), 1))
+}
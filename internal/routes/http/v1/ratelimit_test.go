@@ -0,0 +1,138 @@
+package v1_test
+
+import (
+	"context"
+	"encoding/json"
+	"messagio_testsuite/internal/entity"
+	v1 "messagio_testsuite/internal/routes/http/v1"
+	routeerrs "messagio_testsuite/internal/routes/http/v1/route_errors"
+	"messagio_testsuite/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryQuota keeps quota usage in memory.
+type memoryQuota struct {
+	mu   sync.Mutex
+	used map[string]int64
+}
+
+func (m *memoryQuota) ConsumeQuota(_ context.Context, client string, day time.Time, n, limit int64) (int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := client + day.Format(time.DateOnly)
+	if m.used[key]+n > limit {
+		return m.used[key], false, nil
+	}
+	m.used[key] += n
+	return m.used[key], true, nil
+}
+
+func (m *memoryQuota) ReleaseQuota(_ context.Context, client string, day time.Time, n int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := client + day.Format(time.DateOnly)
+	m.used[key] = max(m.used[key]-n, 0)
+	return nil
+}
+
+func (m *memoryQuota) GetQuotaUsage(_ context.Context, client string, day time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.used[client+day.Format(time.DateOnly)], nil
+}
+
+func (m *memoryQuota) DeleteQuotaUsage(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func newRateLimitService(cfg service.RateLimitConfig) *service.RateLimitService {
+	return service.NewRateLimitService(&memoryQuota{used: make(map[string]int64)}, cfg)
+}
+
+func TestThrottler_Limit(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = routeerrs.ErrorHandler
+	limits := newRateLimitService(service.RateLimitConfig{Rate: 1, Burst: 2})
+	auth := v1.NewAuthenticator(testAuth)
+	e.GET("/limited", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, auth.Require(entity.ScopeMessagesRead), v1.NewThrottler(limits).Limit())
+
+	request := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		req.Header.Set(v1.HeaderAPIKey, key)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(testReaderKey)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get(v1.HeaderRateLimitLimit))
+	assert.Equal(t, "1", rec.Header().Get(v1.HeaderRateLimitRemaining))
+	assert.Equal(t, "1", rec.Header().Get(v1.HeaderRateLimitReset))
+
+	assert.Equal(t, http.StatusNoContent, request(testReaderKey).Code)
+
+	rec = request(testReaderKey)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get(v1.HeaderRateLimitRemaining))
+	assert.Equal(t, "1", rec.Header().Get(echo.HeaderRetryAfter))
+
+	assert.Equal(t, http.StatusNoContent, request(testAdminKey).Code, "every API key has its own bucket")
+}
+
+func TestThrottler_ChargeQuota(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = routeerrs.ErrorHandler
+	limits := newRateLimitService(service.RateLimitConfig{DailyQuota: 3})
+	throttle := v1.NewThrottler(limits)
+	auth := v1.NewAuthenticator(testAuth)
+	e.POST("/write", func(c echo.Context) error {
+		if err := throttle.ChargeQuota(c, 2); err != nil {
+			return err
+		}
+		// One of the two messages failed.
+		throttle.RefundQuota(c, 1)
+		return c.NoContent(http.StatusNoContent)
+	}, auth.Require(entity.ScopeMessagesWrite))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/write", nil)
+		req.Header.Set(v1.HeaderAPIKey, testAdminKey)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Empty(t, rec.Header().Get(v1.HeaderRateLimitLimit), "rate limiting is off")
+	}
+
+	// Two messages do not fit into the one left.
+	req := httptest.NewRequest(http.MethodPost, "/write", nil)
+	req.Header.Set(v1.HeaderAPIKey, testAdminKey)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get(echo.HeaderRetryAfter))
+
+	var problem struct {
+		Type string `json:"type"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+	assert.Equal(t, "urn:messaggio:problem:quota-exceeded", problem.Type)
+
+	usage, err := limits.GetQuotaUsage(context.Background(), "principal:admin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usage.Used)
+	assert.Equal(t, int64(1), usage.Remaining)
+}
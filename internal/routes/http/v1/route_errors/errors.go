@@ -1,17 +1,34 @@
 package routeerrs
 
 import (
-	"errors"
-
-	"github.com/labstack/echo/v4"
+	"fmt"
+	"net/http"
 )
 
-func NewErrorResponse(c echo.Context, errStatus int, message string) {
-	err := errors.New(message)
-	_, ok := err.(*echo.HTTPError)
-	if !ok {
-		report := echo.NewHTTPError(errStatus, err.Error())
-		_ = c.JSON(errStatus, report)
+// Error is returned by handlers for failures that are not a service or repo
+// error, e.g. a malformed request. Detail is shown to the client, Err is the
+// cause and only logged.
+type Error struct {
+	Status int
+	Detail string
+	Err    error
+}
+
+func New(status int, detail string, err error) *Error {
+	return &Error{Status: status, Detail: detail, Err: err}
+}
+
+func BadRequest(detail string, err error) *Error {
+	return New(http.StatusBadRequest, detail, err)
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%d: %s", e.Status, e.Detail)
 	}
-	c.Error(errors.New("internal server error"))
+	return fmt.Sprintf("%d: %s: %v", e.Status, e.Detail, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
 }
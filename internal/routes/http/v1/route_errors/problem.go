@@ -0,0 +1,81 @@
+package routeerrs
+
+import (
+	"errors"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+const problemTypePrefix = "urn:messaggio:problem:"
+
+// NewProblem describes err for the client, sentinel errors as mapped by
+// serviceerrs.Mappings. Errors it does not know are reported as an internal
+// server error without their text.
+func NewProblem(err error) Problem {
+	var routeErr *Error
+	if errors.As(err, &routeErr) {
+		return Problem{Type: "about:blank", Title: http.StatusText(routeErr.Status), Status: routeErr.Status, Detail: routeErr.Detail}
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		problem := Problem{Type: "about:blank", Title: http.StatusText(httpErr.Code), Status: httpErr.Code}
+		if msg, ok := httpErr.Message.(string); ok {
+			problem.Detail = msg
+		}
+		return problem
+	}
+
+	if m, ok := serviceerrs.Lookup(err); ok {
+		return Problem{Type: problemTypePrefix + m.Problem, Title: http.StatusText(m.Status), Status: m.Status, Detail: m.Detail(err)}
+	}
+
+	return Problem{Type: "about:blank", Title: http.StatusText(http.StatusInternalServerError), Status: http.StatusInternalServerError}
+}
+
+// ErrorHandler is the Echo HTTPErrorHandler. It writes errors returned by
+// handlers as application/problem+json and logs server errors with their
+// cause.
+func ErrorHandler(err error, c echo.Context) {
+	req := c.Request()
+	requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+
+	if c.Response().Committed {
+		// Streaming handlers fail after the status line went out.
+		logrus.WithField("request_id", requestID).Errorf("%s %s failed after responding: %v", req.Method, req.URL.Path, err)
+		return
+	}
+
+	problem := NewProblem(err)
+	problem.Instance = req.URL.Path
+	problem.RequestID = requestID
+
+	if problem.Status >= http.StatusInternalServerError {
+		logrus.WithField("request_id", requestID).Errorf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+
+	if req.Method == http.MethodHead {
+		err = c.NoContent(problem.Status)
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, MIMEApplicationProblemJSON)
+		err = c.JSON(problem.Status, problem)
+	}
+	if err != nil {
+		logrus.WithField("request_id", requestID).Errorf("Failed to write error response: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package routeerrs_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	routeerrs "messagio_testsuite/internal/routes/http/v1/route_errors"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProblem(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want routeerrs.Problem
+	}{
+		{
+			name: "sentinel",
+			err:  fmt.Errorf("mark: %w", serviceerrs.ErrVersionMismatch),
+			want: routeerrs.Problem{
+				Type:   "urn:messaggio:problem:version-mismatch",
+				Title:  "Precondition Failed",
+				Status: http.StatusPreconditionFailed,
+				Detail: "mark: message version does not match",
+			},
+		},
+		{
+			name: "server sentinel hides its cause",
+			err:  fmt.Errorf("%w: dial tcp: connection refused", serviceerrs.ErrCannotGetStats),
+			want: routeerrs.Problem{
+				Type:   "urn:messaggio:problem:cannot-get-stats",
+				Title:  "Internal Server Error",
+				Status: http.StatusInternalServerError,
+				Detail: serviceerrs.ErrCannotGetStats.Error(),
+			},
+		},
+		{
+			name: "route error",
+			err:  routeerrs.BadRequest("invalid id", errors.New("bad uuid")),
+			want: routeerrs.Problem{Type: "about:blank", Title: "Bad Request", Status: http.StatusBadRequest, Detail: "invalid id"},
+		},
+		{
+			name: "echo error",
+			err:  echo.NewHTTPError(http.StatusMethodNotAllowed, "method not allowed"),
+			want: routeerrs.Problem{Type: "about:blank", Title: "Method Not Allowed", Status: http.StatusMethodNotAllowed, Detail: "method not allowed"},
+		},
+		{
+			name: "unknown error hides its text",
+			err:  errors.New("pq: connection refused"),
+			want: routeerrs.Problem{Type: "about:blank", Title: "Internal Server Error", Status: http.StatusInternalServerError},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, routeerrs.NewProblem(tt.err))
+		})
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/messages/1", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Response().Header().Set(echo.HeaderXRequestID, "req-1")
+
+	routeerrs.ErrorHandler(serviceerrs.ErrMessageNotFound, c)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, routeerrs.MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+
+	var problem routeerrs.Problem
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+	assert.Equal(t, "urn:messaggio:problem:message-not-found", problem.Type)
+	assert.Equal(t, "/messages/1", problem.Instance)
+	assert.Equal(t, "req-1", problem.RequestID)
+}
+
+func TestErrorHandler_Committed(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/messages/events", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Response().WriteHeader(http.StatusOK)
+
+	routeerrs.ErrorHandler(errors.New("stream broke"), c)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String())
+}
@@ -1,32 +0,0 @@
-package v1
-
-import (
-	"messagio_testsuite/internal/service"
-	"os"
-
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
-	log "github.com/sirupsen/logrus"
-)
-
-func NewRouter(handler *echo.Echo, services *service.Services) {
-	handler.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
-		Format: `{"time":"${time_rfc3339_nano}", "method":"${method}","uri":"${uri}", "status":${status},"error":"${error}"}` + "\n",
-		Output: setLogsFile(),
-	}))
-	handler.Use(middleware.Recover())
-
-	v1 := handler.Group("/api/v1")
-	{
-		NewMessageRoutes(v1.Group("/messages"), services.Message)
-
-	}
-}
-
-func setLogsFile() *os.File {
-	file, err := os.OpenFile("/logs/requests.log", os.O_APPEND|os.O_CREATE|os.O_RDWR, 0666)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return file
-}
@@ -0,0 +1,310 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	wsCommandCreate = "create"
+
+	wsMessageAck   = "ack"
+	wsMessageError = "error"
+
+	// wsMaxMessageSize caps a single command frame.
+	wsMaxMessageSize = 64 << 10
+	// wsMaxPending caps the messages a connection waits on at the same time.
+	wsMaxPending = 1000
+	// wsSendBuffer is how many outgoing messages may queue up before the
+	// client is dropped as too slow.
+	wsSendBuffer = 256
+
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+type wsCommand struct {
+	Type      string `json:"type"`
+	RequestID string `json:"request_id,omitempty"`
+	Message   string `json:"message"`
+}
+
+type wsServerMessage struct {
+	Type      string               `json:"type"`
+	RequestID string               `json:"request_id,omitempty"`
+	ID        *uuid.UUID           `json:"id,omitempty"`
+	Status    entity.MessageStatus `json:"status,omitempty"`
+	Error     string               `json:"error,omitempty"`
+}
+
+// WebSocket upgrades to a connection on which clients send create commands.
+// Every command is acknowledged with the new message ID (or an error), and
+// the connection is notified once each of those messages is processed,
+// failed or dead lettered. Each create command is rate limited and charged
+// against the daily quota like a POST /messages.
+func (r *MessageRoutes) WebSocket(c echo.Context) error {
+	client := clientKey(c)
+	conn, err := wsUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// The upgrader already responded.
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
+	s := &wsSession{
+		conn:           conn,
+		messageService: r.MessageService,
+		limits:         r.RateLimitService,
+		client:         client,
+		eventsService:  r.EventsService,
+		send:           make(chan wsServerMessage, wsSendBuffer),
+		tracked:        service.NewMessageIDSet(),
+		cancel:         cancel,
+		closeCode:      websocket.CloseNormalClosure,
+	}
+
+	// Subscribe before taking commands so no notification slips through.
+	// The log only hands over the events of the messages tracked here.
+	_, events := r.EventsService.Subscribe(ctx, service.EventFilter{MessageIDs: s.tracked})
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		s.writeLoop(ctx)
+	}()
+	go s.forwardEvents(ctx, events)
+
+	s.readLoop(ctx)
+	cancel()
+	<-writerDone
+	return nil
+}
+
+// wsSession is the state of one WebSocket connection. readLoop and writeLoop
+// are the only reader and writer of conn.
+type wsSession struct {
+	conn           *websocket.Conn
+	messageService service.Message
+	eventsService  service.Events
+	limits         service.RateLimit
+	client         string
+	send           chan wsServerMessage
+	// tracked holds the messages created on this connection that did not
+	// get their notification yet.
+	tracked *service.MessageIDSet
+	cancel  context.CancelFunc
+
+	mu        sync.Mutex
+	closeCode int
+	closeText string
+}
+
+func (s *wsSession) readLoop(ctx context.Context) {
+	s.conn.SetReadLimit(wsMaxMessageSize)
+	_ = s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				s.closeWith(websocket.CloseMessageTooBig, "message too big")
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logrus.Debugf("WebSocket read failed: %v", err)
+			}
+			return
+		}
+
+		var cmd wsCommand
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			s.reply(ctx, wsServerMessage{Type: wsMessageError, Error: "invalid JSON"})
+			continue
+		}
+
+		switch cmd.Type {
+		case wsCommandCreate:
+			s.create(ctx, cmd)
+		default:
+			s.reply(ctx, wsServerMessage{Type: wsMessageError, RequestID: cmd.RequestID, Error: "unknown command type"})
+		}
+	}
+}
+
+func (s *wsSession) create(ctx context.Context, cmd wsCommand) {
+	if cmd.Message == "" {
+		s.reply(ctx, wsServerMessage{Type: wsMessageError, RequestID: cmd.RequestID, Error: serviceerrs.ErrEmptyMessage.Error()})
+		return
+	}
+
+	if s.tracked.Len() >= wsMaxPending {
+		s.reply(ctx, wsServerMessage{Type: wsMessageError, RequestID: cmd.RequestID, Error: "too many pending messages"})
+		return
+	}
+
+	if result := s.limits.Allow(s.client); !result.Allowed {
+		s.reply(ctx, wsServerMessage{Type: wsMessageError, RequestID: cmd.RequestID, Error: fmt.Sprintf("%s, retry in %s", serviceerrs.ErrRateLimited, result.RetryAfter.Round(time.Millisecond))})
+		return
+	}
+	if _, err := s.limits.ConsumeQuota(ctx, s.client, 1); err != nil {
+		s.reply(ctx, wsServerMessage{Type: wsMessageError, RequestID: cmd.RequestID, Error: err.Error()})
+		return
+	}
+
+	id, err := s.messageService.CreateMessage(ctx, cmd.Message)
+	if err != nil {
+		s.limits.ReleaseQuota(ctx, s.client, 1)
+		s.reply(ctx, wsServerMessage{Type: wsMessageError, RequestID: cmd.RequestID, Error: serviceerrs.ErrCannotCreateMessage.Error()})
+		return
+	}
+
+	s.tracked.Add(id)
+
+	s.reply(ctx, wsServerMessage{Type: wsMessageAck, RequestID: cmd.RequestID, ID: &id, Status: entity.StatusReceived})
+
+	// The message may have been processed before it was tracked, in which
+	// case its event was already passed over.
+	message, err := s.messageService.GetMessageById(ctx, id)
+	if err != nil {
+		logrus.Debugf("Failed to check the status of message %s: %v", id, err)
+		return
+	}
+	event := entity.MessageEvent{MessageID: id, Status: message.Status, Error: message.LastError}
+	switch message.Status {
+	case entity.StatusProcessed:
+		event.Type = entity.EventProcessed
+	case entity.StatusFailed:
+		event.Type = entity.EventFailed
+	case entity.StatusDeadLettered:
+		event.Type = entity.EventDeadLettered
+	default:
+		return
+	}
+	s.notify(event)
+}
+
+// reply queues a response to a command. It blocks while the send buffer is
+// full, which stops reading further commands until the client catches up.
+func (s *wsSession) reply(ctx context.Context, msg wsServerMessage) {
+	select {
+	case s.send <- msg:
+	case <-ctx.Done():
+	}
+}
+
+// forwardEvents notifies the client about the messages it created. Unlike
+// replies, notifications cannot wait, so a client that does not drain them is
+// disconnected. When the subscription ends it is resumed after the last event
+// received; the session is only closed once the event log is gone.
+func (s *wsSession) forwardEvents(ctx context.Context, events <-chan entity.MessageEvent) {
+	var (
+		last     uint64
+		received = true
+	)
+	forward := func(event entity.MessageEvent) bool {
+		last, received = event.ID, true
+		return event.Type == entity.EventCreated || s.notify(event)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if ok {
+				if !forward(event) {
+					return
+				}
+				continue
+			}
+			if !received {
+				// The new subscription ended right away.
+				s.closeWith(websocket.CloseTryAgainLater, "event stream closed")
+				return
+			}
+
+			var replay []entity.MessageEvent
+			replay, events = s.eventsService.Subscribe(ctx, service.EventFilter{After: last, MessageIDs: s.tracked})
+			received = false
+			for _, event := range replay {
+				if !forward(event) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// notify tells the client about the outcome of a tracked message, once. It
+// returns false when the client is disconnected for not keeping up.
+func (s *wsSession) notify(event entity.MessageEvent) bool {
+	if !s.tracked.Remove(event.MessageID) {
+		return true
+	}
+
+	id := event.MessageID
+	select {
+	case s.send <- wsServerMessage{Type: string(event.Type), ID: &id, Status: event.Status, Error: event.Error}:
+		return true
+	default:
+		s.closeWith(websocket.ClosePolicyViolation, "slow consumer")
+		return false
+	}
+}
+
+func (s *wsSession) writeLoop(ctx context.Context) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	defer s.conn.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			code, text := s.closeCode, s.closeText
+			s.mu.Unlock()
+			_ = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(wsWriteWait))
+			return
+		case msg := <-s.send:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := s.conn.WriteJSON(msg); err != nil {
+				s.cancel()
+				return
+			}
+		case <-ticker.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				s.cancel()
+				return
+			}
+		}
+	}
+}
+
+// closeWith ends the session and tells the client why.
+func (s *wsSession) closeWith(code int, text string) {
+	s.mu.Lock()
+	s.closeCode, s.closeText = code, text
+	s.mu.Unlock()
+	s.cancel()
+}
@@ -0,0 +1,208 @@
+package v1_test
+
+import (
+	"encoding/json"
+	"errors"
+	"messagio_testsuite/internal/entity"
+	v1 "messagio_testsuite/internal/routes/http/v1"
+	"messagio_testsuite/internal/service"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type wsMessage struct {
+	Type      string               `json:"type"`
+	RequestID string               `json:"request_id"`
+	ID        *uuid.UUID           `json:"id"`
+	Status    entity.MessageStatus `json:"status"`
+	Error     string               `json:"error"`
+}
+
+func dialWebSocket(t *testing.T) (*websocket.Conn, *MockMessageService, chan entity.MessageEvent) {
+	e, mockService, routes := setup()
+	conn, events := connectWebSocket(t, e, routes)
+	return conn, mockService, events
+}
+
+func connectWebSocket(t *testing.T, e *echo.Echo, routes *v1.MessageRoutes) (*websocket.Conn, chan entity.MessageEvent) {
+	events := make(chan entity.MessageEvent, 10)
+	routes.EventsService.(*MockEventsService).On("Subscribe", mock.Anything, mock.Anything).Return(nil, events)
+	return serveWebSocket(t, e, routes), events
+}
+
+func serveWebSocket(t *testing.T, e *echo.Echo, routes *v1.MessageRoutes) *websocket.Conn {
+	e.GET("/ws", routes.WebSocket)
+
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readWSMessage(t *testing.T, conn *websocket.Conn) wsMessage {
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var msg wsMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+func TestWebSocket_CreateAndNotify(t *testing.T) {
+	conn, mockService, events := dialWebSocket(t)
+
+	id := uuid.New()
+	mockService.On("CreateMessage", mock.Anything, "Hello, world!").Return(id, nil)
+	mockService.On("GetMessageById", mock.Anything, id).Return(entity.Message{ID: id, Status: entity.StatusReceived}, nil)
+
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "create", "request_id": "r1", "message": "Hello, world!"}))
+	ack := readWSMessage(t, conn)
+	assert.Equal(t, "ack", ack.Type)
+	assert.Equal(t, "r1", ack.RequestID)
+	if assert.NotNil(t, ack.ID) {
+		assert.Equal(t, id, *ack.ID)
+	}
+
+	// Only messages created on this connection are reported.
+	events <- entity.MessageEvent{ID: 1, Type: entity.EventProcessed, MessageID: uuid.New(), Status: entity.StatusProcessed}
+	events <- entity.MessageEvent{ID: 2, Type: entity.EventProcessed, MessageID: id, Status: entity.StatusProcessed}
+
+	processed := readWSMessage(t, conn)
+	assert.Equal(t, "processed", processed.Type)
+	if assert.NotNil(t, processed.ID) {
+		assert.Equal(t, id, *processed.ID)
+	}
+	assert.Equal(t, entity.StatusProcessed, processed.Status)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestWebSocket_NotifiesMessagesProcessedBeforeTracking(t *testing.T) {
+	conn, mockService, events := dialWebSocket(t)
+
+	id := uuid.New()
+	mockService.On("CreateMessage", mock.Anything, "Hello, world!").Return(id, nil)
+	mockService.On("GetMessageById", mock.Anything, id).Return(entity.Message{ID: id, Status: entity.StatusProcessed}, nil)
+
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "create", "request_id": "r1", "message": "Hello, world!"}))
+	assert.Equal(t, "ack", readWSMessage(t, conn).Type)
+
+	processed := readWSMessage(t, conn)
+	assert.Equal(t, "processed", processed.Type)
+	if assert.NotNil(t, processed.ID) {
+		assert.Equal(t, id, *processed.ID)
+	}
+
+	// The event that comes in afterwards is not reported again.
+	events <- entity.MessageEvent{ID: 2, Type: entity.EventProcessed, MessageID: id, Status: entity.StatusProcessed}
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "delete", "request_id": "r2"}))
+	assert.Equal(t, "r2", readWSMessage(t, conn).RequestID)
+}
+
+func TestWebSocket_InvalidCommands(t *testing.T) {
+	conn, mockService, _ := dialWebSocket(t)
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("{")))
+	assert.Equal(t, wsMessage{Type: "error", Error: "invalid JSON"}, readWSMessage(t, conn))
+
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "delete", "request_id": "r1"}))
+	assert.Equal(t, wsMessage{Type: "error", RequestID: "r1", Error: "unknown command type"}, readWSMessage(t, conn))
+
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "create", "request_id": "r2"}))
+	assert.Equal(t, wsMessage{Type: "error", RequestID: "r2", Error: "message is required"}, readWSMessage(t, conn))
+
+	mockService.AssertNotCalled(t, "CreateMessage", mock.Anything, mock.Anything)
+}
+
+func TestWebSocket_ThrottlesCreateCommands(t *testing.T) {
+	e, mockService, routes := setup()
+	limits := newRateLimitService(service.RateLimitConfig{Rate: 0.01, Burst: 2, DailyQuota: 1})
+	routes.RateLimitService = limits
+	conn, _ := connectWebSocket(t, e, routes)
+
+	id := uuid.New()
+	mockService.On("CreateMessage", mock.Anything, "Hello, world!").Return(id, nil).Once()
+	mockService.On("GetMessageById", mock.Anything, id).Return(entity.Message{ID: id, Status: entity.StatusReceived}, nil)
+
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "create", "request_id": "r1", "message": "Hello, world!"}))
+	assert.Equal(t, "ack", readWSMessage(t, conn).Type)
+
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "create", "request_id": "r2", "message": "Hello, world!"}))
+	assert.Equal(t, wsMessage{Type: "error", RequestID: "r2", Error: "daily quota exceeded"}, readWSMessage(t, conn))
+
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "create", "request_id": "r3", "message": "Hello, world!"}))
+	limited := readWSMessage(t, conn)
+	assert.Equal(t, "r3", limited.RequestID)
+	assert.True(t, strings.HasPrefix(limited.Error, "rate limit exceeded"), limited.Error)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestWebSocket_ClosesWhenEventsEnd(t *testing.T) {
+	conn, _, events := dialWebSocket(t)
+	close(events)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if assert.True(t, errors.As(err, &closeErr)) {
+		assert.Equal(t, websocket.CloseTryAgainLater, closeErr.Code)
+	}
+}
+
+func TestWebSocket_ResumesEndedSubscriptions(t *testing.T) {
+	e, mockService, routes := setup()
+	first, second := make(chan entity.MessageEvent, 10), make(chan entity.MessageEvent, 10)
+	mockEvents := routes.EventsService.(*MockEventsService)
+	mockEvents.On("Subscribe", mock.Anything, mock.MatchedBy(func(f service.EventFilter) bool {
+		return f.After == 0 && f.MessageIDs != nil
+	})).Return(nil, first).Once()
+	mockEvents.On("Subscribe", mock.Anything, mock.MatchedBy(func(f service.EventFilter) bool {
+		return f.After == 7 && f.MessageIDs != nil
+	})).Return(nil, second).Once()
+	conn := serveWebSocket(t, e, routes)
+
+	id := uuid.New()
+	mockService.On("CreateMessage", mock.Anything, "Hello, world!").Return(id, nil)
+	mockService.On("GetMessageById", mock.Anything, id).Return(entity.Message{ID: id, Status: entity.StatusReceived}, nil)
+
+	require.NoError(t, conn.WriteJSON(map[string]string{"type": "create", "request_id": "r1", "message": "Hello, world!"}))
+	assert.Equal(t, "ack", readWSMessage(t, conn).Type)
+
+	// The session picks up after the last event it got.
+	first <- entity.MessageEvent{ID: 7, Type: entity.EventCreated, MessageID: id, Status: entity.StatusReceived}
+	close(first)
+	second <- entity.MessageEvent{ID: 9, Type: entity.EventProcessed, MessageID: id, Status: entity.StatusProcessed}
+
+	processed := readWSMessage(t, conn)
+	assert.Equal(t, "processed", processed.Type)
+	if assert.NotNil(t, processed.ID) {
+		assert.Equal(t, id, *processed.ID)
+	}
+	mockEvents.AssertExpectations(t)
+}
+
+func TestWebSocket_RejectsLargeMessages(t *testing.T) {
+	conn, _, _ := dialWebSocket(t)
+
+	payload, err := json.Marshal(map[string]string{"type": "create", "message": strings.Repeat("a", 128<<10)})
+	require.NoError(t, err)
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, payload))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if assert.True(t, errors.As(err, &closeErr)) {
+		assert.Equal(t, websocket.CloseMessageTooBig, closeErr.Code)
+	}
+}
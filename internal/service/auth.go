@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/repo"
+	repoerrs "messagio_testsuite/internal/repo/repo_errors"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"messagio_testsuite/pkg/jwks"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// APIKeyPrefix starts every generated API key so leaked keys are easy to
+	// spot.
+	APIKeyPrefix = "mk_"
+
+	apiKeyBytes         = 32
+	apiKeyVisiblePart   = len(APIKeyPrefix) + 8
+	maxAPIKeyNameLength = 100
+	// minAPIKeyLength applies to keys from the configuration, generated keys
+	// are longer.
+	minAPIKeyLength = len(APIKeyPrefix) + 32
+	// exampleAPIKey is the placeholder shipped in .env.example, which must
+	// never be an actual key.
+	exampleAPIKey = "mk_change_me_to_a_long_random_secret"
+)
+
+var tokenMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+type AuthConfig struct {
+	// JWKS verifies bearer tokens. Without it only API keys are accepted.
+	JWKS *jwks.Set
+	// Issuer and Audience are checked against the iss and aud claims when set.
+	Issuer   string
+	Audience string
+}
+
+type AuthService struct {
+	apiKeyRepo repo.APIKey
+	cfg        AuthConfig
+	parser     *jwt.Parser
+}
+
+func NewAuthService(apiKeyRepo repo.APIKey, cfg AuthConfig) *AuthService {
+	opts := []jwt.ParserOption{jwt.WithValidMethods(tokenMethods), jwt.WithExpirationRequired()}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+
+	return &AuthService{
+		apiKeyRepo: apiKeyRepo,
+		cfg:        cfg,
+		parser:     jwt.NewParser(opts...),
+	}
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *AuthService) AuthenticateAPIKey(ctx context.Context, key string) (entity.Principal, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return entity.Principal{}, serviceerrs.ErrUnauthenticated
+	}
+
+	apiKey, err := s.apiKeyRepo.GetAPIKeyByHash(ctx, hashAPIKey(key))
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return entity.Principal{}, serviceerrs.ErrUnauthenticated
+		}
+		logrus.Errorf("Failed to look up API key: %v", err)
+		return entity.Principal{}, serviceerrs.ErrCannotAuthenticate
+	}
+	if apiKey.RevokedAt != nil {
+		return entity.Principal{}, serviceerrs.ErrUnauthenticated
+	}
+
+	return entity.Principal{Subject: apiKey.ID.String(), Scopes: apiKey.Scopes}, nil
+}
+
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	// Scope is the space separated list of RFC 8693, Scp the array some
+	// issuers send instead.
+	Scope string   `json:"scope"`
+	Scp   []string `json:"scp"`
+}
+
+func (s *AuthService) AuthenticateToken(_ context.Context, token string) (entity.Principal, error) {
+	if s.cfg.JWKS == nil {
+		return entity.Principal{}, serviceerrs.ErrUnauthenticated
+	}
+
+	var claims tokenClaims
+	if _, err := s.parser.ParseWithClaims(token, &claims, s.cfg.JWKS.Keyfunc); err != nil {
+		logrus.Debugf("Rejected bearer token: %v", err)
+		return entity.Principal{}, serviceerrs.ErrUnauthenticated
+	}
+
+	var scopes []entity.Scope
+	for _, name := range append(strings.Fields(claims.Scope), claims.Scp...) {
+		// Tokens often carry scopes of other services, skip those.
+		if scope := entity.Scope(name); scope.Valid() {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return entity.Principal{Subject: claims.Subject, Scopes: scopes}, nil
+}
+
+type CreateAPIKeyInput struct {
+	Name   string
+	Scopes []entity.Scope
+}
+
+// CreateAPIKey generates a key and stores its hash. The returned secret is
+// not kept and cannot be shown again.
+func (s *AuthService) CreateAPIKey(ctx context.Context, input CreateAPIKeyInput) (entity.APIKey, string, error) {
+	if err := validateAPIKeyInput(input); err != nil {
+		return entity.APIKey{}, "", err
+	}
+
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return entity.APIKey{}, "", fmt.Errorf("%w: %v", serviceerrs.ErrCannotManageAPIKeys, err)
+	}
+	secret := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	apiKey, err := s.apiKeyRepo.CreateAPIKey(ctx, entity.APIKey{
+		Name:    input.Name,
+		Prefix:  secret[:apiKeyVisiblePart],
+		KeyHash: hashAPIKey(secret),
+		Scopes:  input.Scopes,
+	})
+	if err != nil {
+		logrus.Errorf("Failed to create API key: %v", err)
+		return entity.APIKey{}, "", serviceerrs.ErrCannotManageAPIKeys
+	}
+	return apiKey, secret, nil
+}
+
+// EnsureAPIKey stores secret as a key unless it is already known. It lets a
+// deployment start with an admin key from its configuration. A revoked key
+// stays revoked.
+func (s *AuthService) EnsureAPIKey(ctx context.Context, secret string, input CreateAPIKeyInput) error {
+	if err := validateAPIKeyInput(input); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(secret, APIKeyPrefix) || len(secret) < minAPIKeyLength {
+		return fmt.Errorf("api key must start with %q and have at least %d characters", APIKeyPrefix, minAPIKeyLength)
+	}
+	if secret == exampleAPIKey {
+		return fmt.Errorf("api key is the example placeholder, generate a random one")
+	}
+
+	hash := hashAPIKey(secret)
+	_, err := s.apiKeyRepo.GetAPIKeyByHash(ctx, hash)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, repoerrs.ErrNotFound) {
+		return err
+	}
+
+	_, err = s.apiKeyRepo.CreateAPIKey(ctx, entity.APIKey{
+		Name:    input.Name,
+		Prefix:  secret[:apiKeyVisiblePart],
+		KeyHash: hash,
+		Scopes:  input.Scopes,
+	})
+	if errors.Is(err, repoerrs.ErrAlreadyExists) {
+		// Another instance stored it first.
+		return nil
+	}
+	return err
+}
+
+func validateAPIKeyInput(input CreateAPIKeyInput) error {
+	if input.Name == "" || len(input.Name) > maxAPIKeyNameLength {
+		return fmt.Errorf("%w: it must have 1 to %d characters", serviceerrs.ErrInvalidAPIKeyName, maxAPIKeyNameLength)
+	}
+	if len(input.Scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", serviceerrs.ErrInvalidScope)
+	}
+	for _, scope := range input.Scopes {
+		if !scope.Valid() {
+			return fmt.Errorf("%w: %q", serviceerrs.ErrInvalidScope, scope)
+		}
+	}
+	return nil
+}
+
+func (s *AuthService) GetAPIKeys(ctx context.Context) ([]entity.APIKey, error) {
+	keys, err := s.apiKeyRepo.GetAPIKeys(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to list API keys: %v", err)
+		return nil, serviceerrs.ErrCannotManageAPIKeys
+	}
+	return keys, nil
+}
+
+func (s *AuthService) RevokeAPIKey(ctx context.Context, id uuid.UUID) error {
+	err := s.apiKeyRepo.RevokeAPIKey(ctx, id)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return serviceerrs.ErrAPIKeyNotFound
+		}
+		logrus.Errorf("Failed to revoke API key: %v", err)
+		return serviceerrs.ErrCannotManageAPIKeys
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package service_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"messagio_testsuite/internal/entity"
+	repoerrs "messagio_testsuite/internal/repo/repo_errors"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"messagio_testsuite/pkg/jwks"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryAPIKeys keeps API keys in memory.
+type memoryAPIKeys struct {
+	mu   sync.Mutex
+	keys []entity.APIKey
+}
+
+func (m *memoryAPIKeys) CreateAPIKey(_ context.Context, key entity.APIKey) (entity.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.keys {
+		if k.KeyHash == key.KeyHash {
+			return entity.APIKey{}, repoerrs.ErrAlreadyExists
+		}
+	}
+	key.ID, key.CreatedAt = uuid.New(), time.Now()
+	m.keys = append(m.keys, key)
+	return key, nil
+}
+
+func (m *memoryAPIKeys) GetAPIKeyByHash(_ context.Context, hash string) (entity.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.keys {
+		if k.KeyHash == hash {
+			return k, nil
+		}
+	}
+	return entity.APIKey{}, repoerrs.ErrNotFound
+}
+
+func (m *memoryAPIKeys) GetAPIKeys(context.Context) ([]entity.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]entity.APIKey(nil), m.keys...), nil
+}
+
+func (m *memoryAPIKeys) RevokeAPIKey(_ context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, k := range m.keys {
+		if k.ID == id {
+			now := time.Now()
+			m.keys[i].RevokedAt = &now
+			return nil
+		}
+	}
+	return repoerrs.ErrNotFound
+}
+
+func TestAuthService_APIKeys(t *testing.T) {
+	repo := &memoryAPIKeys{}
+	s := service.NewAuthService(repo, service.AuthConfig{})
+	ctx := context.Background()
+
+	_, _, err := s.CreateAPIKey(ctx, service.CreateAPIKeyInput{Name: "ingest", Scopes: []entity.Scope{"messages:delete"}})
+	assert.ErrorIs(t, err, serviceerrs.ErrInvalidScope)
+	_, _, err = s.CreateAPIKey(ctx, service.CreateAPIKeyInput{Scopes: []entity.Scope{entity.ScopeMessagesWrite}})
+	assert.ErrorIs(t, err, serviceerrs.ErrInvalidAPIKeyName)
+
+	apiKey, secret, err := s.CreateAPIKey(ctx, service.CreateAPIKeyInput{Name: "ingest", Scopes: []entity.Scope{entity.ScopeMessagesWrite}})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, service.APIKeyPrefix))
+	assert.True(t, strings.HasPrefix(secret, apiKey.Prefix))
+	assert.NotContains(t, apiKey.KeyHash, secret[len(service.APIKeyPrefix):], "only the hash is stored")
+
+	principal, err := s.AuthenticateAPIKey(ctx, secret)
+	require.NoError(t, err)
+	assert.Equal(t, apiKey.ID.String(), principal.Subject)
+	assert.True(t, principal.HasScope(entity.ScopeMessagesWrite))
+	assert.False(t, principal.HasScope(entity.ScopeMessagesRead))
+
+	_, err = s.AuthenticateAPIKey(ctx, secret+"x")
+	assert.ErrorIs(t, err, serviceerrs.ErrUnauthenticated)
+
+	require.NoError(t, s.RevokeAPIKey(ctx, apiKey.ID))
+	_, err = s.AuthenticateAPIKey(ctx, secret)
+	assert.ErrorIs(t, err, serviceerrs.ErrUnauthenticated, "revoked keys are refused")
+	assert.ErrorIs(t, s.RevokeAPIKey(ctx, uuid.New()), serviceerrs.ErrAPIKeyNotFound)
+}
+
+func TestAuthService_EnsureAPIKey(t *testing.T) {
+	repo := &memoryAPIKeys{}
+	s := service.NewAuthService(repo, service.AuthConfig{})
+	ctx := context.Background()
+	input := service.CreateAPIKeyInput{Name: "bootstrap", Scopes: []entity.Scope{entity.ScopeMessagesAdmin}}
+
+	assert.Error(t, s.EnsureAPIKey(ctx, "mk_short", input))
+	assert.Error(t, s.EnsureAPIKey(ctx, "mk_change_me_to_a_long_random_secret", input), "the example key is refused")
+
+	secret := "mk_bootstrap_secret_for_tests_0123456789"
+	require.NoError(t, s.EnsureAPIKey(ctx, secret, input))
+	require.NoError(t, s.EnsureAPIKey(ctx, secret, input), "a known key is left alone")
+
+	keys, err := s.GetAPIKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+
+	principal, err := s.AuthenticateAPIKey(ctx, secret)
+	require.NoError(t, err)
+	assert.True(t, principal.HasScope(entity.ScopeMessagesWrite), "admin implies the other scopes")
+}
+
+func TestAuthService_AuthenticateToken(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	set, err := jwks.Parse([]byte(fmt.Sprintf(`{"keys": [{"kty": "EC", "kid": "k1", "crv": "P-256", "x": %q, "y": %q}]}`,
+		base64.RawURLEncoding.EncodeToString(private.X.FillBytes(make([]byte, 32))),
+		base64.RawURLEncoding.EncodeToString(private.Y.FillBytes(make([]byte, 32))),
+	)))
+	require.NoError(t, err)
+
+	s := service.NewAuthService(&memoryAPIKeys{}, service.AuthConfig{JWKS: set, Issuer: "https://issuer", Audience: "messaggio"})
+	ctx := context.Background()
+
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(private)
+		require.NoError(t, err)
+		return signed
+	}
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":   "client-1",
+			"iss":   "https://issuer",
+			"aud":   "messaggio",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": "messages:read openid",
+			"scp":   []string{"messages:write"},
+		}
+	}
+
+	principal, err := s.AuthenticateToken(ctx, sign(valid()))
+	require.NoError(t, err)
+	assert.Equal(t, "client-1", principal.Subject)
+	assert.ElementsMatch(t, []entity.Scope{entity.ScopeMessagesRead, entity.ScopeMessagesWrite}, principal.Scopes)
+
+	for name, change := range map[string]func(jwt.MapClaims){
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"no expiry":      func(c jwt.MapClaims) { delete(c, "exp") },
+		"other issuer":   func(c jwt.MapClaims) { c["iss"] = "https://other" },
+		"other audience": func(c jwt.MapClaims) { c["aud"] = "other" },
+	} {
+		claims := valid()
+		change(claims)
+		_, err := s.AuthenticateToken(ctx, sign(claims))
+		assert.ErrorIs(t, err, serviceerrs.ErrUnauthenticated, name)
+	}
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodES256, valid())
+	forged.Header["kid"] = "k1"
+	signed, err := forged.SignedString(other)
+	require.NoError(t, err)
+	_, err = s.AuthenticateToken(ctx, signed)
+	assert.ErrorIs(t, err, serviceerrs.ErrUnauthenticated, "tokens signed by unknown keys are refused")
+
+	_, err = service.NewAuthService(&memoryAPIKeys{}, service.AuthConfig{}).AuthenticateToken(ctx, sign(valid()))
+	assert.ErrorIs(t, err, serviceerrs.ErrUnauthenticated, "tokens are refused without a JWKS")
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"messagio_testsuite/internal/entity"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// cursor is what next_cursor carries between pages. The order is part of it
+// so a cursor cannot be replayed against a listing sorted the other way.
+type cursor struct {
+	CreatedAt time.Time        `json:"t"`
+	ID        uuid.UUID        `json:"id"`
+	Order     entity.SortOrder `json:"o"`
+}
+
+func encodeCursor(message entity.Message, order entity.SortOrder) string {
+	data, _ := json.Marshal(cursor{
+		CreatedAt: message.CreatedAt,
+		ID:        message.ID,
+		Order:     order,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string, order entity.SortOrder) (*entity.MessageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, serviceerrs.ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == uuid.Nil || c.Order != order {
+		return nil, serviceerrs.ErrInvalidCursor
+	}
+
+	return &entity.MessageCursor{CreatedAt: c.CreatedAt, ID: c.ID}, nil
+}
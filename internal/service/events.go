@@ -0,0 +1,485 @@
+package service
+
+import (
+	"context"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/repo"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultEventLogSize          = 1000
+	defaultEventSubscriberBuffer = 64
+	defaultEventPollInterval     = 250 * time.Millisecond
+
+	// eventPollBatch is how many transitions are read per query.
+	eventPollBatch = 500
+	// eventGapWait is how long a missing transition ID is looked for before
+	// it is taken for a rolled back insert and given up on.
+	eventGapWait = 5 * time.Second
+	// maxEventGaps caps the missing transition IDs looked for at a time.
+	maxEventGaps = 10000
+	// maxEventReplay caps the transitions read back from the database for a
+	// subscriber resuming from before the log.
+	maxEventReplay = 10000
+)
+
+type EventsConfig struct {
+	// LogSize is how many recent events are kept for resuming subscribers.
+	LogSize int
+	// SubscriberBuffer is how many events are handed to a subscriber ahead of
+	// it reading them.
+	SubscriberBuffer int
+	// PollInterval is how often the log looks for new message transitions.
+	PollInterval time.Duration
+}
+
+// EventLog fans message events out to subscribers. The events are read from
+// the message transitions every instance records, so an event carries the ID
+// of its transition and subscribers see the changes made by any instance. The
+// most recent events are kept in a ring buffer, each subscriber reads them at
+// its own pace and falls back to the database once it is behind the ring, so
+// a slow subscriber lags instead of being dropped.
+//
+// Transition IDs are taken before the transaction commits, so a transition
+// may become visible after one with a greater ID. Events are kept in the
+// order they were seen, which is the order of their IDs but for such late
+// ones.
+type EventLog struct {
+	messageRepo repo.Message
+	cfg         EventsConfig
+	// ready is closed once the log knows where the transitions it missed
+	// end.
+	ready chan struct{}
+	// gaps holds the IDs below the cursor that were not visible yet, with
+	// when they were first missed.
+	gaps map[int64]time.Time
+
+	mu sync.Mutex
+	// events holds the events at the positions from head-len(events) up to
+	// head, the event at position p in events[p%LogSize].
+	events []entity.MessageEvent
+	head   uint64
+	// cursor is the last transition looked at, start the last one before the
+	// log was ready and floor the greatest one no longer in events.
+	cursor uint64
+	start  uint64
+	floor  uint64
+	// appended is closed and replaced whenever events are appended, which
+	// wakes up the waiting subscribers.
+	appended chan struct{}
+	// done is closed with the log.
+	done   chan struct{}
+	closed bool
+}
+
+// NewEventLog returns a log fed by Run.
+func NewEventLog(messageRepo repo.Message, cfg EventsConfig) *EventLog {
+	if cfg.LogSize <= 0 {
+		cfg.LogSize = defaultEventLogSize
+	}
+	if cfg.SubscriberBuffer <= 0 {
+		cfg.SubscriberBuffer = defaultEventSubscriberBuffer
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultEventPollInterval
+	}
+
+	return &EventLog{
+		messageRepo: messageRepo,
+		cfg:         cfg,
+		ready:       make(chan struct{}),
+		gaps:        make(map[int64]time.Time),
+		events:      make([]entity.MessageEvent, 0, cfg.LogSize),
+		appended:    make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Run publishes the transitions recorded from now on until ctx is done.
+func (l *EventLog) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := l.poll(ctx); err != nil && ctx.Err() == nil {
+			logrus.Errorf("Failed to read message transitions: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll publishes the transitions recorded since the last call. The log moves
+// on past missing IDs right away and looks for them on the next polls, for
+// eventGapWait each, so a rolled back insert delays nothing.
+func (l *EventLog) poll(ctx context.Context) error {
+	select {
+	case <-l.ready:
+	default:
+		last, err := l.messageRepo.GetLastTransitionID(ctx)
+		if err != nil {
+			return err
+		}
+		l.mu.Lock()
+		l.cursor, l.start, l.floor = uint64(last), uint64(last), uint64(last)
+		l.mu.Unlock()
+		close(l.ready)
+		return nil
+	}
+
+	events, err := l.fillGaps(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		l.mu.Lock()
+		cursor := l.cursor
+		l.mu.Unlock()
+
+		transitions, err := l.messageRepo.GetTransitionsAfter(ctx, int64(cursor), eventPollBatch)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, t := range transitions {
+			for id := int64(cursor) + 1; id < t.ID; id++ {
+				if len(l.gaps) >= maxEventGaps {
+					logrus.Warnf("Skipping message transitions %d to %d, too many are missing", id, t.ID-1)
+					break
+				}
+				l.gaps[id] = now
+			}
+			cursor = uint64(t.ID)
+			if event, ok := transitionEvent(t); ok {
+				events = append(events, event)
+			}
+		}
+		l.publish(cursor, events)
+		if len(transitions) < eventPollBatch {
+			return nil
+		}
+		events = nil
+	}
+}
+
+// fillGaps returns the events of the missing transitions that showed up since
+// the last call and gives up on the ones missing for eventGapWait.
+func (l *EventLog) fillGaps(ctx context.Context) ([]entity.MessageEvent, error) {
+	if len(l.gaps) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, 0, len(l.gaps))
+	for id := range l.gaps {
+		ids = append(ids, id)
+	}
+	transitions, err := l.messageRepo.GetTransitions(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []entity.MessageEvent
+	for _, t := range transitions {
+		delete(l.gaps, t.ID)
+		if event, ok := transitionEvent(t); ok {
+			events = append(events, event)
+		}
+	}
+	var expired int
+	for id, since := range l.gaps {
+		if time.Since(since) >= eventGapWait {
+			delete(l.gaps, id)
+			expired++
+		}
+	}
+	if expired > 0 {
+		logrus.Debugf("Gave up on %d message transition(s) that did not show up", expired)
+	}
+	return events, nil
+}
+
+// publish moves the cursor and appends the events to the log. Subscribers are
+// only woken up, each one reads the events itself.
+func (l *EventLog) publish(cursor uint64, events []entity.MessageEvent) {
+	l.mu.Lock()
+	l.cursor = cursor
+	if len(events) == 0 {
+		l.mu.Unlock()
+		return
+	}
+	for _, event := range events {
+		if len(l.events) < l.cfg.LogSize {
+			l.events = append(l.events, event)
+		} else {
+			i := l.head % uint64(l.cfg.LogSize)
+			l.floor = max(l.floor, l.events[i].ID)
+			l.events[i] = event
+		}
+		l.head++
+	}
+	appended := l.appended
+	l.appended = make(chan struct{})
+	l.mu.Unlock()
+
+	close(appended)
+}
+
+// Subscribe returns the events logged after the one with ID filter.After and
+// a channel with the ones published from now on. The channel is closed when ctx is done or the log is
+// closed.
+func (l *EventLog) Subscribe(ctx context.Context, filter EventFilter) ([]entity.MessageEvent, <-chan entity.MessageEvent) {
+	ch := make(chan entity.MessageEvent, l.cfg.SubscriberBuffer)
+
+	// Until the log knows where the recorded transitions end, it cannot tell
+	// the ones to publish from the ones before the subscription.
+	select {
+	case <-l.ready:
+	case <-ctx.Done():
+		close(ch)
+		return nil, ch
+	}
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		close(ch)
+		return nil, ch
+	}
+	var (
+		replay []entity.MessageEvent
+		until  uint64
+	)
+	if filter.After > 0 {
+		// A subscriber resuming within the ring gets what was logged after its
+		// last event, late ones included. One resuming from further back gets
+		// the evicted events from the database.
+		from, after := l.tail(), filter.After
+		if p, ok := l.find(filter.After); ok {
+			from, after = p+1, 0
+		} else if filter.After < l.floor {
+			until, after = l.floor, l.floor
+		}
+		for p := from; p < l.head; p++ {
+			if event := l.at(p); event.ID > after && filter.matches(event) {
+				replay = append(replay, event)
+			}
+		}
+	}
+	pos, last := l.head, l.cursor
+	l.mu.Unlock()
+
+	if until > 0 {
+		replay = append(l.replay(ctx, filter.After, until, filter), replay...)
+	}
+
+	go l.deliver(ctx, filter, pos, last, ch)
+	return replay, ch
+}
+
+// deliver sends the events from position pos on to ch until ctx is done or
+// the log is closed. last is the ID of the transition before pos, the point
+// to read back from the database from when the subscriber falls behind the
+// ring.
+func (l *EventLog) deliver(ctx context.Context, filter EventFilter, pos, last uint64, ch chan<- entity.MessageEvent) {
+	defer close(ch)
+
+	send := func(events []entity.MessageEvent) bool {
+		for _, event := range events {
+			select {
+			case ch <- event:
+			case <-ctx.Done():
+				return false
+			case <-l.done:
+				return false
+			}
+		}
+		return true
+	}
+
+	for {
+		l.mu.Lock()
+		// Events evicted before the subscriber got to them are read back
+		// from the database.
+		var from, until uint64
+		if pos < l.tail() {
+			from, until, pos = max(last, l.start), l.floor, l.tail()
+		}
+		var events []entity.MessageEvent
+		for ; pos < l.head; pos++ {
+			event := l.at(pos)
+			if event.ID > until && filter.matches(event) {
+				events = append(events, event)
+			}
+			last = max(last, event.ID)
+		}
+		appended := l.appended
+		l.mu.Unlock()
+
+		if until > from {
+			events = append(l.replay(ctx, from, until, filter), events...)
+		}
+		if !send(events) {
+			return
+		}
+
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return
+		case <-l.done:
+			return
+		}
+	}
+}
+
+// find returns the position of the event with the given ID, it must be called
+// with mu held.
+func (l *EventLog) find(id uint64) (uint64, bool) {
+	for p := l.head; p > l.tail(); p-- {
+		if l.at(p-1).ID == id {
+			return p - 1, true
+		}
+	}
+	return 0, false
+}
+
+// tail is the position of the oldest event in the ring, it must be called
+// with mu held.
+func (l *EventLog) tail() uint64 {
+	return l.head - uint64(len(l.events))
+}
+
+// at returns the event at position p, it must be called with mu held.
+func (l *EventLog) at(p uint64) entity.MessageEvent {
+	return l.events[p%uint64(l.cfg.LogSize)]
+}
+
+// replay reads the events after the transition after up to and including
+// until back from the database.
+func (l *EventLog) replay(ctx context.Context, after, until uint64, filter EventFilter) []entity.MessageEvent {
+	var (
+		events []entity.MessageEvent
+		cursor = int64(after)
+	)
+	for read := 0; read < maxEventReplay; {
+		transitions, err := l.messageRepo.GetTransitionsAfter(ctx, cursor, eventPollBatch)
+		if err != nil {
+			logrus.Errorf("Failed to replay message events after %d: %v", cursor, err)
+			return events
+		}
+		for _, t := range transitions {
+			if uint64(t.ID) > until {
+				return events
+			}
+			if event, ok := transitionEvent(t); ok && filter.matches(event) {
+				events = append(events, event)
+			}
+			cursor = t.ID
+		}
+		if len(transitions) < eventPollBatch {
+			return events
+		}
+		read += len(transitions)
+	}
+
+	logrus.Warnf("Replayed the first %d message transitions after %d only", maxEventReplay, after)
+	return events
+}
+
+// Close ends all subscriptions, so long-lived streams do not hold up a
+// server shutdown. Later subscriptions are closed right away.
+func (l *EventLog) Close() {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	close(l.done)
+}
+
+func (f EventFilter) matches(event entity.MessageEvent) bool {
+	if f.MessageID != nil && *f.MessageID != event.MessageID {
+		return false
+	}
+	return f.MessageIDs == nil || f.MessageIDs.Contains(event.MessageID)
+}
+
+// MessageIDSet is a set of message IDs safe for concurrent use.
+type MessageIDSet struct {
+	mu  sync.Mutex
+	ids map[uuid.UUID]struct{}
+}
+
+func NewMessageIDSet() *MessageIDSet {
+	return &MessageIDSet{ids: make(map[uuid.UUID]struct{})}
+}
+
+func (s *MessageIDSet) Add(id uuid.UUID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ids[id] = struct{}{}
+}
+
+// Remove reports whether id was in the set.
+func (s *MessageIDSet) Remove(id uuid.UUID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.ids[id]
+	delete(s.ids, id)
+	return ok
+}
+
+func (s *MessageIDSet) Contains(id uuid.UUID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.ids[id]
+	return ok
+}
+
+func (s *MessageIDSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.ids)
+}
+
+// transitionEvent returns the event of a transition to a status subscribers
+// care about.
+func transitionEvent(t entity.MessageTransition) (entity.MessageEvent, bool) {
+	event := entity.MessageEvent{
+		ID:        uint64(t.ID),
+		MessageID: t.MessageID,
+		Status:    t.To,
+		Error:     t.Reason,
+		CreatedAt: t.CreatedAt,
+	}
+	switch t.To {
+	case entity.StatusReceived:
+		event.Type = entity.EventCreated
+	case entity.StatusProcessed:
+		event.Type = entity.EventProcessed
+	case entity.StatusFailed:
+		event.Type = entity.EventFailed
+	case entity.StatusDeadLettered:
+		event.Type = entity.EventDeadLettered
+	default:
+		return entity.MessageEvent{}, false
+	}
+	return event, true
+}
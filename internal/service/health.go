@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/repo"
+	"messagio_testsuite/pkg/kafka"
+	"sync"
+	"time"
+)
+
+const defaultHealthTimeout = 2 * time.Second
+
+type HealthConfig struct {
+	// Timeout bounds every check of a probe.
+	Timeout time.Duration
+	// The outbox is reported down once more than OutboxMaxPending events are
+	// pending or the oldest of them is older than OutboxMaxAge, zero turns the
+	// respective limit off.
+	OutboxMaxPending int64
+	OutboxMaxAge     time.Duration
+	// SchemaVersion is the version of the latest migration, the startup probe
+	// waits for the database to reach it.
+	SchemaVersion uint
+}
+
+// HealthService checks the dependencies of the role for the probes. Checks of
+// a probe run concurrently and each is reported with its latency.
+type HealthService struct {
+	role          entity.Role
+	schemaRepo    repo.Schema
+	outboxRepo    repo.Outbox
+	kafkaProducer kafka.Producer
+	kafkaConsumer kafka.Consumer
+	cfg           HealthConfig
+	now           func() time.Time
+}
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) (interface{}, error)
+}
+
+func NewHealthService(role entity.Role, schemaRepo repo.Schema, outboxRepo repo.Outbox, kafkaProducer kafka.Producer, kafkaConsumer kafka.Consumer, cfg HealthConfig) *HealthService {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHealthTimeout
+	}
+
+	return &HealthService{
+		role:          role,
+		schemaRepo:    schemaRepo,
+		outboxRepo:    outboxRepo,
+		kafkaProducer: kafkaProducer,
+		kafkaConsumer: kafkaConsumer,
+		cfg:           cfg,
+		now:           time.Now,
+	}
+}
+
+// Live only tells that the process serves requests, restarting it would not
+// bring back a dependency that is down.
+func (s *HealthService) Live(context.Context) entity.HealthReport {
+	return entity.HealthReport{Status: entity.HealthStatusUp, Role: s.role}
+}
+
+// Ready only checks Kafka and the outbox backlog on instances that process
+// messages, API instances accept messages while the workers are down.
+func (s *HealthService) Ready(ctx context.Context) entity.HealthReport {
+	checks := []healthCheck{
+		{name: "postgres", check: s.checkPostgres},
+	}
+	if s.role.ProcessesMessages() {
+		checks = append(checks,
+			healthCheck{name: "kafka", check: s.checkKafka},
+			healthCheck{name: "consumer", check: s.checkConsumer},
+			healthCheck{name: "outbox", check: s.checkOutbox},
+		)
+	}
+	return s.run(ctx, checks)
+}
+
+func (s *HealthService) Started(ctx context.Context) entity.HealthReport {
+	return s.run(ctx, []healthCheck{
+		{name: "migrations", check: s.checkMigrations},
+	})
+}
+
+func (s *HealthService) run(ctx context.Context, checks []healthCheck) entity.HealthReport {
+	report := entity.HealthReport{
+		Status:     entity.HealthStatusUp,
+		Role:       s.role,
+		Components: make([]entity.ComponentHealth, len(checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c healthCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+			defer cancel()
+
+			start := time.Now()
+			details, err := c.check(ctx)
+			component := entity.ComponentHealth{
+				Name:    c.name,
+				Status:  entity.HealthStatusUp,
+				Latency: float64(time.Since(start).Microseconds()) / 1000,
+				Details: details,
+			}
+			if err != nil {
+				component.Status = entity.HealthStatusDown
+				component.Error = err.Error()
+			}
+			report.Components[i] = component
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, component := range report.Components {
+		if component.Status != entity.HealthStatusUp {
+			report.Status = entity.HealthStatusDown
+		}
+	}
+	return report
+}
+
+func (s *HealthService) checkPostgres(ctx context.Context) (interface{}, error) {
+	return nil, s.schemaRepo.Ping(ctx)
+}
+
+func (s *HealthService) checkKafka(ctx context.Context) (interface{}, error) {
+	return nil, s.kafkaProducer.Ping(ctx)
+}
+
+func (s *HealthService) checkConsumer(context.Context) (interface{}, error) {
+	stats := s.kafkaConsumer.Stats()
+	if !s.kafkaConsumer.Running() {
+		return stats, errors.New("consumer is not running")
+	}
+	return stats, nil
+}
+
+func (s *HealthService) checkOutbox(ctx context.Context) (interface{}, error) {
+	backlog, err := s.outboxRepo.GetBacklog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cfg.OutboxMaxPending > 0 && backlog.Pending > s.cfg.OutboxMaxPending {
+		return backlog, fmt.Errorf("%d events pending, more than %d", backlog.Pending, s.cfg.OutboxMaxPending)
+	}
+	if s.cfg.OutboxMaxAge > 0 && backlog.OldestAt != nil {
+		if age := s.now().Sub(*backlog.OldestAt); age > s.cfg.OutboxMaxAge {
+			return backlog, fmt.Errorf("oldest event pending for %s, longer than %s", age.Round(time.Second), s.cfg.OutboxMaxAge)
+		}
+	}
+	return backlog, nil
+}
+
+func (s *HealthService) checkMigrations(ctx context.Context) (interface{}, error) {
+	version, err := s.schemaRepo.GetSchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if version.Dirty {
+		return version, fmt.Errorf("migration %d failed and needs fixing", version.Version)
+	}
+	if version.Version < s.cfg.SchemaVersion {
+		return version, fmt.Errorf("waiting for migration %d", s.cfg.SchemaVersion)
+	}
+	return version, nil
+}
@@ -0,0 +1,103 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/service"
+	"messagio_testsuite/pkg/kafka"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSchema struct {
+	pingErr error
+	version entity.SchemaVersion
+}
+
+func (s *fakeSchema) Ping(context.Context) error {
+	return s.pingErr
+}
+
+func (s *fakeSchema) GetSchemaVersion(context.Context) (entity.SchemaVersion, error) {
+	return s.version, nil
+}
+
+func componentsByName(report entity.HealthReport) map[string]entity.ComponentHealth {
+	components := make(map[string]entity.ComponentHealth, len(report.Components))
+	for _, c := range report.Components {
+		components[c.Name] = c
+	}
+	return components
+}
+
+func TestHealthService_Ready(t *testing.T) {
+	broker := kafka.NewMemoryBroker(1)
+	repos := newMemoryRepo()
+	schema := &fakeSchema{pingErr: errors.New("connection refused")}
+	consumer := kafka.NewMemoryConsumer(broker, "group", "messages")
+	messages := service.NewMessageService(repos, consumer)
+	health := service.NewHealthService(entity.RoleAll, schema, repos, kafka.NewMemoryProducer(broker, "messages"), consumer, service.HealthConfig{
+		OutboxMaxPending: 1,
+	})
+	ctx := context.Background()
+
+	report := health.Ready(ctx)
+	assert.Equal(t, entity.HealthStatusDown, report.Status)
+	components := componentsByName(report)
+	require.Len(t, components, 4)
+	assert.Equal(t, entity.HealthStatusDown, components["postgres"].Status)
+	assert.Equal(t, "connection refused", components["postgres"].Error)
+	assert.Equal(t, entity.HealthStatusUp, components["kafka"].Status)
+	assert.Equal(t, entity.HealthStatusDown, components["consumer"].Status, "Consume is not running")
+	assert.Equal(t, entity.HealthStatusUp, components["outbox"].Status)
+
+	schema.pingErr = nil
+	consumerCtx, stopConsumer := context.WithCancel(ctx)
+	defer stopConsumer()
+	go consumer.Consume(consumerCtx, func(context.Context, kafka.Envelope) error { return nil })
+	require.Eventually(t, consumer.Running, time.Second, 10*time.Millisecond)
+
+	report = health.Ready(ctx)
+	assert.Equal(t, entity.HealthStatusUp, report.Status, report)
+
+	for i := 0; i < 2; i++ {
+		_, err := messages.CreateMessage(ctx, "Hello, world!")
+		require.NoError(t, err)
+	}
+	report = health.Ready(ctx)
+	assert.Equal(t, entity.HealthStatusDown, report.Status)
+	outbox := componentsByName(report)["outbox"]
+	assert.Equal(t, entity.HealthStatusDown, outbox.Status)
+	assert.Equal(t, entity.OutboxBacklog{Pending: 2}, outbox.Details)
+}
+
+func TestHealthService_ReadyForTheAPIRole(t *testing.T) {
+	health := service.NewHealthService(entity.RoleAPI, &fakeSchema{}, newMemoryRepo(), nil, nil, service.HealthConfig{})
+
+	report := health.Ready(context.Background())
+	assert.Equal(t, entity.HealthStatusUp, report.Status)
+	assert.Equal(t, entity.RoleAPI, report.Role)
+	require.Len(t, report.Components, 1, "the API does not depend on Kafka or the workers")
+	assert.Equal(t, "postgres", report.Components[0].Name)
+}
+
+func TestHealthService_Started(t *testing.T) {
+	schema := &fakeSchema{version: entity.SchemaVersion{Version: 9}}
+	health := service.NewHealthService(entity.RoleWorker, schema, newMemoryRepo(), nil, nil, service.HealthConfig{SchemaVersion: 10})
+	ctx := context.Background()
+
+	report := health.Started(ctx)
+	assert.Equal(t, entity.HealthStatusDown, report.Status)
+	require.Len(t, report.Components, 1)
+	assert.Equal(t, "waiting for migration 10", report.Components[0].Error)
+
+	schema.version = entity.SchemaVersion{Version: 10, Dirty: true}
+	assert.Equal(t, entity.HealthStatusDown, health.Started(ctx).Status)
+
+	schema.version = entity.SchemaVersion{Version: 10}
+	assert.Equal(t, entity.HealthStatusUp, health.Started(ctx).Status)
+}
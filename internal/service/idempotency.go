@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/repo"
+	repoerrs "messagio_testsuite/internal/repo/repo_errors"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultIdempotencyTTL   = 24 * time.Hour
+	defaultIdempotencyLease = time.Minute
+
+	idempotencyPurgeInterval = time.Minute
+)
+
+type IdempotencyConfig struct {
+	// TTL is how long a completed response is replayed.
+	TTL time.Duration
+	// Lease is how long a request holds its key before another request with
+	// the same key may take over.
+	Lease time.Duration
+}
+
+type IdempotencyService struct {
+	idempotencyRepo repo.Idempotency
+	cfg             IdempotencyConfig
+	lastPurge       atomic.Int64
+}
+
+func NewIdempotencyService(idempotencyRepo repo.Idempotency, cfg IdempotencyConfig) *IdempotencyService {
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultIdempotencyTTL
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = defaultIdempotencyLease
+	}
+
+	return &IdempotencyService{
+		idempotencyRepo: idempotencyRepo,
+		cfg:             cfg,
+	}
+}
+
+// Fingerprint identifies a request by its method, path and canonical body.
+func Fingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Begin reserves the client's key for the request. Keys are scoped to the
+// client, so different clients never see each other's requests. It returns the stored response when
+// the key was already completed by the same request, ErrIdempotencyKeyReused
+// when it was used for a different one and ErrIdempotencyKeyInFlight when the
+// first request is still running.
+func (s *IdempotencyService) Begin(ctx context.Context, client, key, fingerprint string) (*entity.IdempotencyKey, error) {
+	s.purge(ctx)
+
+	record, reserved, err := s.idempotencyRepo.ReserveKey(ctx, client, key, fingerprint, s.cfg.TTL, s.cfg.Lease)
+	if errors.Is(err, repoerrs.ErrNotFound) {
+		// The holder released the key in between, try again once.
+		record, reserved, err = s.idempotencyRepo.ReserveKey(ctx, client, key, fingerprint, s.cfg.TTL, s.cfg.Lease)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to reserve idempotency key: %v", err)
+		return nil, serviceerrs.ErrIdempotencyUnavailable
+	}
+
+	switch {
+	case reserved:
+		return nil, nil
+	case record.Fingerprint != fingerprint:
+		return nil, serviceerrs.ErrIdempotencyKeyReused
+	case !record.Completed():
+		return nil, serviceerrs.ErrIdempotencyKeyInFlight
+	}
+	return &record, nil
+}
+
+// Complete stores the response to replay for the client's key.
+func (s *IdempotencyService) Complete(ctx context.Context, client, key string, statusCode int, response []byte) error {
+	if err := s.idempotencyRepo.CompleteKey(ctx, client, key, statusCode, response); err != nil {
+		logrus.Errorf("Failed to store idempotent response: %v", err)
+		return serviceerrs.ErrIdempotencyUnavailable
+	}
+	return nil
+}
+
+// Release gives up a key whose request failed, so it can be retried.
+func (s *IdempotencyService) Release(ctx context.Context, client, key string) {
+	if err := s.idempotencyRepo.ReleaseKey(ctx, client, key); err != nil {
+		logrus.Errorf("Failed to release idempotency key: %v", err)
+	}
+}
+
+func (s *IdempotencyService) purge(ctx context.Context) {
+	last := s.lastPurge.Load()
+	now := time.Now().UnixNano()
+	if time.Duration(now-last) < idempotencyPurgeInterval || !s.lastPurge.CompareAndSwap(last, now) {
+		return
+	}
+
+	deleted, err := s.idempotencyRepo.DeleteExpiredKeys(ctx)
+	if err != nil {
+		logrus.Errorf("Failed to purge expired idempotency keys: %v", err)
+		return
+	}
+	if deleted > 0 {
+		logrus.Debugf("Purged %d expired idempotency key(s)", deleted)
+	}
+}
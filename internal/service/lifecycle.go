@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/repo"
+	repoerrs "messagio_testsuite/internal/repo/repo_errors"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+
+	"github.com/google/uuid"
+)
+
+// transitionAttempts bounds how often a transition is retried when the status
+// changes between reading and updating the message.
+const transitionAttempts = 3
+
+// transitionMessage moves the message to status `to` if its current status
+// allows it. It returns the message as it was before. A non-zero
+// version makes the transition conditional on the message still being at that
+// version; conflicts are then reported as ErrVersionMismatch instead of being
+// retried.
+func transitionMessage(ctx context.Context, messageRepo repo.Message, id uuid.UUID, version int, to entity.MessageStatus, reason string) (entity.Message, error) {
+	for attempt := 1; ; attempt++ {
+		message, err := messageRepo.GetMessageById(ctx, id)
+		if err != nil {
+			if errors.Is(err, repoerrs.ErrNotFound) {
+				return entity.Message{}, serviceerrs.ErrMessageNotFound
+			}
+			return entity.Message{}, err
+		}
+		if version != 0 && message.Version != version {
+			return message, serviceerrs.ErrVersionMismatch
+		}
+
+		if !message.Status.CanTransition(to) {
+			return message, fmt.Errorf("%w: %s to %s", serviceerrs.ErrInvalidTransition, message.Status, to)
+		}
+
+		err = messageRepo.UpdateMessageStatus(ctx, id, message.Version, message.Status, to, reason)
+		switch {
+		case errors.Is(err, repoerrs.ErrNotFound):
+			return message, serviceerrs.ErrMessageNotFound
+		case errors.Is(err, repoerrs.ErrConflict) && version != 0:
+			return message, serviceerrs.ErrVersionMismatch
+		case errors.Is(err, repoerrs.ErrConflict) && attempt < transitionAttempts:
+			continue
+		}
+		return message, err
+	}
+}
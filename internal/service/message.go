@@ -3,11 +3,15 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/metrics"
 	"messagio_testsuite/internal/repo"
 	repoerrs "messagio_testsuite/internal/repo/repo_errors"
 	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"messagio_testsuite/internal/tracing"
 	"messagio_testsuite/pkg/kafka"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
@@ -15,29 +19,24 @@ import (
 
 type MessageService struct {
 	messageRepo   repo.Message
-	kafkaProducer *kafka.KafkaProducer
-	kafkaConsumer *kafka.KafkaConsumer
+	kafkaConsumer kafka.Consumer
 }
 
-func NewMessageService(messageRepo repo.Message, kafkaProducer *kafka.KafkaProducer, kafkaConsumer *kafka.KafkaConsumer) *MessageService {
-	s := &MessageService{
+func NewMessageService(messageRepo repo.Message, kafkaConsumer kafka.Consumer) *MessageService {
+	return &MessageService{
 		messageRepo:   messageRepo,
-		kafkaProducer: kafkaProducer,
 		kafkaConsumer: kafkaConsumer,
 	}
-
-	go s.listenForProcessedMessages()
-
-	return s
 }
 
 func (s *MessageService) CreateMessage(ctx context.Context, content string) (uuid.UUID, error) {
-	message := entity.Message{
-		Message: content,
+	message, event, err := newMessage(ctx, content)
+	if err != nil {
+		return uuid.Nil, err
 	}
 
 	logrus.Infof("Creating message: %s", content)
-	id, err := s.messageRepo.CreateMessage(ctx, message)
+	id, err := s.messageRepo.CreateMessage(ctx, message, event)
 	if err != nil {
 		if errors.Is(err, repoerrs.ErrInsertFailed) {
 			return uuid.Nil, serviceerrs.ErrCannotCreateMessage
@@ -46,14 +45,85 @@ func (s *MessageService) CreateMessage(ctx context.Context, content string) (uui
 		return uuid.Nil, err
 	}
 
-	err = s.kafkaProducer.Produce(ctx, content)
+	logrus.Infof("Message created with ID: %s", id)
+	metrics.MessagesCreated.Inc()
+	return id, nil
+}
+
+// CreateMessages stores a batch of messages in one transaction. Empty
+// messages are rejected individually, the others are created together or,
+// if the insert fails, all reported as failed.
+func (s *MessageService) CreateMessages(ctx context.Context, contents []string) ([]CreateMessageResult, error) {
+	if len(contents) > MaxBatchSize {
+		return nil, serviceerrs.ErrBatchTooLarge
+	}
+
+	results := make([]CreateMessageResult, len(contents))
+	messages := make([]entity.Message, 0, len(contents))
+	events := make([]entity.OutboxEvent, 0, len(contents))
+	indexes := make([]int, 0, len(contents))
+	for i, content := range contents {
+		results[i].Index = i
+		if content == "" {
+			results[i].Error = serviceerrs.ErrEmptyMessage.Error()
+			continue
+		}
+
+		message, event, err := newMessage(ctx, content)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		messages = append(messages, message)
+		events = append(events, event)
+		indexes = append(indexes, i)
+	}
+
+	if len(messages) == 0 {
+		return results, nil
+	}
+
+	logrus.Infof("Creating %d message(s) in a batch", len(messages))
+	if err := s.messageRepo.CreateMessages(ctx, messages, events); err != nil {
+		logrus.Errorf("Failed to create message batch: %v", err)
+		for _, i := range indexes {
+			results[i].Error = serviceerrs.ErrCannotCreateMessage.Error()
+		}
+		return results, nil
+	}
+
+	metrics.MessagesCreated.Add(float64(len(messages)))
+	for j, i := range indexes {
+		results[i].ID = &messages[j].ID
+	}
+	return results, nil
+}
+
+// newMessage builds a message and the outbox event that publishes it. The
+// event is committed together with the message and published by the
+// OutboxRelay, so a Kafka outage does not fail the request. The event keeps
+// the trace context of ctx so the message can be followed across the relay.
+func newMessage(ctx context.Context, content string) (entity.Message, entity.OutboxEvent, error) {
+	message := entity.Message{
+		ID:        uuid.New(),
+		Message:   content,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	envelope := kafka.NewEnvelope(message.ID, message.Message, message.CreatedAt)
+	payload, err := envelope.Marshal()
 	if err != nil {
-		logrus.Errorf("Failed to produce message to Kafka: %v", err)
-		return uuid.Nil, serviceerrs.ErrCannotProduceMessage
+		logrus.Errorf("Failed to encode message envelope: %v", err)
+		return entity.Message{}, entity.OutboxEvent{}, serviceerrs.ErrCannotCreateMessage
 	}
 
-	logrus.Infof("Message created with ID: %s", id)
-	return id, nil
+	event := entity.OutboxEvent{
+		MessageID:    message.ID,
+		Key:          envelope.Key(),
+		Payload:      payload,
+		TraceContext: tracing.Inject(ctx),
+	}
+	return message, event, nil
 }
 
 func (s *MessageService) GetMessageById(ctx context.Context, messageId uuid.UUID) (entity.Message, error) {
@@ -67,8 +137,62 @@ func (s *MessageService) GetMessageById(ctx context.Context, messageId uuid.UUID
 	return message, nil
 }
 
-func (s *MessageService) GetMessages(ctx context.Context) ([]entity.Message, error) {
-	return s.messageRepo.GetMessages(ctx)
+func (s *MessageService) GetMessages(ctx context.Context, input GetMessagesInput) (MessagesPage, error) {
+	filter := entity.MessageFilter{
+		Status:        input.Status,
+		Processed:     input.Processed,
+		CreatedFrom:   input.CreatedFrom,
+		CreatedTo:     input.CreatedTo,
+		ProcessedFrom: input.ProcessedFrom,
+		ProcessedTo:   input.ProcessedTo,
+		Query:         input.Query,
+		Order:         input.Order,
+		Limit:         input.Limit,
+	}
+
+	if filter.Status != "" && !filter.Status.Valid() {
+		return MessagesPage{}, fmt.Errorf("%w: unknown status %q", serviceerrs.ErrInvalidFilter, input.Status)
+	}
+
+	switch filter.Order {
+	case "":
+		filter.Order = entity.SortDesc
+	case entity.SortAsc, entity.SortDesc:
+	default:
+		return MessagesPage{}, fmt.Errorf("%w: unknown order %q", serviceerrs.ErrInvalidFilter, input.Order)
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = DefaultPageSize
+	}
+	if filter.Limit < 0 || filter.Limit > MaxPageSize {
+		return MessagesPage{}, fmt.Errorf("%w: limit must be between 1 and %d", serviceerrs.ErrInvalidFilter, MaxPageSize)
+	}
+
+	if input.Cursor != "" {
+		after, err := decodeCursor(input.Cursor, filter.Order)
+		if err != nil {
+			return MessagesPage{}, err
+		}
+		filter.After = after
+	}
+
+	// One extra row tells whether there is a next page.
+	limit := filter.Limit
+	filter.Limit++
+
+	messages, err := s.messageRepo.GetMessages(ctx, filter)
+	if err != nil {
+		logrus.Errorf("Failed to get messages: %v", err)
+		return MessagesPage{}, serviceerrs.ErrCannotGetMessage
+	}
+
+	page := MessagesPage{Messages: messages}
+	if len(messages) > limit {
+		page.Messages = messages[:limit]
+		page.NextCursor = encodeCursor(page.Messages[limit-1], filter.Order)
+	}
+	return page, nil
 }
 
 func (s *MessageService) GetMessageByContent(ctx context.Context, content string) (entity.Message, error) {
@@ -82,30 +206,90 @@ func (s *MessageService) GetMessageByContent(ctx context.Context, content string
 	return message, nil
 }
 
-func (s *MessageService) MarkMessageAsProcessed(ctx context.Context, messageId uuid.UUID) error {
-	return s.messageRepo.MarkMessageAsProcessed(ctx, messageId)
+// MarkMessageAsProcessed settles a message by hand. It fails with
+// ErrMessageAlreadyProcessed when that already happened and with
+// ErrInvalidTransition when the message reached another final status. A
+// non-zero version must match the current one, otherwise ErrVersionMismatch is
+// returned.
+// MarkMessageAsProcessed returns the version of the processed message.
+func (s *MessageService) MarkMessageAsProcessed(ctx context.Context, messageId uuid.UUID, version int) (int, error) {
+	from, err := transitionMessage(ctx, s.messageRepo, messageId, version, entity.StatusProcessed, "")
+	if errors.Is(err, serviceerrs.ErrInvalidTransition) && from.Status == entity.StatusProcessed {
+		return 0, serviceerrs.ErrMessageAlreadyProcessed
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	metrics.MessagesProcessed.Inc()
+	// Every transition bumps the version by one.
+	return from.Version + 1, nil
+}
+
+func (s *MessageService) GetMessageHistory(ctx context.Context, messageId uuid.UUID) ([]entity.MessageTransition, error) {
+	if _, err := s.GetMessageById(ctx, messageId); err != nil {
+		return nil, err
+	}
+
+	transitions, err := s.messageRepo.GetMessageTransitions(ctx, messageId)
+	if err != nil {
+		logrus.Errorf("Failed to get message history: %v", err)
+		return nil, serviceerrs.ErrCannotGetMessage
+	}
+	return transitions, nil
 }
 
 func (s *MessageService) GetProcessedMessagesStats(ctx context.Context) (int, error) {
 	return s.messageRepo.GetProcessedMessagesStats(ctx)
 }
 
-func (s *MessageService) listenForProcessedMessages() {
-	ctx := context.Background()
-	s.kafkaConsumer.Consume(ctx, s.handleMessage)
-}
+func (s *MessageService) GetStats(ctx context.Context, input GetStatsInput) (entity.MessageStats, error) {
+	stats := entity.MessageStats{
+		To:     time.Now().UTC(),
+		Bucket: input.Bucket,
+	}
+	if input.To != nil {
+		stats.To = input.To.UTC()
+	}
+	stats.From = stats.To.Add(-DefaultStatsRange)
+	if input.From != nil {
+		stats.From = input.From.UTC()
+	}
+	if stats.Bucket == "" {
+		stats.Bucket = entity.StatsBucketHour
+	}
 
-func (s *MessageService) handleMessage(ctx context.Context, messageContent string) {
-	message, err := s.GetMessageByContent(ctx, messageContent)
-	if err != nil {
-		logrus.Errorf("Failed to get message by content: %v", err)
-		return
+	if stats.Bucket.Duration() == 0 {
+		return entity.MessageStats{}, fmt.Errorf("%w: unknown bucket %q", serviceerrs.ErrInvalidFilter, stats.Bucket)
+	}
+	if !stats.From.Before(stats.To) {
+		return entity.MessageStats{}, fmt.Errorf("%w: from must be before to", serviceerrs.ErrInvalidFilter)
+	}
+	if stats.To.Sub(stats.From)/stats.Bucket.Duration() > MaxStatsBuckets {
+		return entity.MessageStats{}, fmt.Errorf("%w: range spans more than %d buckets", serviceerrs.ErrInvalidFilter, MaxStatsBuckets)
 	}
 
-	err = s.MarkMessageAsProcessed(ctx, message.ID)
-	if err != nil {
-		logrus.Errorf("Failed to mark message %s as processed: %v", message.ID, err)
-	} else {
-		logrus.Infof("Message %s marked as processed", message.ID)
+	var err error
+	if stats.Counts, err = s.messageRepo.GetMessageCounts(ctx); err != nil {
+		logrus.Errorf("Failed to count messages: %v", err)
+		return entity.MessageStats{}, serviceerrs.ErrCannotGetStats
+	}
+	if stats.Latency, err = s.messageRepo.GetProcessingLatency(ctx, stats.From, stats.To); err != nil {
+		logrus.Errorf("Failed to get processing latency: %v", err)
+		return entity.MessageStats{}, serviceerrs.ErrCannotGetStats
+	}
+	if stats.Throughput, err = s.messageRepo.GetThroughput(ctx, stats.From, stats.To, stats.Bucket); err != nil {
+		logrus.Errorf("Failed to get throughput: %v", err)
+		return entity.MessageStats{}, serviceerrs.ErrCannotGetStats
+	}
+	return stats, nil
+}
+
+// GetConsumerStats returns zero stats on instances that do not consume, see
+// entity.RoleAPI.
+func (s *MessageService) GetConsumerStats() kafka.ConsumerStats {
+	if s.kafkaConsumer == nil {
+		return kafka.ConsumerStats{}
 	}
+	return s.kafkaConsumer.Stats()
 }
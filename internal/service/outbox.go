@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/repo"
+	"messagio_testsuite/internal/tracing"
+	"messagio_testsuite/pkg/kafka"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultOutboxPollInterval = time.Second
+	defaultOutboxBatchSize    = 100
+	defaultOutboxMinBackoff   = time.Second
+	defaultOutboxMaxBackoff   = time.Minute
+	defaultOutboxLease        = 30 * time.Second
+
+	outboxPurgeInterval = time.Minute
+)
+
+type OutboxRelayConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	Lease        time.Duration
+	Retention    time.Duration
+}
+
+// OutboxRelay publishes events written to the outbox together with their
+// messages. All state lives in the outbox table, so pending events are picked
+// up again after a restart.
+type OutboxRelay struct {
+	outboxRepo    repo.Outbox
+	kafkaProducer kafka.Producer
+	cfg           OutboxRelayConfig
+	lastPurge     time.Time
+}
+
+func NewOutboxRelay(outboxRepo repo.Outbox, kafkaProducer kafka.Producer, cfg OutboxRelayConfig) *OutboxRelay {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultOutboxPollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultOutboxBatchSize
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = defaultOutboxMinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = defaultOutboxMaxBackoff
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = defaultOutboxLease
+	}
+
+	return &OutboxRelay{
+		outboxRepo:    outboxRepo,
+		kafkaProducer: kafkaProducer,
+		cfg:           cfg,
+	}
+}
+
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		sent, err := r.relayBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			logrus.Errorf("Outbox relay error: %v", err)
+		}
+
+		// A full batch means there is likely more waiting, skip the wait.
+		if err == nil && sent == r.cfg.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			logrus.Info("Outbox relay stopped")
+			return
+		case <-ticker.C:
+			r.purge(ctx)
+		}
+	}
+}
+
+func (r *OutboxRelay) relayBatch(ctx context.Context) (int, error) {
+	events, err := r.outboxRepo.ClaimPendingEvents(ctx, r.cfg.BatchSize, r.cfg.Lease)
+	if err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	// Every event is published in the trace of the request that created its
+	// message.
+	records := make([]kafka.Record, len(events))
+	spans := make([]trace.Span, len(events))
+	for i, event := range events {
+		eventCtx, span := tracing.Start(tracing.Extract(ctx, event.TraceContext), "OutboxRelay.publish",
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(attribute.String("message.id", event.MessageID.String()), attribute.Int("outbox.attempts", event.Attempts)),
+		)
+		spans[i] = span
+		records[i] = kafka.Record{
+			Key:     event.Key,
+			Value:   event.Payload,
+			Headers: tracing.Inject(eventCtx),
+		}
+	}
+
+	publishErr := r.kafkaProducer.ProduceRecords(ctx, records...)
+
+	var writeErrs kafka.WriteErrors
+	partial := errors.As(publishErr, &writeErrs) && len(writeErrs) == len(events)
+
+	sent := make([]int64, 0, len(events))
+	for i, event := range events {
+		eventErr := publishErr
+		if partial {
+			eventErr = writeErrs[i]
+		}
+		tracing.End(spans[i], eventErr)
+
+		if eventErr == nil {
+			sent = append(sent, event.ID)
+			continue
+		}
+
+		if err := r.outboxRepo.MarkEventFailed(ctx, event.ID, eventErr.Error(), r.backoff(event)); err != nil {
+			logrus.Errorf("Failed to reschedule outbox event %d: %v", event.ID, err)
+		}
+	}
+
+	if len(sent) > 0 {
+		if err := r.outboxRepo.MarkEventsSent(ctx, sent); err != nil {
+			return len(sent), err
+		}
+	}
+
+	return len(sent), publishErr
+}
+
+func (r *OutboxRelay) backoff(event entity.OutboxEvent) time.Duration {
+	backoff := r.cfg.MinBackoff
+	for i := 1; i < event.Attempts && backoff < r.cfg.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, r.cfg.MaxBackoff)
+}
+
+func (r *OutboxRelay) purge(ctx context.Context) {
+	if r.cfg.Retention <= 0 || time.Since(r.lastPurge) < outboxPurgeInterval {
+		return
+	}
+	r.lastPurge = time.Now()
+
+	deleted, err := r.outboxRepo.DeleteSentEvents(ctx, r.cfg.Retention)
+	if err != nil {
+		logrus.Errorf("Failed to purge sent outbox events: %v", err)
+		return
+	}
+	if deleted > 0 {
+		logrus.Debugf("Purged %d sent outbox event(s)", deleted)
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/metrics"
+	"messagio_testsuite/internal/repo"
+	repoerrs "messagio_testsuite/internal/repo/repo_errors"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"messagio_testsuite/internal/tracing"
+	"messagio_testsuite/pkg/kafka"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MessageProcessor consumes published messages and marks them as processed.
+type MessageProcessor struct {
+	messageRepo   repo.Message
+	kafkaConsumer kafka.Consumer
+}
+
+func NewMessageProcessor(messageRepo repo.Message, kafkaConsumer kafka.Consumer) *MessageProcessor {
+	return &MessageProcessor{
+		messageRepo:   messageRepo,
+		kafkaConsumer: kafkaConsumer,
+	}
+}
+
+// Run consumes until ctx is done and the records in flight are drained.
+func (p *MessageProcessor) Run(ctx context.Context) error {
+	return p.kafkaConsumer.Consume(ctx, p.handleMessage)
+}
+
+func (p *MessageProcessor) handleMessage(ctx context.Context, envelope kafka.Envelope) (err error) {
+	ctx, span := tracing.Start(ctx, "MessageProcessor.handleMessage",
+		trace.WithAttributes(attribute.String("message.id", envelope.ID.String())))
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
+	err = p.processMessage(ctx, envelope)
+	metrics.ProcessingDuration.Observe(time.Since(start).Seconds())
+	if err == nil {
+		return nil
+	}
+
+	// The consumer gives up on the record after this attempt. Record where
+	// the message ended up only once it got there, a record that could not
+	// be dead-lettered yet is redelivered and must not be skipped then.
+	if attempt, ok := kafka.AttemptFromContext(ctx); ok && attempt.Final && envelope.ID != uuid.Nil {
+		status := entity.StatusFailed
+		if attempt.DeadLetter {
+			status = entity.StatusDeadLettered
+		}
+		reason := err.Error()
+		kafka.AfterGiveUp(ctx, func(ctx context.Context) {
+			p.markGivenUp(ctx, envelope.ID, status, reason)
+		})
+	}
+	return err
+}
+
+func (p *MessageProcessor) markGivenUp(ctx context.Context, id uuid.UUID, status entity.MessageStatus, reason string) {
+	if _, err := transitionMessage(ctx, p.messageRepo, id, 0, status, reason); err != nil {
+		logrus.Errorf("Failed to mark message %s as %s: %v", id, status, err)
+		return
+	}
+	metrics.MessagesFailed.WithLabelValues(string(status)).Inc()
+}
+
+func (p *MessageProcessor) processMessage(ctx context.Context, envelope kafka.Envelope) error {
+	id := envelope.ID
+	if id == uuid.Nil {
+		// Legacy records carry only the content, fall back to the oldest
+		// unprocessed message with the same text.
+		message, err := p.messageRepo.GetMessageByContent(ctx, envelope.Content)
+		if err != nil {
+			if errors.Is(err, repoerrs.ErrNotFound) {
+				err = serviceerrs.ErrMessageNotFound
+			}
+			return fmt.Errorf("get message by content: %w", err)
+		}
+		id = message.ID
+	}
+
+	from, err := transitionMessage(ctx, p.messageRepo, id, 0, entity.StatusProcessing, "")
+	if errors.Is(err, serviceerrs.ErrInvalidTransition) && from.Status.Terminal() {
+		// Redelivered after it was settled, e.g. processed before the offset
+		// was committed.
+		logrus.Infof("Message %s is already %s, skipping", id, from.Status)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("mark message %s as processing: %w", id, err)
+	}
+
+	if _, err := transitionMessage(ctx, p.messageRepo, id, 0, entity.StatusProcessed, ""); err != nil {
+		return fmt.Errorf("mark message %s as processed: %w", id, err)
+	}
+
+	logrus.Infof("Message %s marked as processed", id)
+	metrics.MessagesProcessed.Inc()
+	return nil
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/repo"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"messagio_testsuite/pkg/ratelimit"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const quotaPurgeInterval = time.Hour
+
+type RateLimitConfig struct {
+	// Rate is how many requests per second a client may send and Burst how
+	// many it may send at once. A zero Rate turns rate limiting off.
+	Rate  float64
+	Burst int
+	// DailyQuota caps the messages a client creates per UTC day, zero turns
+	// it off.
+	DailyQuota int64
+}
+
+type RateLimitService struct {
+	limiter   *ratelimit.Limiter
+	quotaRepo repo.Quota
+	cfg       RateLimitConfig
+	lastPurge atomic.Int64
+	now       func() time.Time
+}
+
+func NewRateLimitService(quotaRepo repo.Quota, cfg RateLimitConfig) *RateLimitService {
+	s := &RateLimitService{
+		quotaRepo: quotaRepo,
+		cfg:       cfg,
+		now:       time.Now,
+	}
+	if cfg.Rate > 0 {
+		s.limiter = ratelimit.New(cfg.Rate, cfg.Burst)
+	}
+	return s
+}
+
+// Allow takes a token from the bucket of client. With rate limiting off every
+// call is allowed and the result has a zero Limit.
+func (s *RateLimitService) Allow(client string) ratelimit.Result {
+	if s.limiter == nil {
+		return ratelimit.Result{Allowed: true}
+	}
+	return s.limiter.Allow(client)
+}
+
+// ConsumeQuota counts n messages against the daily quota of client, all of
+// them or none, and returns ErrQuotaExceeded with the usage when they do not
+// fit. It returns nil usage when quotas are off. Quotas are not enforced while
+// the store is unreachable, the rate limit still applies then.
+func (s *RateLimitService) ConsumeQuota(ctx context.Context, client string, n int) (*entity.QuotaUsage, error) {
+	if s.cfg.DailyQuota <= 0 {
+		return nil, nil
+	}
+	day := s.today()
+	s.purge(ctx, day)
+
+	used, ok, err := s.quotaRepo.ConsumeQuota(ctx, client, day, int64(n), s.cfg.DailyQuota)
+	if err != nil {
+		logrus.Errorf("Failed to count quota usage: %v", err)
+		return nil, nil
+	}
+
+	usage := s.usage(day, used)
+	if !ok {
+		return usage, serviceerrs.ErrQuotaExceeded
+	}
+	return usage, nil
+}
+
+// ReleaseQuota gives back n of the messages ConsumeQuota counted, for those
+// that were not created after all.
+func (s *RateLimitService) ReleaseQuota(ctx context.Context, client string, n int) {
+	if s.cfg.DailyQuota <= 0 || n <= 0 {
+		return
+	}
+
+	if err := s.quotaRepo.ReleaseQuota(ctx, client, s.today(), int64(n)); err != nil {
+		logrus.Errorf("Failed to release quota usage: %v", err)
+	}
+}
+
+// GetQuotaUsage returns nil when quotas are off.
+func (s *RateLimitService) GetQuotaUsage(ctx context.Context, client string) (*entity.QuotaUsage, error) {
+	if s.cfg.DailyQuota <= 0 {
+		return nil, nil
+	}
+	day := s.today()
+
+	used, err := s.quotaRepo.GetQuotaUsage(ctx, client, day)
+	if err != nil {
+		logrus.Errorf("Failed to get quota usage: %v", err)
+		return nil, serviceerrs.ErrCannotGetStats
+	}
+	return s.usage(day, used), nil
+}
+
+func (s *RateLimitService) today() time.Time {
+	return s.now().UTC().Truncate(24 * time.Hour)
+}
+
+func (s *RateLimitService) usage(day time.Time, used int64) *entity.QuotaUsage {
+	return &entity.QuotaUsage{
+		Limit:     s.cfg.DailyQuota,
+		Used:      used,
+		Remaining: max(s.cfg.DailyQuota-used, 0),
+		ResetsAt:  day.AddDate(0, 0, 1),
+	}
+}
+
+// purge drops the usage of past days.
+func (s *RateLimitService) purge(ctx context.Context, day time.Time) {
+	last := s.lastPurge.Load()
+	now := s.now().UnixNano()
+	if time.Duration(now-last) < quotaPurgeInterval || !s.lastPurge.CompareAndSwap(last, now) {
+		return
+	}
+
+	deleted, err := s.quotaRepo.DeleteQuotaUsage(ctx, day)
+	if err != nil {
+		logrus.Errorf("Failed to purge quota usage: %v", err)
+		return
+	}
+	if deleted > 0 {
+		logrus.Debugf("Purged quota usage of %d client day(s)", deleted)
+	}
+}
@@ -0,0 +1,122 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryQuota keeps quota usage in memory and fails every call once err is
+// set.
+type memoryQuota struct {
+	mu   sync.Mutex
+	used map[string]int64
+	err  error
+}
+
+func (m *memoryQuota) ConsumeQuota(_ context.Context, client string, day time.Time, n, limit int64) (int64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return 0, false, m.err
+	}
+	key := client + day.Format(time.DateOnly)
+	if m.used[key]+n > limit {
+		return m.used[key], false, nil
+	}
+	m.used[key] += n
+	return m.used[key], true, nil
+}
+
+func (m *memoryQuota) ReleaseQuota(_ context.Context, client string, day time.Time, n int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+	key := client + day.Format(time.DateOnly)
+	m.used[key] = max(m.used[key]-n, 0)
+	return nil
+}
+
+func (m *memoryQuota) GetQuotaUsage(_ context.Context, client string, day time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.used[client+day.Format(time.DateOnly)], m.err
+}
+
+func (m *memoryQuota) DeleteQuotaUsage(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestRateLimitService_ConsumeQuota(t *testing.T) {
+	repo := &memoryQuota{used: make(map[string]int64)}
+	s := service.NewRateLimitService(repo, service.RateLimitConfig{DailyQuota: 2})
+	ctx := context.Background()
+
+	for i := 1; i <= 2; i++ {
+		usage, err := s.ConsumeQuota(ctx, "principal:a", 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(i), usage.Used)
+		assert.Equal(t, int64(2-i), usage.Remaining)
+	}
+
+	usage, err := s.ConsumeQuota(ctx, "principal:a", 1)
+	assert.ErrorIs(t, err, serviceerrs.ErrQuotaExceeded)
+	require.NotNil(t, usage)
+	assert.True(t, usage.ResetsAt.After(time.Now()))
+
+	_, err = s.ConsumeQuota(ctx, "principal:b", 1)
+	assert.NoError(t, err, "every client has its own quota")
+
+	repo.err = errors.New("connection refused")
+	usage, err = s.ConsumeQuota(ctx, "principal:a", 1)
+	assert.NoError(t, err, "quotas fail open")
+	assert.Nil(t, usage)
+	_, err = s.GetQuotaUsage(ctx, "principal:a")
+	assert.ErrorIs(t, err, serviceerrs.ErrCannotGetStats)
+}
+
+func TestRateLimitService_ConsumeQuotaForManyMessages(t *testing.T) {
+	s := service.NewRateLimitService(&memoryQuota{used: make(map[string]int64)}, service.RateLimitConfig{DailyQuota: 10})
+	ctx := context.Background()
+
+	usage, err := s.ConsumeQuota(ctx, "principal:a", 8)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), usage.Remaining)
+
+	usage, err = s.ConsumeQuota(ctx, "principal:a", 3)
+	assert.ErrorIs(t, err, serviceerrs.ErrQuotaExceeded, "a batch fits whole or not at all")
+	assert.Equal(t, int64(8), usage.Used)
+
+	s.ReleaseQuota(ctx, "principal:a", 3)
+	usage, err = s.ConsumeQuota(ctx, "principal:a", 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), usage.Used)
+}
+
+func TestRateLimitService_Disabled(t *testing.T) {
+	s := service.NewRateLimitService(&memoryQuota{}, service.RateLimitConfig{})
+	ctx := context.Background()
+
+	result := s.Allow("ip:192.0.2.1")
+	assert.True(t, result.Allowed)
+	assert.Zero(t, result.Limit)
+
+	usage, err := s.ConsumeQuota(ctx, "ip:192.0.2.1", 1)
+	assert.NoError(t, err)
+	assert.Nil(t, usage)
+	usage, err = s.GetQuotaUsage(ctx, "ip:192.0.2.1")
+	assert.NoError(t, err)
+	assert.Nil(t, usage)
+}
@@ -5,30 +5,172 @@ import (
 	"messagio_testsuite/internal/entity"
 	"messagio_testsuite/internal/repo"
 	"messagio_testsuite/pkg/kafka"
+	"messagio_testsuite/pkg/ratelimit"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type Message interface {
 	CreateMessage(ctx context.Context, content string) (uuid.UUID, error)
+	CreateMessages(ctx context.Context, contents []string) ([]CreateMessageResult, error)
 	GetMessageById(ctx context.Context, messageId uuid.UUID) (entity.Message, error)
-	GetMessages(ctx context.Context) ([]entity.Message, error)
-	MarkMessageAsProcessed(ctx context.Context, messageId uuid.UUID) error
+	GetMessages(ctx context.Context, input GetMessagesInput) (MessagesPage, error)
+	MarkMessageAsProcessed(ctx context.Context, messageId uuid.UUID, version int) (int, error)
+	GetMessageHistory(ctx context.Context, messageId uuid.UUID) ([]entity.MessageTransition, error)
 	GetProcessedMessagesStats(ctx context.Context) (int, error)
+	GetStats(ctx context.Context, input GetStatsInput) (entity.MessageStats, error)
+	GetConsumerStats() kafka.ConsumerStats
 }
 
+// MaxBatchSize caps the number of messages created by one CreateMessages call.
+const MaxBatchSize = 1000
+
+// CreateMessageResult reports the outcome for the message at Index of a batch,
+// either its ID or the reason it was not created.
+type CreateMessageResult struct {
+	Index int        `json:"index"`
+	ID    *uuid.UUID `json:"id,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+type GetMessagesInput struct {
+	Status        entity.MessageStatus
+	Processed     *bool
+	CreatedFrom   *time.Time
+	CreatedTo     *time.Time
+	ProcessedFrom *time.Time
+	ProcessedTo   *time.Time
+	Query         string
+
+	// Order defaults to newest first, Limit to DefaultPageSize.
+	Order  entity.SortOrder
+	Limit  int
+	Cursor string
+}
+
+type MessagesPage struct {
+	Messages   []entity.Message `json:"messages"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+const (
+	DefaultStatsRange = 24 * time.Hour
+	MaxStatsBuckets   = 1500
+)
+
+type GetStatsInput struct {
+	// From defaults to DefaultStatsRange before To, To to now.
+	From *time.Time
+	To   *time.Time
+	// Bucket defaults to entity.StatsBucketHour.
+	Bucket entity.StatsBucket
+}
+
+type Idempotency interface {
+	Begin(ctx context.Context, client, key, fingerprint string) (*entity.IdempotencyKey, error)
+	Complete(ctx context.Context, client, key string, statusCode int, response []byte) error
+	Release(ctx context.Context, client, key string)
+}
+
+type Events interface {
+	Subscribe(ctx context.Context, filter EventFilter) ([]entity.MessageEvent, <-chan entity.MessageEvent)
+	Close()
+}
+
+type EventFilter struct {
+	// After replays the events logged after the one with this ID, 0 replays
+	// nothing.
+	After     uint64
+	MessageID *uuid.UUID
+	// MessageIDs keeps the events of the messages in the set, which may
+	// change while subscribed.
+	MessageIDs *MessageIDSet
+}
+
+// Auth resolves the caller from its credentials. Both methods return
+// ErrUnauthenticated for credentials that are unknown, revoked or expired.
+type Auth interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (entity.Principal, error)
+	AuthenticateToken(ctx context.Context, token string) (entity.Principal, error)
+}
+
+type APIKeys interface {
+	CreateAPIKey(ctx context.Context, input CreateAPIKeyInput) (entity.APIKey, string, error)
+	GetAPIKeys(ctx context.Context) ([]entity.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id uuid.UUID) error
+}
+
+type RateLimit interface {
+	Allow(client string) ratelimit.Result
+	ConsumeQuota(ctx context.Context, client string, n int) (*entity.QuotaUsage, error)
+	ReleaseQuota(ctx context.Context, client string, n int)
+	GetQuotaUsage(ctx context.Context, client string) (*entity.QuotaUsage, error)
+}
+
+// Health builds the reports of the liveness, readiness and startup probes.
+type Health interface {
+	Live(ctx context.Context) entity.HealthReport
+	Ready(ctx context.Context) entity.HealthReport
+	Started(ctx context.Context) entity.HealthReport
+}
+
+// Services holds what the role of the instance needs, the rest is nil. API
+// instances have neither a Processor nor an Outbox relay, workers none of the
+// services behind the APIs. The Events log and the Processor and Outbox relay
+// do their work in Run.
 type Services struct {
-	Message Message
+	Message     Message
+	Idempotency Idempotency
+	Events      *EventLog
+	Auth        *AuthService
+	RateLimit   RateLimit
+	Processor   *MessageProcessor
+	Outbox      *OutboxRelay
+	Health      Health
 }
 
 type ServicesDependencies struct {
+	// Role defaults to entity.RoleAll. API instances need no Kafka producer
+	// or consumer.
+	Role          entity.Role
 	Repos         *repo.Repositories
-	KafkaProducer *kafka.KafkaProducer
-	KafkaConsumer *kafka.KafkaConsumer
+	KafkaProducer kafka.Producer
+	KafkaConsumer kafka.Consumer
+	Outbox        OutboxRelayConfig
+	Idempotency   IdempotencyConfig
+	Events        EventsConfig
+	Auth          AuthConfig
+	RateLimit     RateLimitConfig
+	Health        HealthConfig
 }
 
 func NewServices(deps ServicesDependencies) *Services {
-	return &Services{
-		Message: NewMessageService(deps.Repos.Message, deps.KafkaProducer, deps.KafkaConsumer),
+	role := deps.Role
+	if role == "" {
+		role = entity.RoleAll
+	}
+
+	services := &Services{
+		Health: NewHealthService(role, deps.Repos.Schema, deps.Repos.Outbox, deps.KafkaProducer, deps.KafkaConsumer, deps.Health),
 	}
+
+	if role.ServesAPI() {
+		services.Message = NewMessageService(deps.Repos.Message, deps.KafkaConsumer)
+		services.Events = NewEventLog(deps.Repos.Message, deps.Events)
+		services.Idempotency = NewIdempotencyService(deps.Repos.Idempotency, deps.Idempotency)
+		services.Auth = NewAuthService(deps.Repos.APIKey, deps.Auth)
+		services.RateLimit = NewRateLimitService(deps.Repos.Quota, deps.RateLimit)
+	}
+	if role.ProcessesMessages() {
+		services.Processor = NewMessageProcessor(deps.Repos.Message, deps.KafkaConsumer)
+		services.Outbox = NewOutboxRelay(deps.Repos.Outbox, deps.KafkaProducer, deps.Outbox)
+	}
+
+	return services
 }
@@ -3,8 +3,31 @@ package serviceerrs
 import "fmt"
 
 var (
-	ErrCannotCreateMessage  = fmt.Errorf("cannot create message")
-	ErrMessageNotFound      = fmt.Errorf("message not found")
-	ErrCannotGetMessage     = fmt.Errorf("cannot get message")
-	ErrCannotProduceMessage = fmt.Errorf("cannot produce message")
+	ErrCannotCreateMessage     = fmt.Errorf("cannot create message")
+	ErrMessageNotFound         = fmt.Errorf("message not found")
+	ErrCannotGetMessage        = fmt.Errorf("cannot get message")
+	ErrCannotProduceMessage    = fmt.Errorf("cannot produce message")
+	ErrInvalidCursor           = fmt.Errorf("invalid cursor")
+	ErrInvalidFilter           = fmt.Errorf("invalid filter")
+	ErrCannotGetStats          = fmt.Errorf("cannot get stats")
+	ErrInvalidTransition       = fmt.Errorf("invalid status transition")
+	ErrMessageAlreadyProcessed = fmt.Errorf("message already processed")
+	ErrVersionMismatch         = fmt.Errorf("message version does not match")
+	ErrEmptyMessage            = fmt.Errorf("message is required")
+	ErrBatchTooLarge           = fmt.Errorf("batch too large")
+
+	ErrIdempotencyKeyReused   = fmt.Errorf("idempotency key was used for a different request")
+	ErrIdempotencyKeyInFlight = fmt.Errorf("a request with this idempotency key is in progress")
+	ErrIdempotencyUnavailable = fmt.Errorf("idempotency store unavailable")
+
+	ErrUnauthenticated     = fmt.Errorf("missing or invalid credentials")
+	ErrForbidden           = fmt.Errorf("insufficient scope")
+	ErrCannotAuthenticate  = fmt.Errorf("cannot authenticate")
+	ErrInvalidScope        = fmt.Errorf("invalid scope")
+	ErrInvalidAPIKeyName   = fmt.Errorf("invalid api key name")
+	ErrAPIKeyNotFound      = fmt.Errorf("api key not found")
+	ErrCannotManageAPIKeys = fmt.Errorf("cannot manage api keys")
+
+	ErrRateLimited   = fmt.Errorf("rate limit exceeded")
+	ErrQuotaExceeded = fmt.Errorf("daily quota exceeded")
 )
@@ -0,0 +1,69 @@
+package serviceerrs
+
+import (
+	"errors"
+	repoerrs "messagio_testsuite/internal/repo/repo_errors"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Mapping is how a sentinel error is reported to clients, over HTTP with
+// Status and the Problem type, over gRPC with Code.
+type Mapping struct {
+	Err     error
+	Status  int
+	Problem string
+	Code    codes.Code
+}
+
+// Mappings is shared by the HTTP and gRPC routes, so an error is reported the
+// same way by both.
+var Mappings = []Mapping{
+	{ErrMessageNotFound, http.StatusNotFound, "message-not-found", codes.NotFound},
+	{ErrInvalidCursor, http.StatusBadRequest, "invalid-cursor", codes.InvalidArgument},
+	{ErrInvalidFilter, http.StatusBadRequest, "invalid-filter", codes.InvalidArgument},
+	{ErrEmptyMessage, http.StatusBadRequest, "empty-message", codes.InvalidArgument},
+	{ErrBatchTooLarge, http.StatusRequestEntityTooLarge, "batch-too-large", codes.InvalidArgument},
+	{ErrMessageAlreadyProcessed, http.StatusConflict, "message-already-processed", codes.FailedPrecondition},
+	{ErrInvalidTransition, http.StatusConflict, "invalid-transition", codes.FailedPrecondition},
+	{ErrVersionMismatch, http.StatusPreconditionFailed, "version-mismatch", codes.Aborted},
+	{ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, "idempotency-key-reused", codes.FailedPrecondition},
+	{ErrIdempotencyKeyInFlight, http.StatusConflict, "idempotency-key-in-flight", codes.Aborted},
+	{ErrIdempotencyUnavailable, http.StatusServiceUnavailable, "idempotency-unavailable", codes.Unavailable},
+	{ErrCannotCreateMessage, http.StatusInternalServerError, "cannot-create-message", codes.Internal},
+	{ErrCannotProduceMessage, http.StatusInternalServerError, "cannot-produce-message", codes.Internal},
+	{ErrCannotGetMessage, http.StatusInternalServerError, "cannot-get-message", codes.Internal},
+	{ErrCannotGetStats, http.StatusInternalServerError, "cannot-get-stats", codes.Internal},
+	{ErrUnauthenticated, http.StatusUnauthorized, "unauthenticated", codes.Unauthenticated},
+	{ErrForbidden, http.StatusForbidden, "insufficient-scope", codes.PermissionDenied},
+	{ErrCannotAuthenticate, http.StatusServiceUnavailable, "cannot-authenticate", codes.Unavailable},
+	{ErrInvalidScope, http.StatusBadRequest, "invalid-scope", codes.InvalidArgument},
+	{ErrInvalidAPIKeyName, http.StatusBadRequest, "invalid-api-key-name", codes.InvalidArgument},
+	{ErrAPIKeyNotFound, http.StatusNotFound, "api-key-not-found", codes.NotFound},
+	{ErrCannotManageAPIKeys, http.StatusInternalServerError, "cannot-manage-api-keys", codes.Internal},
+	{ErrRateLimited, http.StatusTooManyRequests, "rate-limited", codes.ResourceExhausted},
+	{ErrQuotaExceeded, http.StatusTooManyRequests, "quota-exceeded", codes.ResourceExhausted},
+	{repoerrs.ErrNotFound, http.StatusNotFound, "not-found", codes.NotFound},
+	{repoerrs.ErrAlreadyExists, http.StatusConflict, "already-exists", codes.AlreadyExists},
+	{repoerrs.ErrConflict, http.StatusConflict, "conflict", codes.Aborted},
+}
+
+// Lookup returns the mapping of the first sentinel in Mappings that err wraps.
+func Lookup(err error) (Mapping, bool) {
+	for _, m := range Mappings {
+		if errors.Is(err, m.Err) {
+			return m, true
+		}
+	}
+	return Mapping{}, false
+}
+
+// Detail is the text err is reported with. Client errors get the full error
+// text, server errors only the sentinel's, so wrapped causes stay in the logs.
+func (m Mapping) Detail(err error) string {
+	if m.Status >= http.StatusInternalServerError {
+		return m.Err.Error()
+	}
+	return err.Error()
+}
@@ -0,0 +1,936 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"messagio_testsuite/internal/entity"
+	"messagio_testsuite/internal/metrics"
+	"messagio_testsuite/internal/repo"
+	repoerrs "messagio_testsuite/internal/repo/repo_errors"
+	"messagio_testsuite/internal/service"
+	serviceerrs "messagio_testsuite/internal/service/service_errors"
+	"messagio_testsuite/pkg/kafka"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryRepo implements the repositories in memory so the services can run
+// end to end against a kafka.MemoryBroker.
+type memoryRepo struct {
+	mu          sync.Mutex
+	messages    map[uuid.UUID]entity.Message
+	transitions []entity.MessageTransition
+	events      []memoryEvent
+}
+
+type memoryEvent struct {
+	entity.OutboxEvent
+	sent bool
+}
+
+func newMemoryRepo() *memoryRepo {
+	return &memoryRepo{
+		messages: make(map[uuid.UUID]entity.Message),
+	}
+}
+
+func (r *memoryRepo) repositories() *repo.Repositories {
+	return &repo.Repositories{Message: r, Outbox: r}
+}
+
+func (r *memoryRepo) CreateMessage(_ context.Context, message entity.Message, event entity.OutboxEvent) (uuid.UUID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	message.Status = entity.StatusReceived
+	message.Version = 1
+	r.messages[message.ID] = message
+	r.transitions = append(r.transitions, entity.MessageTransition{
+		ID:        int64(len(r.transitions) + 1),
+		MessageID: message.ID,
+		To:        entity.StatusReceived,
+		CreatedAt: time.Now(),
+	})
+	event.ID = int64(len(r.events) + 1)
+	event.MessageID = message.ID
+	r.events = append(r.events, memoryEvent{OutboxEvent: event})
+	return message.ID, nil
+}
+
+func (r *memoryRepo) CreateMessages(ctx context.Context, messages []entity.Message, events []entity.OutboxEvent) error {
+	for i := range messages {
+		if _, err := r.CreateMessage(ctx, messages[i], events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepo) GetMessageById(_ context.Context, id uuid.UUID) (entity.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	message, ok := r.messages[id]
+	if !ok {
+		return entity.Message{}, repoerrs.ErrNotFound
+	}
+	return message, nil
+}
+
+func (r *memoryRepo) GetMessages(_ context.Context, filter entity.MessageFilter) ([]entity.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	before := func(a, b entity.Message) bool {
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID.String() < b.ID.String()
+	}
+	if filter.Order == entity.SortDesc {
+		asc := before
+		before = func(a, b entity.Message) bool { return asc(b, a) }
+	}
+
+	messages := make([]entity.Message, 0, len(r.messages))
+	for _, message := range r.messages {
+		if filter.Processed != nil && message.Processed != *filter.Processed {
+			continue
+		}
+		if filter.Query != "" && !strings.Contains(strings.ToLower(message.Message), strings.ToLower(filter.Query)) {
+			continue
+		}
+		if filter.After != nil && !before(entity.Message{ID: filter.After.ID, CreatedAt: filter.After.CreatedAt}, message) {
+			continue
+		}
+		messages = append(messages, message)
+	}
+
+	sort.Slice(messages, func(i, j int) bool { return before(messages[i], messages[j]) })
+	if filter.Limit > 0 && len(messages) > filter.Limit {
+		messages = messages[:filter.Limit]
+	}
+	return messages, nil
+}
+
+func (r *memoryRepo) UpdateMessageStatus(_ context.Context, id uuid.UUID, version int, from, to entity.MessageStatus, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	message, ok := r.messages[id]
+	if !ok {
+		return repoerrs.ErrNotFound
+	}
+	if message.Version != version {
+		return repoerrs.ErrConflict
+	}
+	return r.updateStatus(id, from, to, reason)
+}
+
+// updateStatus must be called with mu held.
+func (r *memoryRepo) updateStatus(id uuid.UUID, from, to entity.MessageStatus, reason string) error {
+	message, ok := r.messages[id]
+	if !ok || message.Status != from {
+		return repoerrs.ErrConflict
+	}
+
+	now := time.Now()
+	message.Status = to
+	message.Version++
+	switch to {
+	case entity.StatusPublished:
+		message.PublishedAt = &now
+	case entity.StatusProcessing:
+		message.ProcessingAt = &now
+	case entity.StatusProcessed:
+		message.Processed = true
+		message.ProcessedAt = &now
+	case entity.StatusFailed:
+		message.FailedAt = &now
+		message.LastError = reason
+	case entity.StatusDeadLettered:
+		message.DeadLetteredAt = &now
+		message.LastError = reason
+	}
+	r.messages[id] = message
+
+	r.transitions = append(r.transitions, entity.MessageTransition{
+		ID:        int64(len(r.transitions) + 1),
+		MessageID: id,
+		From:      from,
+		To:        to,
+		Reason:    reason,
+		CreatedAt: now,
+	})
+	return nil
+}
+
+func (r *memoryRepo) GetMessageTransitions(_ context.Context, id uuid.UUID) ([]entity.MessageTransition, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	transitions := make([]entity.MessageTransition, 0)
+	for _, t := range r.transitions {
+		if t.MessageID == id && t.From != "" {
+			transitions = append(transitions, t)
+		}
+	}
+	return transitions, nil
+}
+
+func (r *memoryRepo) GetTransitionsAfter(_ context.Context, after int64, limit int) ([]entity.MessageTransition, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if after >= int64(len(r.transitions)) {
+		return nil, nil
+	}
+	transitions := r.transitions[after:]
+	if len(transitions) > limit {
+		transitions = transitions[:limit]
+	}
+	return append([]entity.MessageTransition(nil), transitions...), nil
+}
+
+func (r *memoryRepo) GetTransitions(_ context.Context, ids []int64) ([]entity.MessageTransition, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var transitions []entity.MessageTransition
+	for _, id := range ids {
+		if id > 0 && id <= int64(len(r.transitions)) {
+			transitions = append(transitions, r.transitions[id-1])
+		}
+	}
+	return transitions, nil
+}
+
+func (r *memoryRepo) GetLastTransitionID(context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return int64(len(r.transitions)), nil
+}
+
+func (r *memoryRepo) GetProcessedMessagesStats(context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, message := range r.messages {
+		if message.Processed {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryRepo) GetMessageCounts(context.Context) (entity.MessageCounts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var counts entity.MessageCounts
+	for _, message := range r.messages {
+		counts.Total++
+		switch message.Status {
+		case entity.StatusProcessed:
+			counts.Processed++
+		case entity.StatusFailed:
+			counts.Failed++
+		case entity.StatusDeadLettered:
+			counts.DeadLettered++
+		default:
+			counts.Pending++
+		}
+	}
+	return counts, nil
+}
+
+func (r *memoryRepo) GetProcessingLatency(context.Context, time.Time, time.Time) (entity.ProcessingLatency, error) {
+	return entity.ProcessingLatency{}, nil
+}
+
+func (r *memoryRepo) GetThroughput(context.Context, time.Time, time.Time, entity.StatsBucket) ([]entity.ThroughputBucket, error) {
+	return nil, nil
+}
+
+func (r *memoryRepo) GetMessageByContent(_ context.Context, content string) (entity.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, message := range r.messages {
+		if message.Message == content {
+			return message, nil
+		}
+	}
+	return entity.Message{}, repoerrs.ErrNotFound
+}
+
+func (r *memoryRepo) ClaimPendingEvents(_ context.Context, limit int, _ time.Duration) ([]entity.OutboxEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []entity.OutboxEvent
+	for i := range r.events {
+		if !r.events[i].sent && len(events) < limit {
+			r.events[i].Attempts++
+			events = append(events, r.events[i].OutboxEvent)
+		}
+	}
+	return events, nil
+}
+
+func (r *memoryRepo) MarkEventsSent(_ context.Context, ids []int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range ids {
+		r.events[id-1].sent = true
+		if messageID := r.events[id-1].MessageID; r.messages[messageID].Status == entity.StatusReceived {
+			_ = r.updateStatus(messageID, entity.StatusReceived, entity.StatusPublished, "")
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepo) MarkEventFailed(context.Context, int64, string, time.Duration) error {
+	return nil
+}
+
+func (r *memoryRepo) DeleteSentEvents(context.Context, time.Duration) (int64, error) {
+	return 0, nil
+}
+
+func (r *memoryRepo) GetBacklog(context.Context) (entity.OutboxBacklog, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var backlog entity.OutboxBacklog
+	for i := range r.events {
+		if !r.events[i].sent {
+			backlog.Pending++
+		}
+	}
+	return backlog, nil
+}
+
+func TestMessageService_ProcessesThroughMemoryBroker(t *testing.T) {
+	broker := kafka.NewMemoryBroker(2)
+	repos := newMemoryRepo()
+
+	services := service.NewServices(service.ServicesDependencies{
+		Repos:         repos.repositories(),
+		KafkaProducer: kafka.NewMemoryProducer(broker, "messages"),
+		KafkaConsumer: kafka.NewMemoryConsumer(broker, "group", "messages"),
+		Outbox:        service.OutboxRelayConfig{PollInterval: 10 * time.Millisecond},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	created := testutil.ToFloat64(metrics.MessagesCreated)
+	processed := testutil.ToFloat64(metrics.MessagesProcessed)
+	go services.Outbox.Run(ctx)
+	go services.Processor.Run(ctx)
+
+	first, err := services.Message.CreateMessage(ctx, "Hello, world!")
+	require.NoError(t, err)
+	second, err := services.Message.CreateMessage(ctx, "Hello, world!")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		count, err := services.Message.GetProcessedMessagesStats(ctx)
+		return err == nil && count == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, id := range []uuid.UUID{first, second} {
+		message, err := services.Message.GetMessageById(ctx, id)
+		require.NoError(t, err)
+		assert.True(t, message.Processed)
+		assert.Equal(t, entity.StatusProcessed, message.Status)
+		assert.NotNil(t, message.ProcessingAt)
+
+		history, err := services.Message.GetMessageHistory(ctx, id)
+		require.NoError(t, err)
+		require.NotEmpty(t, history)
+		assert.Equal(t, entity.StatusProcessed, history[len(history)-1].To)
+	}
+
+	assert.Equal(t, created+2, testutil.ToFloat64(metrics.MessagesCreated))
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.MessagesProcessed) == processed+2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNewServices_ConstructsWhatTheRoleNeeds(t *testing.T) {
+	api := service.NewServices(service.ServicesDependencies{
+		Role:  entity.RoleAPI,
+		Repos: newMemoryRepo().repositories(),
+	})
+	assert.NotNil(t, api.Message)
+	assert.NotNil(t, api.Auth)
+	assert.Nil(t, api.Processor)
+	assert.Nil(t, api.Outbox)
+	assert.NotNil(t, api.Events)
+	assert.Equal(t, kafka.ConsumerStats{}, api.Message.GetConsumerStats())
+
+	broker := kafka.NewMemoryBroker(1)
+	worker := service.NewServices(service.ServicesDependencies{
+		Role:          entity.RoleWorker,
+		Repos:         newMemoryRepo().repositories(),
+		KafkaProducer: kafka.NewMemoryProducer(broker, "messages"),
+		KafkaConsumer: kafka.NewMemoryConsumer(broker, "group", "messages"),
+	})
+	assert.Nil(t, worker.Message)
+	assert.Nil(t, worker.Auth)
+	assert.Nil(t, worker.Events)
+	assert.NotNil(t, worker.Processor)
+	assert.NotNil(t, worker.Outbox)
+	assert.Equal(t, entity.RoleWorker, worker.Health.Live(context.Background()).Role)
+}
+
+func TestEvents_StreamsAndResumes(t *testing.T) {
+	broker := kafka.NewMemoryBroker(1)
+	repos := newMemoryRepo()
+	eventsCfg := service.EventsConfig{LogSize: 2, PollInterval: 10 * time.Millisecond}
+
+	services := service.NewServices(service.ServicesDependencies{
+		Repos:         repos.repositories(),
+		KafkaProducer: kafka.NewMemoryProducer(broker, "messages"),
+		KafkaConsumer: kafka.NewMemoryConsumer(broker, "group", "messages"),
+		Outbox:        service.OutboxRelayConfig{PollInterval: 10 * time.Millisecond},
+		Events:        eventsCfg,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go services.Events.Run(ctx)
+
+	workCtx, stopWorking := context.WithCancel(ctx)
+	var working sync.WaitGroup
+	working.Add(2)
+	go func() {
+		defer working.Done()
+		services.Outbox.Run(workCtx)
+	}()
+	go func() {
+		defer working.Done()
+		_ = services.Processor.Run(workCtx)
+	}()
+
+	// Subscribing waits for the log to find where the recorded transitions
+	// end.
+	replay, events := services.Events.Subscribe(ctx, service.EventFilter{})
+	assert.Empty(t, replay)
+
+	receive := func(n int) []entity.MessageEvent {
+		var received []entity.MessageEvent
+		for len(received) < n {
+			select {
+			case event := <-events:
+				received = append(received, event)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("got %d events, want %d", len(received), n)
+			}
+		}
+		return received
+	}
+
+	id, err := services.Message.CreateMessage(ctx, "Hello, world!")
+	require.NoError(t, err)
+
+	received := receive(2)
+	assert.Equal(t, entity.EventCreated, received[0].Type)
+	assert.Equal(t, entity.EventProcessed, received[1].Type)
+	assert.Equal(t, id, received[1].MessageID)
+	// Event IDs are transition IDs, the transitions to published and
+	// processing in between make no events.
+	history, err := services.Message.GetMessageHistory(ctx, id)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, uint64(history[2].ID), received[1].ID)
+
+	stopWorking()
+	working.Wait()
+
+	// Two more messages push the first two events out of the two slot log.
+	other, err := services.Message.CreateMessage(ctx, "Hello, universe!")
+	require.NoError(t, err)
+	_, err = services.Message.CreateMessage(ctx, "Hello, everyone!")
+	require.NoError(t, err)
+	receive(2)
+
+	replay, _ = services.Events.Subscribe(ctx, service.EventFilter{After: received[0].ID})
+	require.Len(t, replay, 3, "the evicted event is read back from the database")
+	assert.Equal(t, received[1].ID, replay[0].ID)
+
+	replay, _ = services.Events.Subscribe(ctx, service.EventFilter{After: received[0].ID, MessageID: &other})
+	require.Len(t, replay, 1)
+	assert.Equal(t, other, replay[0].MessageID)
+
+	// Another instance, or this one after a restart, resumes the same way.
+	restarted := service.NewEventLog(repos, eventsCfg)
+	go restarted.Run(ctx)
+	replay, _ = restarted.Subscribe(ctx, service.EventFilter{After: received[0].ID})
+	require.Len(t, replay, 3)
+	assert.Equal(t, received[1].ID, replay[0].ID)
+}
+
+func TestEvents_SlowSubscriberCatchesUp(t *testing.T) {
+	repos := newMemoryRepo()
+	services := service.NewServices(service.ServicesDependencies{
+		Role:   entity.RoleAPI,
+		Repos:  repos.repositories(),
+		Events: service.EventsConfig{LogSize: 4, SubscriberBuffer: 1, PollInterval: 10 * time.Millisecond},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go services.Events.Run(ctx)
+	_, events := services.Events.Subscribe(ctx, service.EventFilter{})
+
+	// A batch far larger than the buffer and the log arrives while the
+	// subscriber is not reading.
+	contents := make([]string, 50)
+	for i := range contents {
+		contents[i] = fmt.Sprintf("message %d", i)
+	}
+	results, err := services.Message.CreateMessages(ctx, contents)
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	var last uint64
+	for i, result := range results {
+		require.NotNil(t, result.ID)
+		select {
+		case event, ok := <-events:
+			require.True(t, ok, "the subscriber is not dropped")
+			assert.Greater(t, event.ID, last)
+			assert.Equal(t, *result.ID, event.MessageID)
+			last = event.ID
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d events, want %d", i, len(results))
+		}
+	}
+}
+
+// uncommittedTransitions hides the transitions with the given IDs, as if
+// their transactions were still running.
+type uncommittedTransitions struct {
+	repo.Message
+	mu          sync.Mutex
+	transitions []entity.MessageTransition
+	hidden      map[int64]bool
+}
+
+func (r *uncommittedTransitions) commit(id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.hidden, id)
+}
+
+func (r *uncommittedTransitions) visible(keep func(entity.MessageTransition) bool) []entity.MessageTransition {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var transitions []entity.MessageTransition
+	for _, t := range r.transitions {
+		if !r.hidden[t.ID] && keep(t) {
+			transitions = append(transitions, t)
+		}
+	}
+	return transitions
+}
+
+func (r *uncommittedTransitions) GetTransitionsAfter(_ context.Context, after int64, limit int) ([]entity.MessageTransition, error) {
+	transitions := r.visible(func(t entity.MessageTransition) bool { return t.ID > after })
+	return transitions[:min(len(transitions), limit)], nil
+}
+
+func (r *uncommittedTransitions) GetTransitions(_ context.Context, ids []int64) ([]entity.MessageTransition, error) {
+	return r.visible(func(t entity.MessageTransition) bool { return slices.Contains(ids, t.ID) }), nil
+}
+
+func (r *uncommittedTransitions) GetLastTransitionID(context.Context) (int64, error) {
+	return 0, nil
+}
+
+func TestEvents_DoNotWaitOnMissingTransitions(t *testing.T) {
+	repos := &uncommittedTransitions{hidden: map[int64]bool{2: true}}
+	for id := int64(1); id <= 3; id++ {
+		repos.transitions = append(repos.transitions, entity.MessageTransition{ID: id, MessageID: uuid.New(), To: entity.StatusReceived})
+	}
+	log := service.NewEventLog(repos, service.EventsConfig{PollInterval: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go log.Run(ctx)
+	_, events := log.Subscribe(ctx, service.EventFilter{})
+
+	receive := func() uint64 {
+		select {
+		case event := <-events:
+			return event.ID
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+			return 0
+		}
+	}
+
+	// The transitions after the missing one are published right away, the
+	// missing one once it shows up.
+	assert.Equal(t, uint64(1), receive())
+	assert.Equal(t, uint64(3), receive())
+	repos.commit(2)
+	assert.Equal(t, uint64(2), receive())
+
+	replay, _ := log.Subscribe(ctx, service.EventFilter{After: 3})
+	require.Len(t, replay, 1, "a subscriber resuming after 3 gets the late event")
+	assert.Equal(t, uint64(2), replay[0].ID)
+}
+
+func TestEvents_FilterByMessageIDSet(t *testing.T) {
+	repos := &uncommittedTransitions{hidden: make(map[int64]bool)}
+	for id := int64(1); id <= 3; id++ {
+		repos.transitions = append(repos.transitions, entity.MessageTransition{ID: id, MessageID: uuid.New(), To: entity.StatusReceived})
+		repos.hidden[id] = true
+	}
+	log := service.NewEventLog(repos, service.EventsConfig{PollInterval: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go log.Run(ctx)
+
+	ids := service.NewMessageIDSet()
+	ids.Add(repos.transitions[0].MessageID)
+	_, events := log.Subscribe(ctx, service.EventFilter{MessageIDs: ids})
+
+	// The set may change while subscribed.
+	assert.True(t, ids.Remove(repos.transitions[0].MessageID))
+	assert.False(t, ids.Remove(repos.transitions[0].MessageID))
+	ids.Add(repos.transitions[2].MessageID)
+	for id := int64(1); id <= 3; id++ {
+		repos.commit(id)
+	}
+
+	select {
+	case event := <-events:
+		assert.Equal(t, uint64(3), event.ID)
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+}
+
+func TestEvents_APIRoleSeesWhatWorkersDo(t *testing.T) {
+	broker := kafka.NewMemoryBroker(1)
+	repos := newMemoryRepo()
+
+	api := service.NewServices(service.ServicesDependencies{
+		Role:   entity.RoleAPI,
+		Repos:  repos.repositories(),
+		Events: service.EventsConfig{PollInterval: 10 * time.Millisecond},
+	})
+	worker := service.NewServices(service.ServicesDependencies{
+		Role:          entity.RoleWorker,
+		Repos:         repos.repositories(),
+		KafkaProducer: kafka.NewMemoryProducer(broker, "messages"),
+		KafkaConsumer: kafka.NewMemoryConsumer(broker, "group", "messages"),
+		Outbox:        service.OutboxRelayConfig{PollInterval: 10 * time.Millisecond},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var running sync.WaitGroup
+	running.Add(3)
+	go func() {
+		defer running.Done()
+		api.Events.Run(ctx)
+	}()
+	go func() {
+		defer running.Done()
+		worker.Outbox.Run(ctx)
+	}()
+	go func() {
+		defer running.Done()
+		_ = worker.Processor.Run(ctx)
+	}()
+	defer running.Wait()
+	defer cancel()
+
+	_, events := api.Events.Subscribe(ctx, service.EventFilter{})
+	id, err := api.Message.CreateMessage(ctx, "Hello, world!")
+	require.NoError(t, err)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type != entity.EventProcessed {
+				continue
+			}
+			assert.Equal(t, id, event.MessageID)
+			return
+		case <-timeout:
+			t.Fatal("the processed event did not reach the API instance")
+		}
+	}
+}
+
+func TestMessageService_RejectsIllegalTransitions(t *testing.T) {
+	repos := newMemoryRepo()
+	services := service.NewServices(service.ServicesDependencies{
+		Repos:         repos.repositories(),
+		KafkaProducer: kafka.NewMemoryProducer(kafka.NewMemoryBroker(1), "messages"),
+		KafkaConsumer: kafka.NewMemoryConsumer(kafka.NewMemoryBroker(1), "group", "messages"),
+	})
+
+	ctx := context.Background()
+	_, err := services.Message.MarkMessageAsProcessed(ctx, uuid.New(), 0)
+	assert.ErrorIs(t, err, serviceerrs.ErrMessageNotFound)
+
+	id, err := services.Message.CreateMessage(ctx, "message")
+	require.NoError(t, err)
+	require.NoError(t, repos.UpdateMessageStatus(ctx, id, 1, entity.StatusReceived, entity.StatusFailed, "database unavailable"))
+
+	_, err = services.Message.MarkMessageAsProcessed(ctx, id, 0)
+	assert.ErrorIs(t, err, serviceerrs.ErrInvalidTransition)
+
+	message, err := services.Message.GetMessageById(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, entity.StatusFailed, message.Status)
+	assert.Equal(t, "database unavailable", message.LastError)
+}
+
+func TestMessageService_MarkMessageAsProcessed(t *testing.T) {
+	repos := newMemoryRepo()
+	services := service.NewServices(service.ServicesDependencies{
+		Repos:         repos.repositories(),
+		KafkaProducer: kafka.NewMemoryProducer(kafka.NewMemoryBroker(1), "messages"),
+		KafkaConsumer: kafka.NewMemoryConsumer(kafka.NewMemoryBroker(1), "group", "messages"),
+	})
+
+	ctx := context.Background()
+	id, err := services.Message.CreateMessage(ctx, "message")
+	require.NoError(t, err)
+
+	_, err = services.Message.MarkMessageAsProcessed(ctx, id, 2)
+	assert.ErrorIs(t, err, serviceerrs.ErrVersionMismatch)
+	version, err := services.Message.MarkMessageAsProcessed(ctx, id, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+
+	message, err := services.Message.GetMessageById(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, entity.StatusProcessed, message.Status)
+	assert.Equal(t, 2, message.Version)
+	processedAt := message.ProcessedAt
+
+	_, err = services.Message.MarkMessageAsProcessed(ctx, id, 0)
+	assert.ErrorIs(t, err, serviceerrs.ErrMessageAlreadyProcessed)
+
+	message, err = services.Message.GetMessageById(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, processedAt, message.ProcessedAt)
+	assert.Equal(t, 2, message.Version)
+}
+
+func TestMessageService_GetMessagesPaginates(t *testing.T) {
+	repos := newMemoryRepo()
+	services := service.NewServices(service.ServicesDependencies{
+		Repos:         repos.repositories(),
+		KafkaProducer: kafka.NewMemoryProducer(kafka.NewMemoryBroker(1), "messages"),
+		KafkaConsumer: kafka.NewMemoryConsumer(kafka.NewMemoryBroker(1), "group", "messages"),
+	})
+
+	ctx := context.Background()
+	var created []uuid.UUID
+	for i := 0; i < 5; i++ {
+		id, err := services.Message.CreateMessage(ctx, "message")
+		require.NoError(t, err)
+		created = append(created, id)
+		time.Sleep(time.Millisecond)
+	}
+
+	var listed []uuid.UUID
+	input := service.GetMessagesInput{Order: entity.SortAsc, Limit: 2}
+	for pages := 1; ; pages++ {
+		page, err := services.Message.GetMessages(ctx, input)
+		require.NoError(t, err)
+		for _, message := range page.Messages {
+			listed = append(listed, message.ID)
+		}
+		if page.NextCursor == "" {
+			assert.Equal(t, 3, pages)
+			break
+		}
+		input.Cursor = page.NextCursor
+	}
+	assert.Equal(t, created, listed)
+
+	// A cursor only continues the order it was issued for.
+	input.Order = entity.SortDesc
+	_, err := services.Message.GetMessages(ctx, input)
+	assert.ErrorIs(t, err, serviceerrs.ErrInvalidCursor)
+
+	_, err = services.Message.GetMessages(ctx, service.GetMessagesInput{Limit: service.MaxPageSize + 1})
+	assert.ErrorIs(t, err, serviceerrs.ErrInvalidFilter)
+}
+
+func TestMessageService_GetStats(t *testing.T) {
+	repos := newMemoryRepo()
+	services := service.NewServices(service.ServicesDependencies{
+		Repos:         repos.repositories(),
+		KafkaProducer: kafka.NewMemoryProducer(kafka.NewMemoryBroker(1), "messages"),
+		KafkaConsumer: kafka.NewMemoryConsumer(kafka.NewMemoryBroker(1), "group", "messages"),
+	})
+
+	ctx := context.Background()
+	var ids []uuid.UUID
+	for i := 0; i < 3; i++ {
+		id, err := services.Message.CreateMessage(ctx, "message")
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	_, err := services.Message.MarkMessageAsProcessed(ctx, ids[0], 0)
+	require.NoError(t, err)
+	require.NoError(t, repos.UpdateMessageStatus(ctx, ids[1], 1, entity.StatusReceived, entity.StatusFailed, "database unavailable"))
+
+	stats, err := services.Message.GetStats(ctx, service.GetStatsInput{})
+	require.NoError(t, err)
+	assert.Equal(t, entity.MessageCounts{Total: 3, Processed: 1, Pending: 1, Failed: 1}, stats.Counts)
+	assert.Equal(t, entity.StatsBucketHour, stats.Bucket)
+	assert.Equal(t, service.DefaultStatsRange, stats.To.Sub(stats.From))
+
+	to := time.Now()
+	from := to.Add(-48 * time.Hour)
+	_, err = services.Message.GetStats(ctx, service.GetStatsInput{From: &from, To: &to, Bucket: entity.StatsBucketMinute})
+	assert.ErrorIs(t, err, serviceerrs.ErrInvalidFilter)
+
+	_, err = services.Message.GetStats(ctx, service.GetStatsInput{From: &to, To: &from})
+	assert.ErrorIs(t, err, serviceerrs.ErrInvalidFilter)
+}
+
+func TestMessageService_CreateMessages(t *testing.T) {
+	repos := newMemoryRepo()
+	services := service.NewServices(service.ServicesDependencies{
+		Repos:         repos.repositories(),
+		KafkaProducer: kafka.NewMemoryProducer(kafka.NewMemoryBroker(1), "messages"),
+		KafkaConsumer: kafka.NewMemoryConsumer(kafka.NewMemoryBroker(1), "group", "messages"),
+	})
+
+	ctx := context.Background()
+	results, err := services.Message.CreateMessages(ctx, []string{"first", "", "third"})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	for _, i := range []int{0, 2} {
+		assert.Equal(t, i, results[i].Index)
+		require.NotNil(t, results[i].ID)
+		assert.Empty(t, results[i].Error)
+		_, err := services.Message.GetMessageById(ctx, *results[i].ID)
+		assert.NoError(t, err)
+	}
+	assert.Nil(t, results[1].ID)
+	assert.Equal(t, serviceerrs.ErrEmptyMessage.Error(), results[1].Error)
+	assert.Len(t, repos.events, 2, "every created message gets an outbox event")
+
+	_, err = services.Message.CreateMessages(ctx, make([]string, service.MaxBatchSize+1))
+	assert.ErrorIs(t, err, serviceerrs.ErrBatchTooLarge)
+}
+
+// memoryIdempotency keeps idempotency keys in memory, without expiry.
+type memoryIdempotency struct {
+	mu   sync.Mutex
+	keys map[[2]string]entity.IdempotencyKey
+}
+
+func (m *memoryIdempotency) ReserveKey(_ context.Context, client, key, fingerprint string, _, _ time.Duration) (entity.IdempotencyKey, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if record, ok := m.keys[[2]string{client, key}]; ok {
+		return record, false, nil
+	}
+	record := entity.IdempotencyKey{Client: client, Key: key, Fingerprint: fingerprint}
+	m.keys[[2]string{client, key}] = record
+	return record, true, nil
+}
+
+func (m *memoryIdempotency) GetKey(_ context.Context, client, key string) (entity.IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.keys[[2]string{client, key}]
+	if !ok {
+		return entity.IdempotencyKey{}, repoerrs.ErrNotFound
+	}
+	return record, nil
+}
+
+func (m *memoryIdempotency) CompleteKey(_ context.Context, client, key string, statusCode int, response []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record := m.keys[[2]string{client, key}]
+	record.StatusCode, record.Response = statusCode, response
+	m.keys[[2]string{client, key}] = record
+	return nil
+}
+
+func (m *memoryIdempotency) ReleaseKey(_ context.Context, client, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.keys, [2]string{client, key})
+	return nil
+}
+
+func (m *memoryIdempotency) DeleteExpiredKeys(context.Context) (int64, error) {
+	return 0, nil
+}
+
+func TestIdempotencyService(t *testing.T) {
+	s := service.NewIdempotencyService(&memoryIdempotency{keys: make(map[[2]string]entity.IdempotencyKey)}, service.IdempotencyConfig{})
+	ctx := context.Background()
+
+	record, err := s.Begin(ctx, "client-a", "key-1", "fingerprint")
+	require.NoError(t, err)
+	assert.Nil(t, record, "a new key is reserved")
+
+	_, err = s.Begin(ctx, "client-a", "key-1", "fingerprint")
+	assert.ErrorIs(t, err, serviceerrs.ErrIdempotencyKeyInFlight)
+
+	require.NoError(t, s.Complete(ctx, "client-a", "key-1", 201, []byte("response")))
+
+	record, err = s.Begin(ctx, "client-a", "key-1", "fingerprint")
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	assert.Equal(t, 201, record.StatusCode)
+	assert.Equal(t, []byte("response"), record.Response)
+
+	_, err = s.Begin(ctx, "client-a", "key-1", "other fingerprint")
+	assert.ErrorIs(t, err, serviceerrs.ErrIdempotencyKeyReused)
+
+	record, err = s.Begin(ctx, "client-b", "key-1", "other fingerprint")
+	require.NoError(t, err)
+	assert.Nil(t, record, "another client has a key of its own")
+
+	_, err = s.Begin(ctx, "client-a", "key-2", "fingerprint")
+	require.NoError(t, err)
+	s.Release(ctx, "client-a", "key-2")
+	record, err = s.Begin(ctx, "client-a", "key-2", "fingerprint")
+	require.NoError(t, err)
+	assert.Nil(t, record, "a released key can be reused")
+}
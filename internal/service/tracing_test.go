@@ -0,0 +1,64 @@
+package service_test
+
+import (
+	"context"
+	"messagio_testsuite/internal/service"
+	"messagio_testsuite/pkg/kafka"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMessageService_TracesAcrossTheOutbox(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	broker := kafka.NewMemoryBroker(1)
+	services := service.NewServices(service.ServicesDependencies{
+		Repos:         newMemoryRepo().repositories(),
+		KafkaProducer: kafka.NewMemoryProducer(broker, "messages"),
+		KafkaConsumer: kafka.NewMemoryConsumer(broker, "group", "messages"),
+		Outbox:        service.OutboxRelayConfig{PollInterval: 10 * time.Millisecond},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go services.Outbox.Run(ctx)
+	go services.Processor.Run(ctx)
+
+	requestCtx, request := provider.Tracer("test").Start(ctx, "POST /api/v1/messages")
+	_, err := services.Message.CreateMessage(requestCtx, "Hello, world!")
+	require.NoError(t, err)
+	request.End()
+
+	spanNamed := func(name string) sdktrace.ReadOnlySpan {
+		for _, span := range recorder.Ended() {
+			if span.Name() == name {
+				return span
+			}
+		}
+		return nil
+	}
+	require.Eventually(t, func() bool {
+		return spanNamed("MessageProcessor.handleMessage") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	traceID := request.SpanContext().TraceID()
+	for _, name := range []string{"OutboxRelay.publish", "messages process", "MessageProcessor.handleMessage"} {
+		span := spanNamed(name)
+		if assert.NotNil(t, span, name) {
+			assert.Equal(t, traceID, span.SpanContext().TraceID(), "%s is in the trace of the request", name)
+		}
+	}
+	assert.Equal(t, spanNamed("OutboxRelay.publish").SpanContext().SpanID(), spanNamed("messages process").Parent().SpanID())
+}
@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+func setupRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	recorder := setupRecorder(t)
+
+	e := echo.New()
+	e.Use(HTTPMiddleware())
+	e.GET("/messages/:id", func(c echo.Context) error {
+		if c.Param("id") == "broken" {
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+		return c.NoContent(http.StatusOK)
+	})
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/messages/1", nil)
+	req.Header.Set("traceparent", parent)
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/messages/broken", nil))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	assert.Equal(t, "GET /messages/:id", spans[0].Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String(), "the trace of the caller is continued")
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	assert.Contains(t, spans[0].Attributes(), semconv.HTTPResponseStatusCode(http.StatusOK))
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	assert.False(t, spans[1].Parent().IsValid())
+	assert.Contains(t, spans[1].Attributes(), semconv.HTTPResponseStatusCode(http.StatusInternalServerError),
+		"errors are counted with the status sent")
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+}
+
+func TestInjectExtract(t *testing.T) {
+	setupRecorder(t)
+
+	assert.Nil(t, Inject(context.Background()), "nothing is stored outside a trace")
+
+	ctx, span := Start(context.Background(), "request")
+	carrier := Inject(ctx)
+	span.End()
+	require.Contains(t, carrier, "traceparent")
+
+	_, child := Start(Extract(context.Background(), carrier), "later")
+	defer child.End()
+	assert.Equal(t, span.SpanContext().TraceID(), child.SpanContext().TraceID())
+}
+
+func TestSQLOperation(t *testing.T) {
+	for sql, operation := range map[string]string{
+		"SELECT id FROM messaggio.messages":          "SELECT",
+		"  insert into messaggio.outbox VALUES ($1)": "INSERT",
+		"WITH sent AS (\n\tUPDATE messaggio.outbox":  "WITH",
+		"begin": "BEGIN",
+		"":      "",
+	} {
+		assert.Equal(t, operation, sqlOperation(sql), sql)
+	}
+}
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS messaggio.outbox;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE messaggio.outbox (
+    id BIGSERIAL PRIMARY KEY,
+    message_id uuid NOT NULL REFERENCES messaggio.messages (id) ON DELETE CASCADE,
+    message_key BYTEA,
+    payload BYTEA NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP
+);
+
+CREATE INDEX outbox_pending_idx ON messaggio.outbox (next_attempt_at, id) WHERE sent_at IS NULL;
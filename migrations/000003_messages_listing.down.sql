@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS messaggio.messages_message_trgm_idx;
+DROP INDEX IF EXISTS messaggio.messages_processed_at_idx;
+DROP INDEX IF EXISTS messaggio.messages_processed_created_at_id_idx;
+DROP INDEX IF EXISTS messaggio.messages_created_at_id_idx;
+
+ALTER TABLE messaggio.messages ALTER COLUMN created_at DROP NOT NULL;
@@ -0,0 +1,9 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+UPDATE messaggio.messages SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+ALTER TABLE messaggio.messages ALTER COLUMN created_at SET NOT NULL;
+
+CREATE INDEX messages_created_at_id_idx ON messaggio.messages (created_at, id);
+CREATE INDEX messages_processed_created_at_id_idx ON messaggio.messages (processed, created_at, id);
+CREATE INDEX messages_processed_at_idx ON messaggio.messages (processed_at) WHERE processed_at IS NOT NULL;
+CREATE INDEX messages_message_trgm_idx ON messaggio.messages USING gin (message gin_trgm_ops);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS messaggio.messages_failed_at_idx;
+
+ALTER TABLE messaggio.messages
+    DROP COLUMN IF EXISTS last_error,
+    DROP COLUMN IF EXISTS failed_at;
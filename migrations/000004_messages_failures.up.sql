@@ -0,0 +1,5 @@
+ALTER TABLE messaggio.messages
+    ADD COLUMN failed_at TIMESTAMP,
+    ADD COLUMN last_error TEXT;
+
+CREATE INDEX messages_failed_at_idx ON messaggio.messages (failed_at) WHERE failed_at IS NOT NULL;
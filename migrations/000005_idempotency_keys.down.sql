@@ -0,0 +1 @@
+DROP TABLE IF EXISTS messaggio.idempotency_keys;
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS messaggio.message_transitions;
+
+DROP INDEX IF EXISTS messaggio.messages_status_created_at_id_idx;
+
+ALTER TABLE messaggio.messages
+    DROP CONSTRAINT IF EXISTS messages_status_check,
+    DROP COLUMN IF EXISTS dead_lettered_at,
+    DROP COLUMN IF EXISTS processing_at,
+    DROP COLUMN IF EXISTS published_at,
+    DROP COLUMN IF EXISTS status;
@@ -0,0 +1,31 @@
+ALTER TABLE messaggio.messages
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'received',
+    ADD COLUMN published_at TIMESTAMP,
+    ADD COLUMN processing_at TIMESTAMP,
+    ADD COLUMN dead_lettered_at TIMESTAMP;
+
+UPDATE messaggio.messages SET status = CASE
+    WHEN processed THEN 'processed'
+    WHEN failed_at IS NOT NULL THEN 'failed'
+    ELSE 'received'
+END;
+
+UPDATE messaggio.messages m SET status = 'published', published_at = o.sent_at
+FROM messaggio.outbox o
+WHERE o.message_id = m.id AND o.sent_at IS NOT NULL AND m.status = 'received';
+
+ALTER TABLE messaggio.messages ADD CONSTRAINT messages_status_check
+    CHECK (status IN ('received', 'published', 'processing', 'processed', 'failed', 'dead_lettered'));
+
+CREATE INDEX messages_status_created_at_id_idx ON messaggio.messages (status, created_at, id);
+
+CREATE TABLE messaggio.message_transitions (
+    id BIGSERIAL PRIMARY KEY,
+    message_id uuid NOT NULL REFERENCES messaggio.messages (id) ON DELETE CASCADE,
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX message_transitions_message_id_idx ON messaggio.message_transitions (message_id, id);
@@ -0,0 +1 @@
+ALTER TABLE messaggio.messages DROP COLUMN IF EXISTS version;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS messaggio.api_keys;
@@ -0,0 +1,6 @@
+CREATE TABLE messaggio.client_quotas (
+    client TEXT NOT NULL,
+    day DATE NOT NULL,
+    used BIGINT NOT NULL,
+    PRIMARY KEY (client, day)
+);
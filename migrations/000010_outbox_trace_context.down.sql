@@ -0,0 +1 @@
+ALTER TABLE messaggio.outbox DROP COLUMN IF EXISTS trace_context;
@@ -0,0 +1 @@
+ALTER TABLE messaggio.outbox ADD COLUMN trace_context JSONB;
@@ -0,0 +1,2 @@
+DELETE FROM messaggio.message_transitions WHERE from_status IS NULL;
+ALTER TABLE messaggio.message_transitions ALTER COLUMN from_status SET NOT NULL;
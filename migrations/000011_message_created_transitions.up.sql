@@ -0,0 +1 @@
+ALTER TABLE messaggio.message_transitions ALTER COLUMN from_status DROP NOT NULL;
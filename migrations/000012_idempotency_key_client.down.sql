@@ -0,0 +1,7 @@
+DELETE FROM messaggio.idempotency_keys a USING messaggio.idempotency_keys b
+    WHERE a.key = b.key AND a.created_at < b.created_at;
+DELETE FROM messaggio.idempotency_keys a USING messaggio.idempotency_keys b
+    WHERE a.key = b.key AND a.created_at = b.created_at AND a.client < b.client;
+ALTER TABLE messaggio.idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE messaggio.idempotency_keys DROP COLUMN client;
+ALTER TABLE messaggio.idempotency_keys ADD PRIMARY KEY (key);
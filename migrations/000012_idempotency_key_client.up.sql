@@ -0,0 +1,4 @@
+ALTER TABLE messaggio.idempotency_keys ADD COLUMN client TEXT NOT NULL DEFAULT '';
+ALTER TABLE messaggio.idempotency_keys ALTER COLUMN client DROP DEFAULT;
+ALTER TABLE messaggio.idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE messaggio.idempotency_keys ADD PRIMARY KEY (client, key);
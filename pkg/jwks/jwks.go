@@ -0,0 +1,174 @@
+// Package jwks reads JSON Web Key Sets (RFC 7517) holding the public keys
+// tokens are verified with.
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrUnsupported = errors.New("unsupported key")
+)
+
+type jsonKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type key struct {
+	kid    string
+	alg    string
+	public interface{}
+}
+
+// Set is a parsed key set. Keys meant for encryption are left out.
+type Set struct {
+	keys []key
+}
+
+func Load(path string) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	return Parse(data)
+}
+
+func Parse(data []byte) (*Set, error) {
+	var doc struct {
+		Keys []jsonKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	set := &Set{}
+	for i, jk := range doc.Keys {
+		if jk.Use != "" && jk.Use != "sig" {
+			continue
+		}
+		public, err := jk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwks: key %d (%q): %w", i, jk.Kid, err)
+		}
+		set.keys = append(set.keys, key{kid: jk.Kid, alg: jk.Alg, public: public})
+	}
+	return set, nil
+}
+
+func (s *Set) Len() int {
+	return len(s.keys)
+}
+
+// Keyfunc picks the key a token was signed with by its kid header. A token
+// without kid is accepted only when the set holds a single key.
+func (s *Set) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if len(s.keys) != 1 {
+			return nil, ErrKeyNotFound
+		}
+		return s.keys[0].checkAlg(token.Method.Alg())
+	}
+
+	for _, k := range s.keys {
+		if k.kid == kid {
+			return k.checkAlg(token.Method.Alg())
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+func (k key) checkAlg(alg string) (interface{}, error) {
+	if k.alg != "" && k.alg != alg {
+		return nil, fmt.Errorf("%w: key is for %s, token is signed with %s", ErrUnsupported, k.alg, alg)
+	}
+	return k.public, nil
+}
+
+func (jk jsonKey) publicKey() (interface{}, error) {
+	switch jk.Kty {
+	case "RSA":
+		n, err := decodeInt(jk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(jk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("%w: RSA exponent too large", ErrUnsupported)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: curve %q", ErrUnsupported, jk.Crv)
+		}
+		x, err := decodeInt(jk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(jk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("%w: point is not on %s", ErrUnsupported, jk.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if jk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("%w: curve %q", ErrUnsupported, jk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: Ed25519 key has %d bytes", ErrUnsupported, len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("%w: kty %q", ErrUnsupported, jk.Kty)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("%w: missing key parameter", ErrUnsupported)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,69 @@
+package jwks_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"messagio_testsuite/pkg/jwks"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestParse(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	set, err := jwks.Parse([]byte(fmt.Sprintf(`{"keys": [
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "n": %q, "e": %q},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": %q},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": %q, "e": %q}
+	]}`,
+		b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		b64(edPublic),
+		b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+	)))
+	require.NoError(t, err)
+	assert.Equal(t, 2, set.Len(), "encryption keys are skipped")
+
+	key, err := set.Keyfunc(&jwt.Token{Method: jwt.SigningMethodRS256, Header: map[string]interface{}{"kid": "rsa"}})
+	require.NoError(t, err)
+	assert.True(t, rsaKey.PublicKey.Equal(key))
+
+	key, err = set.Keyfunc(&jwt.Token{Method: jwt.SigningMethodEdDSA, Header: map[string]interface{}{"kid": "ed"}})
+	require.NoError(t, err)
+	assert.Equal(t, edPublic, key)
+
+	_, err = set.Keyfunc(&jwt.Token{Method: jwt.SigningMethodRS512, Header: map[string]interface{}{"kid": "rsa"}})
+	assert.ErrorIs(t, err, jwks.ErrUnsupported, "the key's alg is enforced")
+
+	_, err = set.Keyfunc(&jwt.Token{Method: jwt.SigningMethodRS256, Header: map[string]interface{}{"kid": "enc"}})
+	assert.ErrorIs(t, err, jwks.ErrKeyNotFound)
+
+	_, err = set.Keyfunc(&jwt.Token{Method: jwt.SigningMethodRS256, Header: map[string]interface{}{}})
+	assert.ErrorIs(t, err, jwks.ErrKeyNotFound, "kid is required when the set has several keys")
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for name, doc := range map[string]string{
+		"not json":      `keys`,
+		"unknown kty":   `{"keys": [{"kty": "oct", "k": "c2VjcmV0"}]}`,
+		"unknown curve": `{"keys": [{"kty": "EC", "crv": "P-192", "x": "AQ", "y": "AQ"}]}`,
+		"off curve":     `{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`,
+		"missing n":     `{"keys": [{"kty": "RSA", "e": "AQAB"}]}`,
+	} {
+		_, err := jwks.Parse([]byte(doc))
+		assert.Error(t, err, name)
+	}
+}
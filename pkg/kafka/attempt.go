@@ -0,0 +1,52 @@
+package kafka
+
+import "context"
+
+// Attempt describes the delivery attempt a Handler is running for.
+type Attempt struct {
+	// Number counts attempts across retry tiers, starting at 1.
+	Number int
+	// Final is set on the last attempt: if it fails the record is handed to
+	// the dead-letter topic, or dropped when there is none.
+	Final bool
+	// DeadLetter is set on the final attempt when a dead-letter topic is
+	// configured.
+	DeadLetter bool
+}
+
+type (
+	attemptKey struct{}
+	giveUpKey  struct{}
+)
+
+// giveUpHooks collects what the final attempt registered with AfterGiveUp.
+type giveUpHooks struct {
+	fns []func(ctx context.Context)
+}
+
+// AttemptFromContext returns the attempt a Handler was called for.
+func AttemptFromContext(ctx context.Context) (Attempt, bool) {
+	a, ok := ctx.Value(attemptKey{}).(Attempt)
+	return a, ok
+}
+
+// AfterGiveUp registers fn to run once the consumer gave up on the record of a
+// final attempt and is done with it, either written to the dead-letter topic
+// or dropped. fn never runs while the record may still be redelivered. It
+// reports false outside of a final attempt, where nothing is registered.
+func AfterGiveUp(ctx context.Context, fn func(ctx context.Context)) bool {
+	hooks, ok := ctx.Value(giveUpKey{}).(*giveUpHooks)
+	if !ok {
+		return false
+	}
+	hooks.fns = append(hooks.fns, fn)
+	return true
+}
+
+func withAttempt(ctx context.Context, a Attempt, hooks *giveUpHooks) context.Context {
+	ctx = context.WithValue(ctx, attemptKey{}, a)
+	if a.Final {
+		ctx = context.WithValue(ctx, giveUpKey{}, hooks)
+	}
+	return ctx
+}
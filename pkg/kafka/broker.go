@@ -0,0 +1,28 @@
+package kafka
+
+import "context"
+
+// Producer and Consumer are implemented both on top of a Kafka cluster and on
+// top of a MemoryBroker, see NewKafkaProducer and NewMemoryProducer.
+type (
+	Producer interface {
+		Produce(ctx context.Context, envelope Envelope) error
+		ProduceRecords(ctx context.Context, records ...Record) error
+		WriterStats() []WriterStats
+		Ping(ctx context.Context) error
+		Close()
+	}
+
+	Consumer interface {
+		Consume(ctx context.Context, handler Handler) error
+		Stats() ConsumerStats
+		ReaderStats() []ReaderStats
+		Running() bool
+		Close()
+	}
+)
+
+var (
+	_ Producer = (*KafkaProducer)(nil)
+	_ Consumer = (*KafkaConsumer)(nil)
+)
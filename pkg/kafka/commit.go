@@ -0,0 +1,155 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+type Delivery string
+
+const (
+	// AtLeastOnce commits offsets only after the handler succeeded or the
+	// record was forwarded to a retry or dead-letter topic.
+	AtLeastOnce Delivery = "at_least_once"
+	// AtMostOnce commits offsets as soon as records are read.
+	AtMostOnce Delivery = "at_most_once"
+)
+
+func (d Delivery) Valid() bool {
+	switch d {
+	case AtLeastOnce, AtMostOnce:
+		return true
+	}
+	return false
+}
+
+const (
+	defaultCommitInterval  = time.Second
+	defaultCommitBatchSize = 100
+	commitFlushTimeout     = 5 * time.Second
+)
+
+// committer batches offset commits for a single reader. Records may complete
+// out of order when several workers share a partition, so an offset only
+// becomes committable once every record fetched before it is done. Commits are
+// flushed once batchSize records completed or every interval, whichever comes
+// first.
+type committer struct {
+	reader    messageReader
+	interval  time.Duration
+	batchSize int
+
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+	pending    map[int]kafka.Message
+	handled    int
+}
+
+type partitionOffsets struct {
+	inflight []kafka.Message
+	done     map[int64]bool
+}
+
+func newCommitter(reader messageReader, interval time.Duration, batchSize int) *committer {
+	return &committer{
+		reader:     reader,
+		interval:   interval,
+		batchSize:  batchSize,
+		partitions: make(map[int]*partitionOffsets),
+		pending:    make(map[int]kafka.Message),
+	}
+}
+
+// track registers a fetched record, it must be called in fetch order. A
+// record that does not follow the last tracked one means the partition was
+// rewound by a rebalance, everything in flight before it is fetched again.
+func (c *committer) track(m kafka.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.partitions[m.Partition]
+	if !ok || (len(p.inflight) > 0 && m.Offset <= p.inflight[len(p.inflight)-1].Offset) {
+		p = &partitionOffsets{done: make(map[int64]bool)}
+		c.partitions[m.Partition] = p
+	}
+	p.inflight = append(p.inflight, m)
+}
+
+// done marks a tracked record as handled and advances the committable offset
+// of its partition as far as the contiguous run of handled records allows.
+func (c *committer) done(ctx context.Context, m kafka.Message) {
+	c.mu.Lock()
+	p, ok := c.partitions[m.Partition]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	p.done[m.Offset] = true
+	for len(p.inflight) > 0 && p.done[p.inflight[0].Offset] {
+		delete(p.done, p.inflight[0].Offset)
+		c.merge(p.inflight[0])
+		p.inflight = p.inflight[1:]
+	}
+	c.handled++
+	full := c.handled >= c.batchSize
+	c.mu.Unlock()
+
+	if full {
+		c.flush(ctx)
+	}
+}
+
+// merge must be called with mu held.
+func (c *committer) merge(m kafka.Message) {
+	if cur, ok := c.pending[m.Partition]; !ok || m.Offset > cur.Offset {
+		c.pending[m.Partition] = m
+	}
+}
+
+func (c *committer) flush(ctx context.Context) {
+	c.mu.Lock()
+	messages := make([]kafka.Message, 0, len(c.pending))
+	for _, m := range c.pending {
+		messages = append(messages, m)
+	}
+	c.pending = make(map[int]kafka.Message)
+	c.handled = 0
+	c.mu.Unlock()
+
+	if len(messages) == 0 {
+		return
+	}
+
+	if err := c.reader.CommitMessages(ctx, messages...); err != nil {
+		logrus.Errorf("Failed to commit offsets: %v", err)
+
+		c.mu.Lock()
+		for _, m := range messages {
+			c.merge(m)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// run flushes on every interval until ctx is done, then makes a final flush
+// that is not bound to ctx so handled records are not redelivered needlessly.
+func (c *committer) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), commitFlushTimeout)
+			c.flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			c.flush(ctx)
+		}
+	}
+}
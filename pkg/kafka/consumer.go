@@ -2,55 +2,418 @@ package kafka
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// mainTier is the tier index of records read from the consumer's own topic.
+const mainTier = -1
+
+const (
+	defaultWorkers      = 1
+	defaultQueueDepth   = 100
+	defaultDrainTimeout = 10 * time.Second
+)
+
+// ErrUnhandled is returned by Consume in at-least-once mode when a record could
+// neither be handled nor forwarded. Its offset is left uncommitted so the
+// record is redelivered once the consumer is restarted.
+var ErrUnhandled = errors.New("kafka: record could not be handled")
+
+type Handler func(ctx context.Context, envelope Envelope) error
+
+type messageReader interface {
+	ReadMessage(ctx context.Context) (kafka.Message, error)
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type ConsumerStats struct {
+	Workers    int   `json:"workers"`
+	QueueDepth int   `json:"queue_depth"`
+	InFlight   int64 `json:"in_flight"`
+	Processed  int64 `json:"processed"`
+	Forwarded  int64 `json:"forwarded"`
+	Failed     int64 `json:"failed"`
+}
+
 type KafkaConsumer struct {
-	reader *kafka.Reader
+	topic           string
+	retry           RetryPolicy
+	delivery        Delivery
+	commitInterval  time.Duration
+	commitBatchSize int
+	workers         int
+	queueDepth      int
+	drainTimeout    time.Duration
+
+	readers []messageReader
+	writer  messageWriter
+
+	running   atomic.Bool
+	inFlight  atomic.Int64
+	processed atomic.Int64
+	forwarded atomic.Int64
+	failed    atomic.Int64
 }
 
-func NewKafkaConsumer(brokers []string, groupID, topic string) *KafkaConsumer {
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:  brokers,
-		GroupID:  groupID,
-		Topic:    topic,
-		MinBytes: 10e3, // 10KB
-		MaxBytes: 10e6, // 10MB
-	})
-	return &KafkaConsumer{
-		reader: r,
+func NewKafkaConsumer(brokers []string, groupID, topic string, opts ...ConsumerOption) *KafkaConsumer {
+	openReader := func(topic string) messageReader {
+		return kafka.NewReader(kafka.ReaderConfig{
+			Brokers:  brokers,
+			GroupID:  groupID,
+			Topic:    topic,
+			MinBytes: 10e3, // 10KB
+			MaxBytes: 10e6, // 10MB
+		})
 	}
+	openWriter := func() messageWriter {
+		return &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.Hash{},
+			AllowAutoTopicCreation: true,
+		}
+	}
+
+	return newConsumer(topic, openReader, openWriter, opts...)
 }
 
-func (kc *KafkaConsumer) Consume(ctx context.Context, handler func(ctx context.Context, message string)) {
-	run := true
-	for run {
+func newConsumer(topic string, openReader func(topic string) messageReader, openWriter func() messageWriter, opts ...ConsumerOption) *KafkaConsumer {
+	kc := &KafkaConsumer{
+		topic:           topic,
+		retry:           RetryPolicy{}.withDefaults(),
+		delivery:        AtLeastOnce,
+		commitInterval:  defaultCommitInterval,
+		commitBatchSize: defaultCommitBatchSize,
+		workers:         defaultWorkers,
+		queueDepth:      defaultQueueDepth,
+		drainTimeout:    defaultDrainTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(kc)
+	}
+
+	kc.readers = append(kc.readers, openReader(topic))
+	for _, tier := range kc.retry.Tiers {
+		kc.readers = append(kc.readers, openReader(tier.Topic))
+	}
+
+	if len(kc.retry.Tiers) > 0 || kc.retry.DeadLetterTopic != "" {
+		kc.writer = openWriter()
+	}
+
+	return kc
+}
+
+// Consume reads the main topic and every retry tier until ctx is done and the
+// records in flight are drained. In at-least-once mode it also stops,
+// returning ErrUnhandled, as soon as a record can neither be handled nor
+// forwarded.
+func (kc *KafkaConsumer) Consume(ctx context.Context, handler Handler) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	kc.running.Store(true)
+	defer kc.running.Store(false)
+
+	var wg sync.WaitGroup
+	for i, reader := range kc.readers {
+		wg.Add(1)
+		go func(reader messageReader, tier int) {
+			defer wg.Done()
+			if err := kc.consumeTier(ctx, reader, tier, handler); err != nil {
+				cancel(err)
+			}
+		}(reader, i+mainTier)
+	}
+	wg.Wait()
+
+	kc.Close()
+
+	if err := context.Cause(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// consumeTier fetches records from reader and dispatches them to a pool of
+// workers. Records with the same key, or the same partition when there is no
+// key, always go to the same worker and are therefore handled in order. A
+// full worker queue blocks fetching. Retry tiers use a single worker since
+// their records wait for the tier delay anyway.
+//
+// Once ctx is done fetching stops, while records already handed to a worker
+// are given up to the drain timeout to finish before their handlers are
+// cancelled.
+func (kc *KafkaConsumer) consumeTier(ctx context.Context, reader messageReader, tier int, handler Handler) error {
+	fetchCtx, stopFetching := context.WithCancelCause(ctx)
+	defer stopFetching(nil)
+
+	handlerCtx, cancelHandlers := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelHandlers()
+	go func() {
 		select {
-		case <-ctx.Done():
-			run = false
-		default:
-			m, err := kc.reader.ReadMessage(ctx)
-			if err != nil {
-				if err == context.DeadlineExceeded || err == context.Canceled {
-					logrus.Debugf("Consumer timeout: %v", err)
-					continue
-				}
-				logrus.Errorf("Consumer error: %v", err)
-				continue
+		case <-fetchCtx.Done():
+			sleep(handlerCtx, kc.drainTimeout)
+			cancelHandlers()
+		case <-handlerCtx.Done():
+		}
+	}()
+
+	workers := kc.workers
+	if tier != mainTier {
+		workers = 1
+	}
+
+	read := reader.FetchMessage
+	var commits *committer
+	if kc.delivery == AtMostOnce {
+		read = reader.ReadMessage
+	} else {
+		commits = newCommitter(reader, kc.commitInterval, kc.commitBatchSize)
+		commitCtx, stopCommits := context.WithCancel(handlerCtx)
+		commitsDone := make(chan struct{})
+		go func() {
+			defer close(commitsDone)
+			commits.run(commitCtx)
+		}()
+		defer func() {
+			stopCommits()
+			<-commitsDone
+		}()
+	}
+
+	var wg sync.WaitGroup
+	queues := make([]chan kafka.Message, workers)
+	for i := range queues {
+		queues[i] = make(chan kafka.Message, kc.queueDepth)
+		wg.Add(1)
+		go func(queue <-chan kafka.Message) {
+			defer wg.Done()
+			for m := range queue {
+				kc.work(handlerCtx, fetchCtx, stopFetching, commits, m, tier, handler)
 			}
-			handler(ctx, string(m.Value))
+		}(queues[i])
+	}
+
+	for fetchCtx.Err() == nil {
+		m, err := kc.next(fetchCtx, read)
+		if err != nil {
+			continue
+		}
+
+		if commits != nil {
+			commits.track(m)
+		}
+		kc.inFlight.Add(1)
+		select {
+		case queues[workerFor(m, workers)] <- m:
+		case <-fetchCtx.Done():
+			kc.inFlight.Add(-1)
+		}
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+
+	if err := context.Cause(fetchCtx); errors.Is(err, ErrUnhandled) {
+		return err
+	}
+	return nil
+}
+
+func (kc *KafkaConsumer) work(ctx, fetchCtx context.Context, stopFetching context.CancelCauseFunc, commits *committer, m kafka.Message, tier int, handler Handler) {
+	defer kc.inFlight.Add(-1)
+
+	// Nothing queued behind a record that could not be handled can be
+	// committed, so there is no point in handling it now.
+	if ctx.Err() != nil || errors.Is(context.Cause(fetchCtx), ErrUnhandled) {
+		return
+	}
+
+	if !kc.process(ctx, m, tier, handler) {
+		if ctx.Err() == nil {
+			stopFetching(fmt.Errorf("%w: %s/%d@%d", ErrUnhandled, m.Topic, m.Partition, m.Offset))
 		}
+		return
+	}
+
+	if commits != nil {
+		commits.done(ctx, m)
 	}
+}
 
-	if err := kc.reader.Close(); err != nil {
-		logrus.Errorf("Failed to close consumer: %v", err)
+func workerFor(m kafka.Message, workers int) int {
+	if len(m.Key) == 0 {
+		return m.Partition % workers
 	}
+
+	h := fnv.New32a()
+	h.Write(m.Key)
+	return int(h.Sum32() % uint32(workers))
 }
 
-func (kp *KafkaConsumer) Close() {
-	if err := kp.reader.Close(); err != nil {
-		logrus.Errorf("Failed to close producer: %v", err)
+func (kc *KafkaConsumer) Stats() ConsumerStats {
+	return ConsumerStats{
+		Workers:    kc.workers,
+		QueueDepth: kc.queueDepth,
+		InFlight:   kc.inFlight.Load(),
+		Processed:  kc.processed.Load(),
+		Forwarded:  kc.forwarded.Load(),
+		Failed:     kc.failed.Load(),
+	}
+}
+
+func (kc *KafkaConsumer) next(ctx context.Context, read func(ctx context.Context) (kafka.Message, error)) (kafka.Message, error) {
+	m, err := read(ctx)
+	if err != nil {
+		if err == context.DeadlineExceeded || err == context.Canceled {
+			logrus.Debugf("Consumer timeout: %v", err)
+		} else {
+			logrus.Errorf("Consumer error: %v", err)
+		}
+	}
+	return m, err
+}
+
+// process runs the handler with the retry policy and reports whether the record
+// is done with, either handled or forwarded to the next tier.
+func (kc *KafkaConsumer) process(ctx context.Context, m kafka.Message, tier int, handler Handler) bool {
+	if tier != mainTier {
+		if !sleep(ctx, time.Until(notBefore(m))) {
+			return false
+		}
+	}
+
+	ctx, span := startProcessSpan(ctx, m)
+	defer span.End()
+
+	envelope := DecodeEnvelope(m.Key, m.Value)
+	f := previousFailure(m)
+	_, next := kc.retry.nextTopic(tier)
+	lastTier := next == nil
+
+	var (
+		err   error
+		hooks giveUpHooks
+	)
+	for attempt := 1; attempt <= kc.retry.Attempts; attempt++ {
+		final := lastTier && attempt == kc.retry.Attempts
+		attemptCtx := withAttempt(ctx, Attempt{
+			Number:     f.attempts + attempt,
+			Final:      final,
+			DeadLetter: final && kc.retry.DeadLetterTopic != "",
+		}, &hooks)
+		if err = handler(attemptCtx, envelope); err == nil {
+			kc.processed.Add(1)
+			return true
+		}
+
+		span.RecordError(err, trace.WithAttributes(attemptAttribute(f.attempts+attempt)))
+		logrus.Warnf("Handler failed for %s/%d@%d (attempt %d/%d): %v",
+			m.Topic, m.Partition, m.Offset, attempt, kc.retry.Attempts, err)
+		if attempt < kc.retry.Attempts && !sleep(ctx, kc.retry.backoff(attempt)) {
+			return false
+		}
+	}
+
+	kc.failed.Add(1)
+	span.SetStatus(codes.Error, err.Error())
+
+	f.err = err
+	f.attempts += kc.retry.Attempts
+	if f.firstFailureAt.IsZero() {
+		f.firstFailureAt = time.Now()
+	}
+
+	if !kc.forward(ctx, m, tier, f) {
+		return false
+	}
+	for _, fn := range hooks.fns {
+		fn(ctx)
+	}
+	return true
+}
+
+// forward hands a record that exhausted its attempts to the next retry tier or
+// the dead-letter topic. In at-least-once mode a failed write is retried with
+// the backoff of the retry policy until it succeeds or ctx is done.
+func (kc *KafkaConsumer) forward(ctx context.Context, m kafka.Message, tier int, f failure) bool {
+	topic, next := kc.retry.nextTopic(tier)
+	if topic == "" {
+		if kc.delivery == AtMostOnce {
+			logrus.Errorf("Dropping %s/%d@%d after %d attempt(s): %v", m.Topic, m.Partition, m.Offset, f.attempts, f.err)
+			return true
+		}
+		logrus.Errorf("Giving up on %s/%d@%d after %d attempt(s): %v", m.Topic, m.Partition, m.Offset, f.attempts, f.err)
+		return false
+	}
+
+	record := failureRecord(m, topic, f, next)
+	for attempt := 1; ; attempt++ {
+		err := kc.writer.WriteMessages(ctx, record)
+		if err == nil {
+			break
+		}
+
+		logrus.Errorf("Failed to forward %s/%d@%d to %s (attempt %d): %v", m.Topic, m.Partition, m.Offset, topic, attempt, err)
+		if kc.delivery == AtMostOnce {
+			return true
+		}
+		// Giving up would stop the consumer over an outage of the cluster,
+		// keep the record until the cluster is back or the consumer stops.
+		if !sleep(ctx, kc.retry.backoff(attempt)) {
+			return false
+		}
+	}
+	kc.forwarded.Add(1)
+	logrus.Infof("Forwarded %s/%d@%d to %s after %d attempt(s)", m.Topic, m.Partition, m.Offset, topic, f.attempts)
+	return true
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (kc *KafkaConsumer) Close() {
+	for _, reader := range kc.readers {
+		if err := reader.Close(); err != nil {
+			logrus.Errorf("Failed to close consumer: %v", err)
+		}
+	}
+	if kc.writer != nil {
+		if err := kc.writer.Close(); err != nil {
+			logrus.Errorf("Failed to close retry producer: %v", err)
+		}
 	}
 }
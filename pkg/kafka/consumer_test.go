@@ -0,0 +1,359 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTopic is a single partition topic with one committed offset, shared by
+// every fakeReader opened on it so that a new reader resumes where the
+// previous one committed.
+type fakeTopic struct {
+	mu        sync.Mutex
+	messages  []kafka.Message
+	committed int64
+}
+
+func newFakeTopic(t *testing.T, topic string, envelopes ...Envelope) *fakeTopic {
+	ft := &fakeTopic{}
+	for i, envelope := range envelopes {
+		value, err := envelope.Marshal()
+		require.NoError(t, err)
+		ft.messages = append(ft.messages, kafka.Message{
+			Topic:  topic,
+			Offset: int64(i),
+			Key:    envelope.Key(),
+			Value:  value,
+		})
+	}
+	return ft
+}
+
+func (ft *fakeTopic) committedOffset() int64 {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return ft.committed
+}
+
+func (ft *fakeTopic) reader() *fakeReader {
+	return &fakeReader{topic: ft, next: ft.committedOffset()}
+}
+
+type fakeReader struct {
+	topic *fakeTopic
+	next  int64
+}
+
+func (r *fakeReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	m, err := r.FetchMessage(ctx)
+	if err != nil {
+		return m, err
+	}
+	return m, r.CommitMessages(ctx, m)
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.topic.mu.Lock()
+	if r.next < int64(len(r.topic.messages)) {
+		m := r.topic.messages[r.next]
+		r.next++
+		r.topic.mu.Unlock()
+		return m, nil
+	}
+	r.topic.mu.Unlock()
+
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *fakeReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.topic.mu.Lock()
+	defer r.topic.mu.Unlock()
+	for _, m := range msgs {
+		if m.Offset+1 > r.topic.committed {
+			r.topic.committed = m.Offset + 1
+		}
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	return nil
+}
+
+func newFakeConsumer(ft *fakeTopic, opts ...ConsumerOption) *KafkaConsumer {
+	return newConsumer("messages",
+		func(string) messageReader { return ft.reader() },
+		func() messageWriter { return nil },
+		append([]ConsumerOption{WithCommitInterval(10 * time.Millisecond)}, opts...)...,
+	)
+}
+
+func TestKafkaConsumer_RedeliversAfterHandlerFailure(t *testing.T) {
+	first, second, third := uuid.New(), uuid.New(), uuid.New()
+	ft := newFakeTopic(t, "messages",
+		NewEnvelope(first, "first", time.Now()),
+		NewEnvelope(second, "second", time.Now()),
+		NewEnvelope(third, "third", time.Now()),
+	)
+
+	var handled []uuid.UUID
+	failing := func(_ context.Context, envelope Envelope) error {
+		if envelope.ID == second {
+			return errors.New("database unavailable")
+		}
+		handled = append(handled, envelope.ID)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := newFakeConsumer(ft).Consume(ctx, failing)
+	require.ErrorIs(t, err, ErrUnhandled)
+	assert.Equal(t, []uuid.UUID{first}, handled)
+	assert.Equal(t, int64(1), ft.committedOffset(), "the failed record must stay uncommitted")
+
+	// Restart: the failed record and everything after it is delivered again.
+	handled = nil
+	restartCtx, stop := context.WithCancel(ctx)
+	healthy := func(_ context.Context, envelope Envelope) error {
+		handled = append(handled, envelope.ID)
+		if envelope.ID == third {
+			stop()
+		}
+		return nil
+	}
+
+	err = newFakeConsumer(ft).Consume(restartCtx, healthy)
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{second, third}, handled)
+	assert.Equal(t, int64(3), ft.committedOffset())
+}
+
+func TestKafkaConsumer_AtMostOnceCommitsOnRead(t *testing.T) {
+	ft := newFakeTopic(t, "messages", NewEnvelope(uuid.New(), "only", time.Now()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := newFakeConsumer(ft, WithDelivery(AtMostOnce)).Consume(ctx, func(context.Context, Envelope) error {
+		cancel()
+		return errors.New("database unavailable")
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), ft.committedOffset())
+}
+
+func TestDelivery_Valid(t *testing.T) {
+	assert.True(t, AtLeastOnce.Valid())
+	assert.True(t, AtMostOnce.Valid())
+	assert.False(t, Delivery("at-most-one").Valid())
+	assert.False(t, Delivery("").Valid())
+}
+
+func TestKafkaConsumer_PreservesOrderPerKey(t *testing.T) {
+	keys := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+
+	var envelopes []Envelope
+	for i := 0; i < 30; i++ {
+		envelopes = append(envelopes, NewEnvelope(keys[i%len(keys)], "message", time.Now().Add(time.Duration(i))))
+	}
+	ft := newFakeTopic(t, "messages", envelopes...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var (
+		mu    sync.Mutex
+		seen  = make(map[uuid.UUID][]time.Time)
+		count int
+	)
+	handler := func(_ context.Context, envelope Envelope) error {
+		if envelope.ID == keys[0] {
+			time.Sleep(time.Millisecond)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		seen[envelope.ID] = append(seen[envelope.ID], envelope.CreatedAt)
+		count++
+		if count == len(envelopes) {
+			cancel()
+		}
+		return nil
+	}
+
+	consumer := newFakeConsumer(ft, WithWorkers(4), WithQueueDepth(2))
+	require.NoError(t, consumer.Consume(ctx, handler))
+
+	for _, key := range keys {
+		assert.Len(t, seen[key], 10)
+		assert.IsIncreasing(t, seen[key], "records with the same key are handled in order")
+	}
+	assert.Equal(t, int64(len(envelopes)), ft.committedOffset())
+
+	stats := consumer.Stats()
+	assert.Equal(t, 4, stats.Workers)
+	assert.Equal(t, 2, stats.QueueDepth)
+	assert.Equal(t, int64(0), stats.InFlight)
+	assert.Equal(t, int64(len(envelopes)), stats.Processed)
+}
+
+func TestCommitter_CommitsContiguousOffsets(t *testing.T) {
+	ft := newFakeTopic(t, "messages",
+		NewEnvelope(uuid.New(), "first", time.Now()),
+		NewEnvelope(uuid.New(), "second", time.Now()),
+		NewEnvelope(uuid.New(), "third", time.Now()),
+	)
+	ctx := context.Background()
+	commits := newCommitter(ft.reader(), time.Hour, 100)
+
+	for _, m := range ft.messages {
+		commits.track(m)
+	}
+
+	commits.done(ctx, ft.messages[2])
+	commits.flush(ctx)
+	assert.Equal(t, int64(0), ft.committedOffset(), "earlier records are still in flight")
+
+	commits.done(ctx, ft.messages[0])
+	commits.flush(ctx)
+	assert.Equal(t, int64(1), ft.committedOffset())
+
+	commits.done(ctx, ft.messages[1])
+	commits.flush(ctx)
+	assert.Equal(t, int64(3), ft.committedOffset())
+}
+
+func TestKafkaConsumer_DrainsInFlightOnShutdown(t *testing.T) {
+	ft := newFakeTopic(t, "messages", NewEnvelope(uuid.New(), "slow", time.Now()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var handlerErr error
+	handler := func(handlerCtx context.Context, _ Envelope) error {
+		cancel()
+		time.Sleep(20 * time.Millisecond)
+		handlerErr = handlerCtx.Err()
+		return nil
+	}
+
+	require.NoError(t, newFakeConsumer(ft).Consume(ctx, handler))
+	assert.NoError(t, handlerErr, "handlers in flight keep a live context while draining")
+	assert.Equal(t, int64(1), ft.committedOffset())
+}
+
+func TestKafkaConsumer_DrainTimeout(t *testing.T) {
+	ft := newFakeTopic(t, "messages", NewEnvelope(uuid.New(), "stuck", time.Now()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := func(handlerCtx context.Context, _ Envelope) error {
+		cancel()
+		<-handlerCtx.Done()
+		return handlerCtx.Err()
+	}
+
+	start := time.Now()
+	consumer := newFakeConsumer(ft, WithDrainTimeout(20*time.Millisecond))
+	require.NoError(t, consumer.Consume(ctx, handler))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Equal(t, int64(0), ft.committedOffset(), "an abandoned record is redelivered")
+	assert.Equal(t, int64(0), consumer.Stats().InFlight)
+}
+
+// flakyWriter fails the first failures writes.
+type flakyWriter struct {
+	mu       sync.Mutex
+	failures int
+	written  []kafka.Message
+}
+
+func (w *flakyWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("unknown topic or partition")
+	}
+	w.written = append(w.written, msgs...)
+	return nil
+}
+
+func (w *flakyWriter) Close() error {
+	return nil
+}
+
+func TestKafkaConsumer_RetriesFailedForwards(t *testing.T) {
+	ft := newFakeTopic(t, "messages", NewEnvelope(uuid.New(), "poison", time.Now()))
+	writer := &flakyWriter{failures: 2}
+	consumer := newConsumer("messages",
+		func(string) messageReader { return ft.reader() },
+		func() messageWriter { return writer },
+		WithCommitInterval(10*time.Millisecond),
+		WithRetryPolicy(RetryPolicy{Attempts: 1, InitialBackoff: time.Millisecond, DeadLetterTopic: "messages.dlq"}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		for ft.committedOffset() == 0 && ctx.Err() == nil {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+
+	err := consumer.Consume(ctx, func(context.Context, Envelope) error {
+		return errors.New("database unavailable")
+	})
+	require.NoError(t, err, "a failed forward does not stop the consumer")
+	assert.Equal(t, int64(1), ft.committedOffset())
+	require.Len(t, writer.written, 1)
+	assert.Equal(t, "messages.dlq", writer.written[0].Topic)
+	assert.Equal(t, int64(1), consumer.Stats().Forwarded)
+}
+
+func TestKafkaConsumer_AfterGiveUpRunsOnceDeadLettered(t *testing.T) {
+	ft := newFakeTopic(t, "messages", NewEnvelope(uuid.New(), "poison", time.Now()))
+	writer := &flakyWriter{failures: 1}
+	consumer := newConsumer("messages",
+		func(string) messageReader { return ft.reader() },
+		func() messageWriter { return writer },
+		WithCommitInterval(10*time.Millisecond),
+		WithRetryPolicy(RetryPolicy{Attempts: 2, InitialBackoff: time.Millisecond, DeadLetterTopic: "messages.dlq"}),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var registered []bool
+	gaveUp := make(chan int, 1)
+	err := consumer.Consume(ctx, func(handlerCtx context.Context, _ Envelope) error {
+		registered = append(registered, AfterGiveUp(handlerCtx, func(context.Context) {
+			writer.mu.Lock()
+			defer writer.mu.Unlock()
+			gaveUp <- len(writer.written)
+			cancel()
+		}))
+		return errors.New("database unavailable")
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true}, registered, "only the final attempt registers")
+	select {
+	case written := <-gaveUp:
+		assert.Equal(t, 1, written, "the hook runs after the dead-letter write")
+	default:
+		t.Fatal("the hook did not run")
+	}
+}
@@ -0,0 +1,73 @@
+package kafka
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// LegacyEnvelopeVersion marks records produced before the envelope was
+	// introduced, whose value is the bare message content.
+	LegacyEnvelopeVersion = 0
+	EnvelopeVersion       = 1
+)
+
+type Envelope struct {
+	ID        uuid.UUID `json:"id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int       `json:"version"`
+}
+
+func NewEnvelope(id uuid.UUID, content string, createdAt time.Time) Envelope {
+	return Envelope{
+		ID:        id,
+		Content:   content,
+		CreatedAt: createdAt,
+		Version:   EnvelopeVersion,
+	}
+}
+
+func (e Envelope) IsLegacy() bool {
+	return e.Version == LegacyEnvelopeVersion
+}
+
+func (e Envelope) Key() []byte {
+	if e.ID == uuid.Nil {
+		return nil
+	}
+	return []byte(e.ID.String())
+}
+
+func (e Envelope) Marshal() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// DecodeEnvelope understands both versioned JSON envelopes and legacy records
+// carrying the raw content string. For legacy records the ID is taken from the
+// key when it holds a UUID and is left as uuid.Nil otherwise.
+func DecodeEnvelope(key, value []byte) Envelope {
+	var env Envelope
+	if err := json.Unmarshal(value, &env); err == nil && env.Version >= EnvelopeVersion {
+		if env.ID == uuid.Nil {
+			env.ID = keyID(key)
+		}
+		return env
+	}
+
+	return Envelope{
+		ID:      keyID(key),
+		Content: string(value),
+		Version: LegacyEnvelopeVersion,
+	}
+}
+
+func keyID(key []byte) uuid.UUID {
+	id, err := uuid.ParseBytes(key)
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
@@ -0,0 +1,37 @@
+package kafka_test
+
+import (
+	"messagio_testsuite/pkg/kafka"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	id := uuid.New()
+	createdAt := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	envelope := kafka.NewEnvelope(id, "Hello, world!", createdAt)
+
+	value, err := envelope.Marshal()
+	require.NoError(t, err)
+
+	decoded := kafka.DecodeEnvelope(envelope.Key(), value)
+	assert.Equal(t, envelope, decoded)
+	assert.False(t, decoded.IsLegacy())
+}
+
+func TestDecodeEnvelope_Legacy(t *testing.T) {
+	decoded := kafka.DecodeEnvelope(nil, []byte("Hello, world!"))
+	assert.True(t, decoded.IsLegacy())
+	assert.Equal(t, uuid.Nil, decoded.ID)
+	assert.Equal(t, "Hello, world!", decoded.Content)
+
+	id := uuid.New()
+	decoded = kafka.DecodeEnvelope([]byte(id.String()), []byte(`{"message": "json but not an envelope"}`))
+	assert.True(t, decoded.IsLegacy())
+	assert.Equal(t, id, decoded.ID)
+	assert.Equal(t, `{"message": "json but not an envelope"}`, decoded.Content)
+}
@@ -0,0 +1,48 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Ping asks the cluster for the metadata of the producer's topic and fails
+// when the topic is missing or has no partitions. On top of a MemoryBroker
+// there is nothing to ask and it always succeeds.
+func (kp *KafkaProducer) Ping(ctx context.Context) error {
+	if kp.ping == nil {
+		return nil
+	}
+	return kp.ping(ctx)
+}
+
+// Running reports whether Consume is reading the topics.
+func (kc *KafkaConsumer) Running() bool {
+	return kc.running.Load()
+}
+
+func topicMetadata(brokers []string, topic string) func(ctx context.Context) error {
+	client := &kafka.Client{Addr: kafka.TCP(brokers...)}
+
+	return func(ctx context.Context) error {
+		resp, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+		if err != nil {
+			return err
+		}
+
+		for _, t := range resp.Topics {
+			if t.Name != topic {
+				continue
+			}
+			if t.Error != nil {
+				return t.Error
+			}
+			if len(t.Partitions) == 0 {
+				return fmt.Errorf("kafka: topic %q has no partitions", topic)
+			}
+			return nil
+		}
+		return fmt.Errorf("kafka: topic %q not found", topic)
+	}
+}
@@ -0,0 +1,299 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const defaultMemoryPartitions = 4
+
+var errMemoryClosed = errors.New("kafka: memory reader closed")
+
+// MemoryBroker is an in-process stand-in for a Kafka cluster. It keeps
+// partitioned topics, per consumer group committed offsets and partition
+// assignment, so uncommitted records are redelivered when a reader is
+// reopened or the group rebalances, the same way they would be by Kafka.
+type MemoryBroker struct {
+	partitions int
+
+	mu      sync.Mutex
+	topics  map[string][][]kafka.Message
+	groups  map[memoryGroupKey]*memoryGroup
+	written chan struct{}
+}
+
+type memoryGroupKey struct {
+	group string
+	topic string
+}
+
+type memoryGroup struct {
+	committed  map[int]int64
+	members    []*memoryReader
+	generation int
+}
+
+func NewMemoryBroker(partitions int) *MemoryBroker {
+	if partitions <= 0 {
+		partitions = defaultMemoryPartitions
+	}
+
+	return &MemoryBroker{
+		partitions: partitions,
+		topics:     make(map[string][][]kafka.Message),
+		groups:     make(map[memoryGroupKey]*memoryGroup),
+		written:    make(chan struct{}),
+	}
+}
+
+func NewMemoryProducer(b *MemoryBroker, topic string) *KafkaProducer {
+	return &KafkaProducer{
+		topic:  topic,
+		writer: &memoryWriter{broker: b, topic: topic},
+	}
+}
+
+func NewMemoryConsumer(b *MemoryBroker, groupID, topic string, opts ...ConsumerOption) *KafkaConsumer {
+	openReader := func(topic string) messageReader {
+		return b.join(groupID, topic)
+	}
+	openWriter := func() messageWriter {
+		return &memoryWriter{broker: b}
+	}
+
+	return newConsumer(topic, openReader, openWriter, opts...)
+}
+
+// Committed returns the next offset the group will read from the partition.
+func (b *MemoryBroker) Committed(groupID, topic string, partition int) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if g, ok := b.groups[memoryGroupKey{groupID, topic}]; ok {
+		return g.committed[partition]
+	}
+	return 0
+}
+
+// Messages returns a copy of every record written to the topic, partition by
+// partition.
+func (b *MemoryBroker) Messages(topic string) []kafka.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var messages []kafka.Message
+	for _, partition := range b.topic(topic) {
+		messages = append(messages, partition...)
+	}
+	return messages
+}
+
+// topic must be called with mu held.
+func (b *MemoryBroker) topic(name string) [][]kafka.Message {
+	partitions, ok := b.topics[name]
+	if !ok {
+		partitions = make([][]kafka.Message, b.partitions)
+		b.topics[name] = partitions
+	}
+	return partitions
+}
+
+func (b *MemoryBroker) write(defaultTopic string, msgs []kafka.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, m := range msgs {
+		if m.Topic == "" && defaultTopic == "" {
+			return errors.New("kafka: memory writer has no topic")
+		}
+	}
+
+	for _, m := range msgs {
+		if m.Topic == "" {
+			m.Topic = defaultTopic
+		}
+
+		partitions := b.topic(m.Topic)
+		m.Partition = b.partitionFor(m.Key, partitions)
+		m.Offset = int64(len(partitions[m.Partition]))
+		if m.Time.IsZero() {
+			m.Time = time.Now()
+		}
+		partitions[m.Partition] = append(partitions[m.Partition], m)
+	}
+
+	close(b.written)
+	b.written = make(chan struct{})
+	return nil
+}
+
+// partitionFor must be called with mu held.
+func (b *MemoryBroker) partitionFor(key []byte, partitions [][]kafka.Message) int {
+	if len(key) == 0 {
+		shortest := 0
+		for i := range partitions {
+			if len(partitions[i]) < len(partitions[shortest]) {
+				shortest = i
+			}
+		}
+		return shortest
+	}
+
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(len(partitions)))
+}
+
+func (b *MemoryBroker) join(groupID, topic string) *memoryReader {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := memoryGroupKey{groupID, topic}
+	g, ok := b.groups[key]
+	if !ok {
+		g = &memoryGroup{committed: make(map[int]int64)}
+		b.groups[key] = g
+	}
+
+	r := &memoryReader{broker: b, group: g, topic: topic, generation: -1}
+	g.members = append(g.members, r)
+	g.generation++
+	return r
+}
+
+func (b *MemoryBroker) leave(r *memoryReader) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i, member := range r.group.members {
+		if member == r {
+			r.group.members = append(r.group.members[:i], r.group.members[i+1:]...)
+			r.group.generation++
+			break
+		}
+	}
+
+	// Wake up blocked fetches so they notice the rebalance or the close.
+	close(b.written)
+	b.written = make(chan struct{})
+}
+
+type memoryReader struct {
+	broker *MemoryBroker
+	group  *memoryGroup
+	topic  string
+
+	// Guarded by broker.mu.
+	closed     bool
+	generation int
+	positions  map[int]int64
+	next       int
+}
+
+func (r *memoryReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
+	m, err := r.FetchMessage(ctx)
+	if err != nil {
+		return m, err
+	}
+	return m, r.CommitMessages(ctx, m)
+}
+
+func (r *memoryReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		r.broker.mu.Lock()
+		if r.closed {
+			r.broker.mu.Unlock()
+			return kafka.Message{}, errMemoryClosed
+		}
+		m, ok := r.poll()
+		written := r.broker.written
+		r.broker.mu.Unlock()
+
+		if ok {
+			return m, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		case <-written:
+		}
+	}
+}
+
+// poll must be called with broker.mu held. After every rebalance the reader
+// resumes its assigned partitions from the committed offsets.
+func (r *memoryReader) poll() (kafka.Message, bool) {
+	if r.generation != r.group.generation {
+		r.generation = r.group.generation
+		r.positions = make(map[int]int64)
+
+		index := 0
+		for i, member := range r.group.members {
+			if member == r {
+				index = i
+			}
+		}
+		for p := 0; p < r.broker.partitions; p++ {
+			if p%len(r.group.members) == index {
+				r.positions[p] = r.group.committed[p]
+			}
+		}
+	}
+
+	partitions := r.broker.topic(r.topic)
+	for i := 0; i < len(partitions); i++ {
+		p := (r.next + i) % len(partitions)
+		position, assigned := r.positions[p]
+		if !assigned || position >= int64(len(partitions[p])) {
+			continue
+		}
+
+		r.positions[p] = position + 1
+		r.next = p + 1
+		return partitions[p][position], true
+	}
+	return kafka.Message{}, false
+}
+
+func (r *memoryReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	r.broker.mu.Lock()
+	defer r.broker.mu.Unlock()
+
+	for _, m := range msgs {
+		if m.Offset+1 > r.group.committed[m.Partition] {
+			r.group.committed[m.Partition] = m.Offset + 1
+		}
+	}
+	return nil
+}
+
+func (r *memoryReader) Close() error {
+	r.broker.mu.Lock()
+	closed := r.closed
+	r.closed = true
+	r.broker.mu.Unlock()
+
+	if !closed {
+		r.broker.leave(r)
+	}
+	return nil
+}
+
+type memoryWriter struct {
+	broker *MemoryBroker
+	topic  string
+}
+
+func (w *memoryWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	return w.broker.write(w.topic, msgs)
+}
+
+func (w *memoryWriter) Close() error {
+	return nil
+}
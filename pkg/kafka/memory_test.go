@@ -0,0 +1,136 @@
+package kafka_test
+
+import (
+	"context"
+	"errors"
+	"messagio_testsuite/pkg/kafka"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func produceEnvelopes(t *testing.T, producer *kafka.KafkaProducer, n int) []uuid.UUID {
+	t.Helper()
+
+	ids := make([]uuid.UUID, n)
+	for i := range ids {
+		ids[i] = uuid.New()
+		require.NoError(t, producer.Produce(context.Background(), kafka.NewEnvelope(ids[i], "message", time.Now())))
+	}
+	return ids
+}
+
+func TestMemoryBroker_ConsumesAndCommits(t *testing.T) {
+	broker := kafka.NewMemoryBroker(2)
+	producer := kafka.NewMemoryProducer(broker, "messages")
+	ids := produceEnvelopes(t, producer, 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var (
+		mu   sync.Mutex
+		seen []uuid.UUID
+	)
+	consumer := kafka.NewMemoryConsumer(broker, "group", "messages", kafka.WithCommitInterval(10*time.Millisecond))
+	err := consumer.Consume(ctx, func(_ context.Context, envelope kafka.Envelope) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, envelope.ID)
+		if len(seen) == len(ids) {
+			cancel()
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, ids, seen)
+
+	var committed int64
+	for p := 0; p < 2; p++ {
+		committed += broker.Committed("group", "messages", p)
+	}
+	assert.Equal(t, int64(len(ids)), committed)
+}
+
+func TestMemoryBroker_RedeliversUncommitted(t *testing.T) {
+	broker := kafka.NewMemoryBroker(1)
+	producer := kafka.NewMemoryProducer(broker, "messages")
+	ids := produceEnvelopes(t, producer, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := kafka.NewMemoryConsumer(broker, "group", "messages").Consume(ctx, func(_ context.Context, envelope kafka.Envelope) error {
+		if envelope.ID == ids[1] {
+			return errors.New("database unavailable")
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, kafka.ErrUnhandled)
+	assert.Equal(t, int64(1), broker.Committed("group", "messages", 0))
+
+	var redelivered []uuid.UUID
+	restartCtx, stop := context.WithCancel(ctx)
+	err = kafka.NewMemoryConsumer(broker, "group", "messages").Consume(restartCtx, func(_ context.Context, envelope kafka.Envelope) error {
+		redelivered = append(redelivered, envelope.ID)
+		if len(redelivered) == 2 {
+			stop()
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ids[1:], redelivered)
+
+	// A different group starts from the beginning of the topic.
+	assert.Equal(t, int64(0), broker.Committed("other", "messages", 0))
+}
+
+func TestMemoryBroker_DeadLetters(t *testing.T) {
+	broker := kafka.NewMemoryBroker(1)
+	producer := kafka.NewMemoryProducer(broker, "messages")
+	ids := produceEnvelopes(t, producer, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	consumer := kafka.NewMemoryConsumer(broker, "group", "messages",
+		kafka.WithRetryPolicy(kafka.RetryPolicy{
+			Attempts:        2,
+			InitialBackoff:  time.Millisecond,
+			DeadLetterTopic: "messages.dlq",
+		}),
+		kafka.WithCommitInterval(10*time.Millisecond),
+	)
+
+	attempts := 0
+	var seen []kafka.Attempt
+	err := consumer.Consume(ctx, func(handlerCtx context.Context, _ kafka.Envelope) error {
+		attempt, ok := kafka.AttemptFromContext(handlerCtx)
+		require.True(t, ok)
+		seen = append(seen, attempt)
+
+		attempts++
+		if attempts == 2 {
+			go func() {
+				for len(broker.Messages("messages.dlq")) == 0 {
+					time.Sleep(time.Millisecond)
+				}
+				cancel()
+			}()
+		}
+		return errors.New("database unavailable")
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, []kafka.Attempt{{Number: 1}, {Number: 2, Final: true, DeadLetter: true}}, seen)
+
+	dead := broker.Messages("messages.dlq")
+	require.Len(t, dead, 1)
+	assert.Equal(t, ids[0], kafka.DecodeEnvelope(dead[0].Key, dead[0].Value).ID)
+	assert.Equal(t, int64(1), broker.Committed("group", "messages", 0))
+	assert.Equal(t, int64(1), consumer.Stats().Forwarded)
+}
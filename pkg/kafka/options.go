@@ -0,0 +1,59 @@
+package kafka
+
+import "time"
+
+type ConsumerOption func(*KafkaConsumer)
+
+func WithRetryPolicy(policy RetryPolicy) ConsumerOption {
+	return func(c *KafkaConsumer) {
+		c.retry = policy.withDefaults()
+	}
+}
+
+func WithDelivery(delivery Delivery) ConsumerOption {
+	return func(c *KafkaConsumer) {
+		c.delivery = delivery
+	}
+}
+
+func WithCommitInterval(interval time.Duration) ConsumerOption {
+	return func(c *KafkaConsumer) {
+		if interval > 0 {
+			c.commitInterval = interval
+		}
+	}
+}
+
+func WithCommitBatchSize(size int) ConsumerOption {
+	return func(c *KafkaConsumer) {
+		if size > 0 {
+			c.commitBatchSize = size
+		}
+	}
+}
+
+func WithWorkers(workers int) ConsumerOption {
+	return func(c *KafkaConsumer) {
+		if workers > 0 {
+			c.workers = workers
+		}
+	}
+}
+
+func WithQueueDepth(depth int) ConsumerOption {
+	return func(c *KafkaConsumer) {
+		if depth > 0 {
+			c.queueDepth = depth
+		}
+	}
+}
+
+// WithDrainTimeout bounds how long Consume waits for records in flight once its
+// context is done.
+func WithDrainTimeout(timeout time.Duration) ConsumerOption {
+	return func(c *KafkaConsumer) {
+		if timeout > 0 {
+			c.drainTimeout = timeout
+		}
+	}
+}
@@ -5,34 +5,83 @@ import (
 
 	"github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/codes"
 )
 
+// WriteErrors is returned by ProduceRecords when only some of the records
+// failed, the errors are in the same order as the records.
+type WriteErrors = kafka.WriteErrors
+
+type Record struct {
+	Key   []byte
+	Value []byte
+	// Headers are sent as record headers. Records without them carry the
+	// trace context of the publish span instead.
+	Headers map[string]string
+}
+
 type KafkaProducer struct {
-	writer *kafka.Writer
+	topic  string
+	writer messageWriter
+	ping   func(ctx context.Context) error
 }
 
+// NewKafkaProducer partitions records by key, so the records of a message stay
+// in order for the consumer.
 func NewKafkaProducer(brokers []string, topic string) *KafkaProducer {
 	w := kafka.NewWriter(kafka.WriterConfig{
 		Brokers:  brokers,
 		Topic:    topic,
-		Balancer: &kafka.LeastBytes{},
+		Balancer: &kafka.Hash{},
 	})
+	w.AllowAutoTopicCreation = true
 	return &KafkaProducer{
+		topic:  topic,
 		writer: w,
+		ping:   topicMetadata(brokers, topic),
 	}
 }
 
-func (kp *KafkaProducer) Produce(ctx context.Context, message string) error {
-	err := kp.writer.WriteMessages(ctx,
-		kafka.Message{
-			Value: []byte(message),
-		},
-	)
+func (kp *KafkaProducer) Produce(ctx context.Context, envelope Envelope) error {
+	value, err := envelope.Marshal()
+	if err != nil {
+		return err
+	}
+
+	return kp.ProduceRecords(ctx, Record{
+		Key:   envelope.Key(),
+		Value: value,
+	})
+}
+
+func (kp *KafkaProducer) ProduceRecords(ctx context.Context, records ...Record) error {
+	ctx, span := startPublishSpan(ctx, kp.topic, len(records))
+	defer span.End()
+
+	messages := make([]kafka.Message, len(records))
+	for i, record := range records {
+		var headers []kafka.Header
+		if record.Headers == nil {
+			headers = injectHeaders(ctx, nil)
+		}
+		for k, v := range record.Headers {
+			headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+		}
+		messages[i] = kafka.Message{
+			Key:     record.Key,
+			Value:   record.Value,
+			Headers: headers,
+		}
+	}
+
+	err := kp.writer.WriteMessages(ctx, messages...)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		logrus.Errorf("Failed to produce message: %v", err)
 		return err
 	}
-	logrus.Infof("Message delivered to topic %v", kp.writer.Stats().Topic)
+	logrus.Infof("%d message(s) delivered to topic %v", len(records), kp.topic)
 	return nil
 }
 
@@ -0,0 +1,152 @@
+package kafka
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Failure headers attached to records forwarded to a retry or dead-letter topic.
+const (
+	HeaderError          = "x-error"
+	HeaderAttempts       = "x-attempts"
+	HeaderFirstFailureAt = "x-first-failure-at"
+	HeaderOriginalTopic  = "x-original-topic"
+	HeaderNotBefore      = "x-retry-not-before"
+)
+
+const (
+	defaultRetryAttempts       = 1
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMultiplier     = 2
+)
+
+type RetryTier struct {
+	Topic string
+	Delay time.Duration
+}
+
+// RetryPolicy controls what happens when a handler fails. Each record is
+// attempted Attempts times in place with exponential backoff, then forwarded to
+// the next retry tier, and once the tiers are exhausted to DeadLetterTopic.
+type RetryPolicy struct {
+	Attempts        int
+	InitialBackoff  time.Duration
+	MaxBackoff      time.Duration
+	Multiplier      float64
+	Tiers           []RetryTier
+	DeadLetterTopic string
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Attempts <= 0 {
+		p.Attempts = defaultRetryAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = defaultRetryMultiplier
+	}
+	return p
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		backoff *= p.Multiplier
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+// nextTopic returns the topic a record that failed on the given tier is
+// forwarded to, tier -1 being the main topic. An empty string means the
+// record has nowhere left to go.
+func (p RetryPolicy) nextTopic(tier int) (string, *RetryTier) {
+	if tier+1 < len(p.Tiers) {
+		return p.Tiers[tier+1].Topic, &p.Tiers[tier+1]
+	}
+	return p.DeadLetterTopic, nil
+}
+
+type failure struct {
+	err            error
+	attempts       int
+	firstFailureAt time.Time
+	originalTopic  string
+}
+
+func isFailureHeader(key string) bool {
+	switch key {
+	case HeaderError, HeaderAttempts, HeaderFirstFailureAt, HeaderOriginalTopic, HeaderNotBefore:
+		return true
+	}
+	return false
+}
+
+func headerValue(headers []kafka.Header, key string) (string, bool) {
+	for _, h := range headers {
+		if h.Key == key {
+			return string(h.Value), true
+		}
+	}
+	return "", false
+}
+
+// previousFailure restores the failure state carried by a record that has
+// already been through a retry tier.
+func previousFailure(m kafka.Message) failure {
+	f := failure{originalTopic: m.Topic}
+	if v, ok := headerValue(m.Headers, HeaderAttempts); ok {
+		f.attempts, _ = strconv.Atoi(v)
+	}
+	if v, ok := headerValue(m.Headers, HeaderFirstFailureAt); ok {
+		f.firstFailureAt, _ = time.Parse(time.RFC3339Nano, v)
+	}
+	if v, ok := headerValue(m.Headers, HeaderOriginalTopic); ok {
+		f.originalTopic = v
+	}
+	return f
+}
+
+func notBefore(m kafka.Message) time.Time {
+	v, ok := headerValue(m.Headers, HeaderNotBefore)
+	if !ok {
+		return time.Time{}
+	}
+	t, _ := time.Parse(time.RFC3339Nano, v)
+	return t
+}
+
+// failureRecord builds the record forwarded to topic, keeping the original key,
+// value and any foreign headers.
+func failureRecord(m kafka.Message, topic string, f failure, tier *RetryTier) kafka.Message {
+	headers := make([]kafka.Header, 0, len(m.Headers)+5)
+	for _, h := range m.Headers {
+		if !isFailureHeader(h.Key) {
+			headers = append(headers, h)
+		}
+	}
+
+	headers = append(headers,
+		kafka.Header{Key: HeaderError, Value: []byte(f.err.Error())},
+		kafka.Header{Key: HeaderAttempts, Value: []byte(strconv.Itoa(f.attempts))},
+		kafka.Header{Key: HeaderFirstFailureAt, Value: []byte(f.firstFailureAt.UTC().Format(time.RFC3339Nano))},
+		kafka.Header{Key: HeaderOriginalTopic, Value: []byte(f.originalTopic)},
+	)
+	if tier != nil {
+		at := time.Now().Add(tier.Delay).UTC()
+		headers = append(headers, kafka.Header{Key: HeaderNotBefore, Value: []byte(at.Format(time.RFC3339Nano))})
+	}
+
+	return kafka.Message{
+		Topic:   topic,
+		Key:     m.Key,
+		Value:   m.Value,
+		Headers: headers,
+	}
+}
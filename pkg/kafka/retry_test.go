@@ -0,0 +1,87 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}.withDefaults()
+
+	assert.Equal(t, 100*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 800*time.Millisecond, policy.backoff(4))
+	assert.Equal(t, time.Second, policy.backoff(5))
+}
+
+func TestRetryPolicy_NextTopic(t *testing.T) {
+	policy := RetryPolicy{
+		Tiers:           []RetryTier{{Topic: "messages.retry.1"}, {Topic: "messages.retry.2"}},
+		DeadLetterTopic: "messages.dlq",
+	}
+
+	topic, tier := policy.nextTopic(mainTier)
+	assert.Equal(t, "messages.retry.1", topic)
+	assert.NotNil(t, tier)
+
+	topic, _ = policy.nextTopic(0)
+	assert.Equal(t, "messages.retry.2", topic)
+
+	topic, tier = policy.nextTopic(1)
+	assert.Equal(t, "messages.dlq", topic)
+	assert.Nil(t, tier)
+}
+
+func TestFailureRecord(t *testing.T) {
+	firstFailure := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	original := kafka.Message{
+		Topic: "messages",
+		Key:   []byte("key"),
+		Value: []byte("value"),
+		Headers: []kafka.Header{
+			{Key: "traceparent", Value: []byte("00-abc-def-01")},
+		},
+	}
+
+	retried := failureRecord(original, "messages.retry.1", failure{
+		err:            errors.New("boom"),
+		attempts:       3,
+		firstFailureAt: firstFailure,
+		originalTopic:  "messages",
+	}, &RetryTier{Topic: "messages.retry.1", Delay: time.Minute})
+
+	assert.Equal(t, "messages.retry.1", retried.Topic)
+	assert.Equal(t, original.Key, retried.Key)
+	assert.Equal(t, original.Value, retried.Value)
+	assert.False(t, notBefore(retried).IsZero())
+
+	retried.Topic = "messages.retry.1"
+	f := previousFailure(retried)
+	assert.Equal(t, 3, f.attempts)
+	assert.Equal(t, firstFailure, f.firstFailureAt)
+	assert.Equal(t, "messages", f.originalTopic)
+
+	dead := failureRecord(retried, "messages.dlq", failure{
+		err:            errors.New("still broken"),
+		attempts:       6,
+		firstFailureAt: f.firstFailureAt,
+		originalTopic:  f.originalTopic,
+	}, nil)
+
+	value, _ := headerValue(dead.Headers, HeaderError)
+	assert.Equal(t, "still broken", value)
+	value, _ = headerValue(dead.Headers, HeaderAttempts)
+	assert.Equal(t, "6", value)
+	value, _ = headerValue(dead.Headers, "traceparent")
+	assert.Equal(t, "00-abc-def-01", value)
+	assert.True(t, notBefore(dead).IsZero())
+	assert.Len(t, dead.Headers, 5)
+}
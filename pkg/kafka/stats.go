@@ -0,0 +1,40 @@
+package kafka
+
+import "github.com/segmentio/kafka-go"
+
+// WriterStats and ReaderStats are the client statistics of kafka-go. Their
+// counters and durations cover the time since the previous call.
+type (
+	WriterStats   = kafka.WriterStats
+	ReaderStats   = kafka.ReaderStats
+	DurationStats = kafka.DurationStats
+)
+
+type statsWriter interface {
+	Stats() kafka.WriterStats
+}
+
+type statsReader interface {
+	Stats() kafka.ReaderStats
+}
+
+// WriterStats returns the statistics of the writer, none on top of a
+// MemoryBroker.
+func (kp *KafkaProducer) WriterStats() []WriterStats {
+	if w, ok := kp.writer.(statsWriter); ok {
+		return []WriterStats{w.Stats()}
+	}
+	return nil
+}
+
+// ReaderStats returns the statistics of the readers of the main topic and
+// every retry tier, none on top of a MemoryBroker.
+func (kc *KafkaConsumer) ReaderStats() []ReaderStats {
+	var stats []ReaderStats
+	for _, reader := range kc.readers {
+		if r, ok := reader.(statsReader); ok {
+			stats = append(stats, r.Stats())
+		}
+	}
+	return stats
+}
@@ -0,0 +1,82 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "messagio_testsuite/pkg/kafka"
+
+// headerCarrier lets the propagator read and write the trace context in
+// record headers.
+type headerCarrier struct {
+	headers *[]kafka.Header
+}
+
+func (c headerCarrier) Get(key string) string {
+	v, _ := headerValue(*c.headers, key)
+	return v
+}
+
+func (c headerCarrier) Set(key, value string) {
+	for i, h := range *c.headers {
+		if h.Key == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, len(*c.headers))
+	for i, h := range *c.headers {
+		keys[i] = h.Key
+	}
+	return keys
+}
+
+func injectHeaders(ctx context.Context, headers []kafka.Header) []kafka.Header {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{&headers})
+	return headers
+}
+
+func extractHeaders(ctx context.Context, headers []kafka.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier{&headers})
+}
+
+func startPublishSpan(ctx context.Context, topic string, records int) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingBatchMessageCount(records),
+		),
+	)
+}
+
+// startProcessSpan continues the trace the record was published in.
+func startProcessSpan(ctx context.Context, m kafka.Message) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(extractHeaders(ctx, m.Headers), m.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingDestinationName(m.Topic),
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(m.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(m.Offset)),
+		),
+	)
+}
+
+func attemptAttribute(n int) attribute.KeyValue {
+	return attribute.Int("messaging.kafka.attempt", n)
+}
@@ -0,0 +1,66 @@
+package kafka_test
+
+import (
+	"context"
+	"messagio_testsuite/pkg/kafka"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMemoryBroker_PropagatesTraceContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	broker := kafka.NewMemoryBroker(1)
+	producer := kafka.NewMemoryProducer(broker, "messages")
+
+	ctx, request := provider.Tracer("test").Start(context.Background(), "request")
+	require.NoError(t, producer.Produce(ctx, kafka.NewEnvelope(uuid.New(), "message", time.Now())))
+	request.End()
+
+	messages := broker.Messages("messages")
+	require.Len(t, messages, 1)
+	require.NotEmpty(t, messages[0].Headers)
+	assert.Equal(t, "traceparent", messages[0].Headers[0].Key)
+
+	consumeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var handled trace.SpanContext
+	consumer := kafka.NewMemoryConsumer(broker, "group", "messages")
+	err := consumer.Consume(consumeCtx, func(handlerCtx context.Context, _ kafka.Envelope) error {
+		handled = trace.SpanContextFromContext(handlerCtx)
+		cancel()
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, request.SpanContext().TraceID(), handled.TraceID(), "the record is handled in the trace it was produced in")
+
+	var publish, process sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "messages publish":
+			publish = span
+		case "messages process":
+			process = span
+		}
+	}
+	require.NotNil(t, publish)
+	require.NotNil(t, process)
+	assert.Equal(t, trace.SpanKindConsumer, process.SpanKind())
+	assert.Equal(t, publish.SpanContext().SpanID(), process.Parent().SpanID())
+	assert.Equal(t, handled.SpanID(), process.SpanContext().SpanID())
+}
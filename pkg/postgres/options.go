@@ -1,6 +1,10 @@
 package postgres
 
-import "time"
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
 
 type Option func(*Postgres)
 
@@ -21,3 +25,11 @@ func ConnTimeout(timeout time.Duration) Option {
 		c.connTimeout = timeout
 	}
 }
+
+// Tracer is called for every query of the pool. If it also implements
+// pgx.CopyFromTracer, copies are traced too.
+func Tracer(tracer pgx.QueryTracer) Option {
+	return func(c *Postgres) {
+		c.tracer = tracer
+	}
+}
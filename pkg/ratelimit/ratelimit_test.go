@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	c := &clock{t: time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)}
+	l := newLimiter(2, 3, c.now)
+
+	for i := 2; i >= 0; i-- {
+		result := l.Allow("a")
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, i, result.Remaining)
+	}
+
+	result := l.Allow("a")
+	assert.False(t, result.Allowed, "the burst is spent")
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, result.Reset)
+
+	assert.True(t, l.Allow("b").Allowed, "keys have their own bucket")
+
+	c.t = c.t.Add(500 * time.Millisecond)
+	assert.True(t, l.Allow("a").Allowed, "a token was refilled")
+	assert.False(t, l.Allow("a").Allowed)
+
+	c.t = c.t.Add(time.Hour)
+	result = l.Allow("a")
+	assert.Equal(t, 2, result.Remaining, "refills stop at the burst")
+}
+
+func TestLimiter_SweepsFullBuckets(t *testing.T) {
+	c := &clock{t: time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)}
+	l := newLimiter(1, 2, c.now)
+
+	l.Allow("a")
+	l.Allow("b")
+	assert.Equal(t, 2, l.Len())
+
+	c.t = c.t.Add(sweepInterval)
+	l.Allow("c")
+	assert.Equal(t, 1, l.Len())
+}
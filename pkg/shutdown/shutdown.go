@@ -0,0 +1,63 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Phase struct {
+	Name string
+	Stop func(ctx context.Context) error
+}
+
+// Sequence stops components one phase at a time, in the order they were added.
+// All phases share a single deadline; a failing or timed out phase is logged
+// and the sequence moves on so that later resources are still released.
+type Sequence struct {
+	timeout time.Duration
+	phases  []Phase
+}
+
+func NewSequence(timeout time.Duration) *Sequence {
+	return &Sequence{timeout: timeout}
+}
+
+func (s *Sequence) Add(name string, stop func(ctx context.Context) error) {
+	s.phases = append(s.phases, Phase{Name: name, Stop: stop})
+}
+
+func (s *Sequence) Run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	var errs []error
+	for i, phase := range s.phases {
+		logrus.Infof("Shutdown phase %d/%d: %s...", i+1, len(s.phases), phase.Name)
+		start := time.Now()
+
+		if err := phase.Stop(ctx); err != nil {
+			logrus.Errorf("Shutdown phase %s failed after %s: %v", phase.Name, time.Since(start), err)
+			errs = append(errs, fmt.Errorf("%s: %w", phase.Name, err))
+			continue
+		}
+		logrus.Infof("Shutdown phase %s done in %s", phase.Name, time.Since(start))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Wait returns a Stop function that waits for done to be closed.
+func Wait(done <-chan struct{}) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
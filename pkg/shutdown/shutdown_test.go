@@ -0,0 +1,71 @@
+package shutdown_test
+
+import (
+	"context"
+	"errors"
+	"messagio_testsuite/pkg/shutdown"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequence_RunsPhasesInOrder(t *testing.T) {
+	var order []string
+	record := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+
+	s := shutdown.NewSequence(time.Second)
+	s.Add("http server", record("http server"))
+	s.Add("consumer", record("consumer"))
+	s.Add("producer", record("producer"))
+	s.Add("postgres", record("postgres"))
+
+	require.NoError(t, s.Run(context.Background()))
+	assert.Equal(t, []string{"http server", "consumer", "producer", "postgres"}, order)
+}
+
+func TestSequence_ContinuesAfterFailure(t *testing.T) {
+	closed := false
+	errBroken := errors.New("broken")
+
+	s := shutdown.NewSequence(time.Second)
+	s.Add("consumer", func(context.Context) error { return errBroken })
+	s.Add("postgres", func(context.Context) error {
+		closed = true
+		return nil
+	})
+
+	err := s.Run(context.Background())
+	assert.ErrorIs(t, err, errBroken)
+	assert.True(t, closed, "later phases still run")
+}
+
+func TestSequence_SharesDeadline(t *testing.T) {
+	never := make(chan struct{})
+	closed := false
+
+	s := shutdown.NewSequence(20 * time.Millisecond)
+	s.Add("consumer", shutdown.Wait(never))
+	s.Add("postgres", func(ctx context.Context) error {
+		closed = true
+		return ctx.Err()
+	})
+
+	start := time.Now()
+	err := s.Run(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, closed)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWait(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	assert.NoError(t, shutdown.Wait(done)(context.Background()))
+}